@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"database/sql"
 	"encoding/base64"
 	"fmt"
@@ -11,16 +10,15 @@ import (
 	"runtime"
 	"strconv"
 
-	"golang.org/x/crypto/acme/autocert"
-
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	mgzip "github.com/martini-contrib/gzip"
 	"github.com/martini-contrib/render"
+	"github.com/russross/gruffles/world"
 	"github.com/russross/meddler"
 )
 
-func setupAPI(db *sql.DB) *http.Server {
+func setupAPI(db *sql.DB, q Queue) http.Handler {
 	// set up martini
 	r := martini.NewRouter()
 	m := martini.New()
@@ -35,6 +33,8 @@ func setupAPI(db *sql.DB) *http.Server {
 		Prefix:      "play",
 	}))
 	m.Use(render.Renderer(render.Options{IndentJSON: true}))
+	m.Map(q)
+	m.Map(db)
 
 	withTx := func(c martini.Context, w http.ResponseWriter) {
 		// start a transaction
@@ -99,6 +99,12 @@ func setupAPI(db *sql.DB) *http.Server {
 			return
 		}
 
+		if user.Banned {
+			session.Delete(w)
+			loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is banned", user.ID, user.Username)
+			return
+		}
+
 		// map the current user to the request context
 		c.Map(user)
 	}
@@ -127,27 +133,50 @@ func setupAPI(db *sql.DB) *http.Server {
 	r.Get("/v1/users", auth, withTx, withCurrentUser, administratorOnly, GetUsers)
 	r.Get("/v1/users/:user_id", auth, withTx, withCurrentUser, administratorOnly, GetUser)
 	r.Get("/v1/users/me", auth, withTx, withCurrentUser, GetUserMe)
+	r.Put("/v1/users/me/password", auth, withTx, withCurrentUser, binding.Json(PasswordChange{}), ChangePassword)
+	r.Patch("/v1/users/:user_id", auth, withTx, withCurrentUser, administratorOnly, binding.Json(UserPatch{}), PatchUser)
+	r.Delete("/v1/users/:user_id", auth, withTx, withCurrentUser, administratorOnly, DeleteUser)
+	r.Post("/v1/users/:user_id/password_resets", auth, withTx, withCurrentUser, administratorOnly, CreatePasswordReset)
+	r.Post("/v1/password_resets", withTx, binding.Json(PasswordResetRequest{}), UsePasswordReset)
 
 	r.Post("/v1/sessions", withTx, binding.Json(User{}), CreateSession)
 
-	// set up letsencrypt
-	lem := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache(Config.LetsEncryptCache),
-		HostPolicy: autocert.HostWhitelist(Config.Hostname),
-		Email:      Config.LetsEncryptEmail,
+	// helps
+	r.Get("/v1/helps", auth, withTx, withCurrentUser, authorOnly, GetHelps)
+	r.Get("/v1/helps/:help_id", auth, withTx, withCurrentUser, authorOnly, GetHelp)
+	r.Post("/v1/helps", auth, withTx, withCurrentUser, authorOnly, binding.Json(world.Help{}), CreateHelp)
+	r.Put("/v1/helps/:help_id", auth, withTx, withCurrentUser, authorOnly, binding.Json(world.Help{}), UpdateHelp)
+
+	// areas
+	r.Get("/v1/areas", auth, withTx, withCurrentUser, authorOnly, GetAreas)
+	r.Get("/v1/areas/:area_id", auth, withTx, withCurrentUser, authorOnly, GetArea)
+	r.Post("/v1/areas", auth, withTx, withCurrentUser, authorOnly, binding.Json(world.Area{}), CreateArea)
+	r.Put("/v1/areas/:area_id", auth, withTx, withCurrentUser, authorOnly, binding.Json(world.Area{}), UpdateArea)
+	r.Delete("/v1/areas/:area_id", auth, withTx, withCurrentUser, authorOnly, DeleteArea)
+	r.Get("/v1/areas/:area_id/authors", auth, withTx, withCurrentUser, authorOnly, GetAreaAuthors)
+	r.Put("/v1/areas/:area_id/authors/:user_id", auth, withTx, withCurrentUser, administratorOnly, AddAreaAuthor)
+	r.Delete("/v1/areas/:area_id/authors/:user_id", auth, withTx, withCurrentUser, administratorOnly, RemoveAreaAuthor)
+
+	r.Get("/v1/players", auth, withTx, withCurrentUser, administratorOnly, GetPlayers)
+	r.Post("/v1/reloads", auth, withTx, withCurrentUser, administratorOnly, binding.Json(ReloadRequest{}), ReloadAreaHandler)
+
+	// rooms, mobiles, objects, resets, and doors
+	for _, res := range []*builderResource{builderRooms, builderMobiles, builderObjects, builderResets, builderDoors} {
+		plural, single := "/v1/"+res.table, "/v1/"+res.table+"/:"+res.param()
+		r.Get(plural, auth, withTx, withCurrentUser, authorOnly, res.List)
+		r.Get(single, auth, withTx, withCurrentUser, authorOnly, res.Get)
+		r.Post(plural, auth, withTx, withCurrentUser, authorOnly, res.Create)
+		r.Put(single, auth, withTx, withCurrentUser, authorOnly, res.Update)
+		r.Delete(single, auth, withTx, withCurrentUser, authorOnly, res.Delete)
 	}
 
-	// start the https server
-	return &http.Server{
-		Addr:    ":https",
-		Handler: m,
-		TLSConfig: &tls.Config{
-			PreferServerCipherSuites: true,
-			MinVersion:               tls.VersionTLS10,
-			GetCertificate:           lem.GetCertificate,
-		},
-	}
+	// player connections go to the websocket handler and everything else to martini
+	mux := http.NewServeMux()
+	mux.HandleFunc("/server", func(w http.ResponseWriter, r *http.Request) {
+		HandleIncommingConnection(w, r, db, q)
+	})
+	mux.Handle("/", m)
+	return mux
 }
 
 func loggedErrorf(f string, params ...interface{}) error {
@@ -202,7 +231,7 @@ func parseID(w http.ResponseWriter, name, s string) (int64, error) {
 		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing %s from URL: %v", name, err)
 	}
 	if id < 1 {
-		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "invalid ID in URL: %s must be 1 or greater", name, err)
+		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "invalid ID in URL: %s must be 1 or greater", name)
 	}
 
 	return id, nil
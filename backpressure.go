@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/martini-contrib/render"
+)
+
+// Flow control for player connections:
+//
+//   - commands are rate limited with a token bucket. Players who keep
+//     going after the bucket is empty are warned, and then disconnected.
+//   - some messages describe the current state of things, so only the
+//     latest one waiting to be sent is kept
+//   - a connection that falls maxPlayerOutgoingQueueLength messages behind
+//     is dropped. The player goes link-dead with the most recent messages
+//     waiting for them, just as if the connection had failed.
+//   - writes time out, and idle connections are pinged so dead ones are
+//     noticed
+const (
+	commandBurst        = 30
+	commandRate         = 10 // per second, once the burst is used up
+	commandWarnInterval = 5 * time.Second
+
+	// commands dropped, without a pause long enough to refill the bucket,
+	// before the player is disconnected
+	commandFloodLimit = 200
+
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	pongTimeout  = 2 * pingInterval
+)
+
+// only the latest of these is worth sending
+var coalescedMsgTypes = map[MsgType]bool{
+	MsgMap:       true,
+	MsgVitals:    true,
+	MsgStatus:    true,
+	MsgRoom:      true,
+	MsgInventory: true,
+	MsgSettings:  true,
+}
+
+// PlayerStats counts messages and commands for one player, including
+// what was held back or thrown away
+type PlayerStats struct {
+	Sent            int
+	Writes          int
+	Coalesced       int
+	Dropped         int
+	SlowDisconnects int
+	Commands        int
+	CommandsDropped int
+}
+
+// commandLimiter is a token bucket for one connection's requests
+type commandLimiter struct {
+	tokens  float64
+	last    time.Time
+	dropped int
+	warned  time.Time
+}
+
+func newCommandLimiter() *commandLimiter {
+	return &commandLimiter{tokens: commandBurst, last: time.Now()}
+}
+
+// allow reports whether a request can go ahead, whether to warn the
+// player that requests are being dropped, and whether they are flooding
+func (l *commandLimiter) allow(now time.Time) (ok, warn, flood bool) {
+	l.tokens += now.Sub(l.last).Seconds() * commandRate
+	l.last = now
+	if l.tokens >= commandBurst {
+		l.tokens = commandBurst
+		l.dropped = 0
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, false, false
+	}
+	l.dropped++
+	if now.Sub(l.warned) >= commandWarnInterval {
+		l.warned = now
+		warn = true
+	}
+	return false, warn, l.dropped >= commandFloodLimit
+}
+
+// Stats gives the player's counters so far
+func (p *Player) Stats() PlayerStats {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	return p.stats
+}
+
+type PlayerInfo struct {
+	Name      string
+	UserID    int64
+	Room      int
+	LinkDead  bool
+	Queued    int
+	Stats     PlayerStats
+	Recording string
+}
+
+// GetPlayers lists the players in the world with their connection stats
+// and recordings
+func GetPlayers(w http.ResponseWriter, q Queue, render render.Render) {
+	done := make(chan []*PlayerInfo)
+	q.Schedule(func(state *State) {
+		list := []*PlayerInfo{}
+		for _, mob := range state.Mobs {
+			player := mob.Player
+			if player == nil {
+				continue
+			}
+			info := &PlayerInfo{Name: mob.Name, UserID: mob.UserID}
+			if mob.Location != nil {
+				info.Room = mob.Location.Vnum
+			}
+			player.outgoingNotEmpty.L.Lock()
+			info.LinkDead = player.conn == nil
+			info.Queued = len(player.outgoingQueue)
+			info.Stats = player.stats
+			if player.recording != nil {
+				info.Recording = player.recording.path
+			}
+			player.outgoingNotEmpty.L.Unlock()
+			list = append(list, info)
+		}
+		done <- list
+	}, 0)
+	render.JSON(http.StatusOK, <-done)
+}
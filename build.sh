@@ -8,3 +8,8 @@ go install github.com/russross/gruffles
 echo installing gruffles server
 sudo mv $GOPATH/bin/gruffles /usr/local/bin/
 sudo setcap cap_net_bind_service=+ep /usr/local/bin/gruffles
+
+if pgrep -x gruffles > /dev/null; then
+    echo asking the running server to copyover to the new version
+    sudo pkill -USR2 -x gruffles
+fi
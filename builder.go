@@ -0,0 +1,598 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/russross/gruffles/world"
+	"github.com/russross/meddler"
+)
+
+// The builder API lets authors edit areas. Admins can edit anything, and
+// authors can edit the areas they have been assigned to in area_authors.
+// An author who creates an area is assigned to it automatically.
+
+// canEditArea reports (and logs) an error if the user cannot edit the area
+func canEditArea(w http.ResponseWriter, tx *sql.Tx, currentUser *User, areaID int) error {
+	if currentUser.Admin {
+		return nil
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM area_authors WHERE area_id = ? AND user_id = ?`,
+		areaID, currentUser.ID).Scan(&count); err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	}
+	if count == 0 {
+		return loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an author of area %d",
+			currentUser.ID, currentUser.Username, areaID)
+	}
+	return nil
+}
+
+// touchArea updates the modified time of an area when anything in it changes
+func touchArea(tx *sql.Tx, areaID int) error {
+	_, err := tx.Exec(`UPDATE areas SET modified_at = ? WHERE id = ?`, time.Now(), areaID)
+	return err
+}
+
+func rowExists(tx *sql.Tx, table string, id int) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(1) FROM `+table+` WHERE id = ?`, id).Scan(&count)
+	return count > 0, err
+}
+
+//
+// areas
+//
+
+func GetAreas(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	areas := []*world.Area{}
+	if err := meddler.QueryAll(tx, &areas, `SELECT * FROM areas ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, areas)
+}
+
+func GetArea(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	area := new(world.Area)
+	if err = meddler.Load(tx, "areas", area, areaID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, area)
+}
+
+func CreateArea(w http.ResponseWriter, tx *sql.Tx, area world.Area, currentUser *User, render render.Render) {
+	now := time.Now()
+	area.ID = 0
+	area.Name = strings.TrimSpace(area.Name)
+	if area.Name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "area name cannot be empty")
+		return
+	}
+	area.CreatedAt = now
+	area.ModifiedAt = now
+	if err := meddler.Insert(tx, "areas", &area); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO area_authors (area_id, user_id) VALUES (?, ?)`, area.ID, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, &area)
+}
+
+func UpdateArea(w http.ResponseWriter, tx *sql.Tx, params martini.Params, area world.Area, currentUser *User, render render.Render) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	old := new(world.Area)
+	if err = meddler.Load(tx, "areas", old, areaID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err = canEditArea(w, tx, currentUser, old.ID); err != nil {
+		return
+	}
+	old.Name = strings.TrimSpace(area.Name)
+	if old.Name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "area name cannot be empty")
+		return
+	}
+	old.ModifiedAt = time.Now()
+	if err = meddler.Update(tx, "areas", old); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, old)
+}
+
+func DeleteArea(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	if err = canEditArea(w, tx, currentUser, int(areaID)); err != nil {
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM areas WHERE id = ?`, areaID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		loggedHTTPDBNotFoundError(w, sql.ErrNoRows)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetAreaAuthors lists the users assigned to an area
+func GetAreaAuthors(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	users := []*User{}
+	if err = meddler.QueryAll(tx, &users, `SELECT users.* FROM users JOIN area_authors ON users.id = area_authors.user_id `+
+		`WHERE area_authors.area_id = ? ORDER BY users.id`, areaID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, users)
+}
+
+func AddAreaAuthor(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	if _, err = tx.Exec(`INSERT OR IGNORE INTO area_authors (area_id, user_id) VALUES (?, ?)`, areaID, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unable to add author: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func RemoveAreaAuthor(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	areaID, err := parseID(w, "area_id", params["area_id"])
+	if err != nil {
+		return
+	}
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	if _, err = tx.Exec(`DELETE FROM area_authors WHERE area_id = ? AND user_id = ?`, areaID, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//
+// rooms, mobiles, objects, resets, and doors all work the same way, so
+// each is described by a builderResource that supplies the parts that
+// differ
+//
+
+type builderResource struct {
+	// name used in messages and URLs, like "room" for /v1/rooms/:room_id
+	name  string
+	table string
+
+	// column used to filter lists, as in /v1/rooms?area_id=3
+	filter string
+
+	// new returns a pointer to a new element, and list returns a pointer
+	// to an empty slice of element pointers
+	new  func() interface{}
+	list func() interface{}
+
+	// id returns a pointer to the ID field of an element
+	id func(elt interface{}) *int
+
+	// area finds the ID of the area that an element belongs to
+	area func(tx *sql.Tx, elt interface{}) (int, error)
+
+	// check validates an element before it is saved, returning a
+	// description of the problem if there is one
+	check func(tx *sql.Tx, elt interface{}) (string, error)
+}
+
+func (res *builderResource) param() string {
+	return res.name + "_id"
+}
+
+func (res *builderResource) List(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	list := res.list()
+	var err error
+	if s := r.URL.Query().Get(res.filter); s != "" {
+		id, parseErr := strconv.Atoi(s)
+		if parseErr != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing %s: %v", res.filter, parseErr)
+			return
+		}
+		err = meddler.QueryAll(tx, list, `SELECT * FROM `+res.table+` WHERE `+res.filter+` = ? ORDER BY id`, id)
+	} else {
+		err = meddler.QueryAll(tx, list, `SELECT * FROM `+res.table+` ORDER BY id`)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, list)
+}
+
+func (res *builderResource) Get(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	id, err := parseID(w, res.param(), params[res.param()])
+	if err != nil {
+		return
+	}
+	elt := res.new()
+	if err = meddler.Load(tx, res.table, elt, id); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, elt)
+}
+
+func (res *builderResource) Create(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	elt := res.new()
+	if err := json.NewDecoder(r.Body).Decode(elt); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error decoding %s: %v", res.name, err)
+		return
+	}
+	*res.id(elt) = 0
+	if err := res.save(w, tx, currentUser, elt); err != nil {
+		return
+	}
+	if err := meddler.Insert(tx, res.table, elt); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, elt)
+}
+
+func (res *builderResource) Update(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	id, err := parseID(w, res.param(), params[res.param()])
+	if err != nil {
+		return
+	}
+
+	// the user must be able to edit the area the element is in now
+	old := res.new()
+	if err = meddler.Load(tx, res.table, old, id); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	areaID, err := res.area(tx, old)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err = canEditArea(w, tx, currentUser, areaID); err != nil {
+		return
+	}
+
+	// and the area it will be in afterward
+	elt := res.new()
+	if err = json.NewDecoder(r.Body).Decode(elt); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error decoding %s: %v", res.name, err)
+		return
+	}
+	*res.id(elt) = int(id)
+	if err = res.save(w, tx, currentUser, elt); err != nil {
+		return
+	}
+	if err = meddler.Update(tx, res.table, elt); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, elt)
+}
+
+func (res *builderResource) Delete(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	id, err := parseID(w, res.param(), params[res.param()])
+	if err != nil {
+		return
+	}
+	elt := res.new()
+	if err = meddler.Load(tx, res.table, elt, id); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	areaID, err := res.area(tx, elt)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err = canEditArea(w, tx, currentUser, areaID); err != nil {
+		return
+	}
+	if _, err = tx.Exec(`DELETE FROM `+res.table+` WHERE id = ?`, id); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err = touchArea(tx, areaID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// save checks ownership and validates an element that is about to be
+// inserted or updated
+func (res *builderResource) save(w http.ResponseWriter, tx *sql.Tx, currentUser *User, elt interface{}) error {
+	problem, err := res.check(tx, elt)
+	if err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	}
+	if problem != "" {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "invalid %s: %s", res.name, problem)
+	}
+	areaID, err := res.area(tx, elt)
+	if err == sql.ErrNoRows {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "invalid %s: area not found", res.name)
+	} else if err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	}
+	if exists, err := rowExists(tx, "areas", areaID); err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	} else if !exists {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "invalid %s: area %d not found", res.name, areaID)
+	}
+	if err := canEditArea(w, tx, currentUser, areaID); err != nil {
+		return err
+	}
+	if err := touchArea(tx, areaID); err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	}
+	return nil
+}
+
+// checkVnum makes sure a vnum is not already used by another element
+func checkVnum(tx *sql.Tx, table string, id, vnum int) (string, error) {
+	if vnum == 0 {
+		return "", nil
+	}
+	if vnum < 0 {
+		return "vnum must be positive", nil
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM `+table+` WHERE vnum = ? AND id != ?`, vnum, id).Scan(&count); err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return fmt.Sprintf("vnum %d is already in use", vnum), nil
+	}
+	return "", nil
+}
+
+// checkRefs makes sure that each non-zero ID refers to a row in its table.
+// References are given as table, name, id triples.
+func checkRefs(tx *sql.Tx, refs ...interface{}) (string, error) {
+	for i := 0; i+2 < len(refs); i += 3 {
+		table, name, id := refs[i].(string), refs[i+1].(string), refs[i+2].(int)
+		if id == 0 {
+			continue
+		}
+		exists, err := rowExists(tx, table, id)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return fmt.Sprintf("%s %d not found", name, id), nil
+		}
+	}
+	return "", nil
+}
+
+var builderRooms = &builderResource{
+	name:   "room",
+	table:  "rooms",
+	filter: "area_id",
+	new:    func() interface{} { return new(world.Room) },
+	list:   func() interface{} { return &[]*world.Room{} },
+	id:     func(elt interface{}) *int { return &elt.(*world.Room).ID },
+	area:   func(tx *sql.Tx, elt interface{}) (int, error) { return elt.(*world.Room).AreaID, nil },
+	check: func(tx *sql.Tx, elt interface{}) (string, error) {
+		room := elt.(*world.Room)
+		switch {
+		case strings.TrimSpace(room.Name) == "":
+			return "name cannot be empty", nil
+		case room.Terrain < 0 || room.Terrain >= len(world.Terrains):
+			return fmt.Sprintf("unknown terrain %d", room.Terrain), nil
+		}
+		if room.Extras == nil {
+			room.Extras = []world.RoomExtraDescription{}
+		}
+		return checkVnum(tx, "rooms", room.ID, room.Vnum)
+	},
+}
+
+var builderMobiles = &builderResource{
+	name:   "mobile",
+	table:  "mobiles",
+	filter: "area_id",
+	new:    func() interface{} { return new(world.Mobile) },
+	list:   func() interface{} { return &[]*world.Mobile{} },
+	id:     func(elt interface{}) *int { return &elt.(*world.Mobile).ID },
+	area:   func(tx *sql.Tx, elt interface{}) (int, error) { return elt.(*world.Mobile).AreaID, nil },
+	check: func(tx *sql.Tx, elt interface{}) (string, error) {
+		mob := elt.(*world.Mobile)
+		switch {
+		case len(mob.Keywords) == 0:
+			return "no keywords", nil
+		case mob.Alignment < -1000 || mob.Alignment > 1000:
+			return "alignment must be between -1000 and 1000", nil
+		case mob.Level < 0 || mob.Level > 100:
+			return "level must be between 0 and 100", nil
+		case !contains(world.Pronouns, mob.Pronouns):
+			return fmt.Sprintf("unknown pronouns %q", mob.Pronouns), nil
+		case !contains(world.Positions, mob.StartPosition):
+			return fmt.Sprintf("unknown start position %q", mob.StartPosition), nil
+		case !contains(world.Positions, mob.DefaultPosition):
+			return fmt.Sprintf("unknown default position %q", mob.DefaultPosition), nil
+		}
+		rolls := [][]int{mob.HitRoll, mob.DamageRoll, mob.DodgeRoll, mob.AbsorbRoll,
+			mob.FireRoll, mob.IceRoll, mob.PoisonRoll, mob.LightningRoll}
+		for _, roll := range rolls {
+			if len(roll) != 2 || roll[0] < 0 || roll[1] < 0 {
+				return "rolls must be a non-negative mean and standard deviation", nil
+			}
+		}
+		return checkVnum(tx, "mobiles", mob.ID, mob.Vnum)
+	},
+}
+
+var builderObjects = &builderResource{
+	name:   "object",
+	table:  "objects",
+	filter: "area_id",
+	new:    func() interface{} { return new(world.Object) },
+	list:   func() interface{} { return &[]*world.Object{} },
+	id:     func(elt interface{}) *int { return &elt.(*world.Object).ID },
+	area:   func(tx *sql.Tx, elt interface{}) (int, error) { return elt.(*world.Object).AreaID, nil },
+	check: func(tx *sql.Tx, elt interface{}) (string, error) {
+		obj := elt.(*world.Object)
+		if len(obj.Keywords) == 0 {
+			return "no keywords", nil
+		}
+		if obj.Extras == nil {
+			obj.Extras = []world.ObjectExtraDescription{}
+		}
+		if obj.Applies == nil {
+			obj.Applies = []world.ObjectApply{}
+		}
+		return checkVnum(tx, "objects", obj.ID, obj.Vnum)
+	},
+}
+
+var builderResets = &builderResource{
+	name:   "reset",
+	table:  "resets",
+	filter: "area_id",
+	new:    func() interface{} { return new(world.Reset) },
+	list:   func() interface{} { return &[]*world.Reset{} },
+	id:     func(elt interface{}) *int { return &elt.(*world.Reset).ID },
+	area:   func(tx *sql.Tx, elt interface{}) (int, error) { return elt.(*world.Reset).AreaID, nil },
+	check: func(tx *sql.Tx, elt interface{}) (string, error) {
+		reset := elt.(*world.Reset)
+		if _, exists := world.ResetTypes[reset.Type]; !exists {
+			return fmt.Sprintf("unknown reset type %q", reset.Type), nil
+		}
+		switch reset.Type {
+		case "M":
+			if reset.RoomID == 0 || reset.MobileID == 0 {
+				return "mobile resets need a room and a mobile", nil
+			}
+		case "O":
+			if reset.RoomID == 0 || reset.ObjectID == 0 {
+				return "object resets need a room and an object", nil
+			}
+		case "P":
+			if reset.ObjectID == 0 || reset.ContainerID == 0 {
+				return "put resets need an object and a container", nil
+			}
+		case "G", "E", "H":
+			if reset.ObjectID == 0 {
+				return "give, equip, and hide resets need an object", nil
+			}
+		case "D":
+			if reset.RoomID == 0 {
+				return "door resets need a room", nil
+			}
+			if reset.DoorState < world.DoorOpen || reset.DoorState > world.DoorLocked {
+				return "door state must be 0 (open), 1 (closed), or 2 (locked)", nil
+			}
+			var count int
+			if err := tx.QueryRow(`SELECT COUNT(1) FROM doors WHERE room_id = ? AND direction = ?`,
+				reset.RoomID, reset.DoorDirection).Scan(&count); err != nil {
+				return "", err
+			}
+			if count == 0 {
+				return fmt.Sprintf("room %d has no exit in direction %d", reset.RoomID, reset.DoorDirection), nil
+			}
+		case "R":
+			if reset.RoomID == 0 {
+				return "randomize resets need a room", nil
+			}
+			if reset.LastDoor < 0 || reset.LastDoor > len(world.Directions) {
+				return fmt.Sprintf("last door must be between 0 and %d", len(world.Directions)), nil
+			}
+		}
+		return checkRefs(tx,
+			"rooms", "room", reset.RoomID,
+			"mobiles", "mobile", reset.MobileID,
+			"objects", "object", reset.ObjectID,
+			"objects", "container", reset.ContainerID)
+	},
+}
+
+var builderDoors = &builderResource{
+	name:   "door",
+	table:  "doors",
+	filter: "room_id",
+	new:    func() interface{} { return new(world.Door) },
+	list:   func() interface{} { return &[]*world.Door{} },
+	id:     func(elt interface{}) *int { return &elt.(*world.Door).ID },
+
+	// a door belongs to the area of the room it leads from
+	area: func(tx *sql.Tx, elt interface{}) (int, error) {
+		var areaID int
+		err := tx.QueryRow(`SELECT area_id FROM rooms WHERE id = ?`, elt.(*world.Door).RoomID).Scan(&areaID)
+		return areaID, err
+	},
+	check: func(tx *sql.Tx, elt interface{}) (string, error) {
+		door := elt.(*world.Door)
+		if door.Direction < 0 || door.Direction >= len(world.Directions) {
+			return fmt.Sprintf("unknown direction %d", door.Direction), nil
+		}
+		if door.ToRoom == 0 {
+			return "exits must lead to a room", nil
+		}
+		if door.Keywords == nil {
+			door.Keywords = []string{}
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM doors WHERE room_id = ? AND direction = ? AND id != ?`,
+			door.RoomID, door.Direction, door.ID).Scan(&count); err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return fmt.Sprintf("room %d already has an exit %s", door.RoomID, world.Directions[door.Direction]), nil
+		}
+		return checkRefs(tx,
+			"rooms", "room", door.RoomID,
+			"rooms", "destination room", door.ToRoom,
+			"objects", "key", door.Key)
+	},
+}
+
+func contains(list []string, s string) bool {
+	for _, elt := range list {
+		if elt == s {
+			return true
+		}
+	}
+	return false
+}
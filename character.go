@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A signed-in player can have several characters, told apart by name.
+// Telnet players pick one as they sign in, and the browser client gets the
+// one played most recently. SaveCharacter and LoadCharacter in mob.go
+// store them.
+
+// characterChoice is the character a player picked when joining: a saved
+// one by ID, a new one by name, or neither for the one they played last
+type characterChoice struct {
+	ID   int64
+	Name string
+}
+
+// characterSummary is a saved character, as listed when choosing one
+type characterSummary struct {
+	ID      int64
+	Name    string
+	SavedAt time.Time
+}
+
+var characterName = regexp.MustCompile(`^[A-Za-z]{3,12}$`)
+
+// parseCharacterName checks a name for a new character and capitalizes it
+func parseCharacterName(name string) (string, error) {
+	if !characterName.MatchString(name) {
+		return "", errors.New("a name must be 3 to 12 letters")
+	}
+	return strings.ToUpper(name[:1]) + strings.ToLower(name[1:]), nil
+}
+
+// listCharacters gives a user's characters, most recently played first
+func listCharacters(db *sql.DB, userID int64) ([]*characterSummary, error) {
+	rows, err := db.Query(`SELECT id, name, saved_at FROM characters WHERE user_id = ? ORDER BY saved_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*characterSummary
+	for rows.Next() {
+		elt := new(characterSummary)
+		if err := rows.Scan(&elt.ID, &elt.Name, &elt.SavedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, elt)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCharacterName(t *testing.T) {
+	tests := []struct {
+		name, expected string
+		ok             bool
+	}{
+		{"gnoric", "Gnoric", true},
+		{"FIDO", "Fido", true},
+		{"Al", "", false},
+		{"Bilbo Baggins", "", false},
+		{"r2d2", "", false},
+		{"Abcdefghijklm", "", false},
+	}
+	for _, test := range tests {
+		got, err := parseCharacterName(test.name)
+		if (err == nil) != test.ok || got != test.expected {
+			t.Errorf("%q gave %q, %v", test.name, got, err)
+		}
+	}
+}
+
+// saveCharacters saves a character for user 7 for each name, each played
+// later than the one before
+func saveCharacters(t *testing.T, state *State, names ...string) []*Mob {
+	t.Helper()
+	var list []*Mob
+	for i, name := range names {
+		mob := &Mob{Name: name, Location: state.Rooms[1], StartLocation: state.Rooms[1], Player: newPlayer(), UserID: 7}
+		state.SaveCharacter(mob)
+		when := time.Date(2026, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if _, err := state.DB.Exec(`UPDATE characters SET saved_at = ? WHERE id = ?`, when, mob.CharacterID); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, mob)
+	}
+	return list
+}
+
+func TestListCharacters(t *testing.T) {
+	state := characterState(t)
+	if list, err := listCharacters(state.DB, 7); err != nil || len(list) != 0 {
+		t.Fatalf("listed %v, %v before any were saved", list, err)
+	}
+	mobs := saveCharacters(t, state, "Gnoric", "Fido")
+	list, err := listCharacters(state.DB, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "Fido" || list[0].ID != mobs[1].CharacterID || list[1].Name != "Gnoric" {
+		t.Errorf("listed %+v %+v, expected Fido then Gnoric", list[0], list[1])
+	}
+}
+
+func TestChooseCharacter(t *testing.T) {
+	state := characterState(t)
+	mobs := saveCharacters(t, state, "Gnoric", "Fido")
+	tests := []struct {
+		input    string
+		expected characterChoice
+	}{
+		{"1\r\n", characterChoice{ID: mobs[1].CharacterID}},
+		{"2\r\n", characterChoice{ID: mobs[0].CharacterID}},
+		{"gnoric\r\n", characterChoice{ID: mobs[0].CharacterID}},
+		{"bilbo\r\n", characterChoice{Name: "Bilbo"}},
+		{"3\r\nx\r\n2\r\n", characterChoice{ID: mobs[0].CharacterID}},
+	}
+	for _, test := range tests {
+		socket := newTelnetSocket(&scriptedConn{input: []byte(test.input)}, TelnetSettings{})
+		choice, err := socket.chooseCharacter(state.DB, 7)
+		if err != nil || choice != test.expected {
+			t.Errorf("%q chose %+v, %v, expected %+v", test.input, choice, err, test.expected)
+		}
+	}
+
+	socket := newTelnetSocket(&scriptedConn{input: []byte("0\r\n9\r\nx\r\n")}, TelnetSettings{})
+	if choice, err := socket.chooseCharacter(state.DB, 7); err == nil {
+		t.Errorf("chose %+v after only bad answers", choice)
+	}
+}
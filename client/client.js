@@ -18,7 +18,7 @@ jQuery(function ($) {
                         "type": "output",
                         "size": 45,
                         "id": "main",
-                        "exclude": "combat|social|map"
+                        "exclude": "combat|map"
                     },
                     {
                         "type": "input",
@@ -238,6 +238,33 @@ jQuery(function ($) {
         ctx.restore();
     };
 
+    var socket;
+    var settings = {};
+    var pingTimer;
+
+    // how long to wait before reconnecting, which grows while the server
+    // stays away
+    var retryDelay = 1000;
+
+    // see protocol.md
+    var send = function (msg) {
+        if (socket && socket.readyState === WebSocket.OPEN)
+            socket.send(JSON.stringify(msg));
+    };
+
+    // the server sizes the map to fit the map pane, at about 12 pixels
+    // per character
+    var sendResize = function () {
+        if (!map || !settings.protocol)
+            return;
+        send({
+            "type": "resize",
+            "width": Math.floor(map.clientWidth / 12),
+            "height": Math.floor(map.clientHeight / 12)
+        });
+    };
+    $(window).on('resize', sendResize);
+
     // create the layout
     var outputs = [];
     var input;
@@ -271,7 +298,8 @@ jQuery(function ($) {
                 sizes: sizes,
                 gutterSize: 8,
                 cursor: 'col-resize',
-                onDrag: renderMap
+                onDrag: renderMap,
+                onDragEnd: sendResize
             });
             break;
 
@@ -297,7 +325,8 @@ jQuery(function ($) {
                 sizes: sizes,
                 gutterSize: 8,
                 cursor: 'row-resize',
-                onDrag: renderMap
+                onDrag: renderMap,
+                onDragEnd: sendResize
             });
             break;
 
@@ -338,7 +367,7 @@ jQuery(function ($) {
         $(input).terminal(function (command) {
             if (command.trim() === '')
                 return;
-            socket.send(JSON.stringify({"cmd": command}));
+            socket.send(JSON.stringify({"type": "cmd", "cmd": command}));
         }, {
             greetings: 'Welcome to ' + document.location.hostname + ':' + document.location.port,
             name: 'gruffles input',
@@ -378,15 +407,38 @@ jQuery(function ($) {
         outputs[i] = $elt;
     }
 
-    var url = 'wss://' + document.location.hostname + ':' + document.location.port + '/server';
-    console.log("connecting to " + url);
-    var socket = new WebSocket(url);
-    socket.onerror = function (event) {
-        console.log("websocket error", event);
-    };
-    socket.onmessage = function (event) {
-        // write the message to all applicable output panes
-        var data = JSON.parse(event.data);
+    var scheme = document.location.protocol === 'https:' ? 'wss://' : 'ws://';
+    var url = scheme + document.location.host + '/server';
+    var gameData = {};
+
+    // write the message to all applicable output panes
+    var receive = function (data) {
+        if (data.type === 'hello') {
+            send({"type": "hello", "protocol": 1, "capabilities": ["batch", "data", "ping", "resize", "settings"]});
+
+            // keep proxies from closing an idle connection
+            clearInterval(pingTimer);
+            pingTimer = setInterval(function () {
+                send({"type": "ping", "id": String(Date.now())});
+            }, 30000);
+            return;
+        }
+        if (data.type === 'settings') {
+            var first = !settings.protocol;
+            settings = data.data;
+            if (first)
+                sendResize();
+            return;
+        }
+        if (data.type === 'pong') {
+            console.log("round trip", Date.now() - parseInt(data.data.id, 10), "ms");
+            return;
+        }
+        if (data.data !== undefined) {
+            // vitals, status, room, and channel data
+            gameData[data.type] = data.data;
+            return;
+        }
         if (data.type === 'map') {
             mapText = data.msg;
             renderMap();
@@ -403,4 +455,39 @@ jQuery(function ($) {
         }
     };
 
+    var connect = function () {
+        console.log("connecting to " + url);
+        socket = new WebSocket(url);
+        socket.onopen = function () {
+            retryDelay = 1000;
+        };
+        socket.onerror = function (event) {
+            console.log("websocket error", event);
+        };
+        socket.onclose = function (event) {
+            console.log("websocket closed", event.code, event.reason);
+            clearInterval(pingTimer);
+            settings = {};
+
+            // 1000 means another connection took over and 1008 means the
+            // server sent us away. Otherwise the server is restarting or the
+            // network dropped: come back, and the server puts a signed-in
+            // player back in their character.
+            if (event.code === 1000 || event.code === 1008)
+                return;
+            receive({"type": "error", "msg": "Disconnected. Reconnecting in " + retryDelay / 1000 + " seconds...\n"});
+            setTimeout(connect, retryDelay);
+            retryDelay = Math.min(retryDelay * 2, 30000);
+        };
+        socket.onmessage = function (event) {
+            // a frame holds one message or a batch of them
+            var data = JSON.parse(event.data);
+            if (!Array.isArray(data))
+                data = [data];
+            for (var i = 0; i < data.length; i++)
+                receive(data[i]);
+        };
+    };
+    connect();
+
 });
@@ -17,20 +17,81 @@ type Command struct {
 	Command string
 	Execute func(state *State, mob *Mob, cmd string) time.Duration
 	Fast    bool
+	Aliases []string
+
+	// only for authors and admins
+	Builder bool
+
+	// only for admins
+	Admin bool
+
+	// for the generated help entry
+	Usage string
+	Help  string
 }
 
 var Commands map[string]*Command
 
+// allowed reports whether a player can use a command. builder is nil for
+// players who are not signed in as an author or admin.
+func (cmd *Command) allowed(builder *Builder) bool {
+	return !(cmd.Builder && builder == nil || cmd.Admin && (builder == nil || !builder.User.Admin))
+}
+
 func SetupCommands() {
 	Commands = make(map[string]*Command)
-	addCommand(&Command{Command: "look", Execute: CmdLook, Fast: true}, []string{"l"})
-	addCommand(&Command{Command: "north", Execute: CmdNorth, Fast: false}, []string{"n"})
-	addCommand(&Command{Command: "east", Execute: CmdEast, Fast: false}, []string{"e"})
-	addCommand(&Command{Command: "south", Execute: CmdSouth, Fast: false}, []string{"s"})
-	addCommand(&Command{Command: "west", Execute: CmdWest, Fast: false}, []string{"w"})
-	addCommand(&Command{Command: "up", Execute: CmdUp, Fast: false}, []string{"u"})
-	addCommand(&Command{Command: "down", Execute: CmdDown, Fast: false}, []string{"d"})
-	addCommand(&Command{Command: "recall", Execute: CmdRecall, Fast: false}, nil)
+	addCommand(&Command{Command: "look", Execute: CmdLook, Fast: true,
+		Help: "Describe your surroundings and show the map."}, []string{"l"})
+	addCommand(&Command{Command: "north", Execute: CmdNorth, Fast: false,
+		Help: "Walk north."}, []string{"n"})
+	addCommand(&Command{Command: "east", Execute: CmdEast, Fast: false,
+		Help: "Walk east."}, []string{"e"})
+	addCommand(&Command{Command: "south", Execute: CmdSouth, Fast: false,
+		Help: "Walk south."}, []string{"s"})
+	addCommand(&Command{Command: "west", Execute: CmdWest, Fast: false,
+		Help: "Walk west."}, []string{"w"})
+	addCommand(&Command{Command: "up", Execute: CmdUp, Fast: false,
+		Help: "Climb up."}, []string{"u"})
+	addCommand(&Command{Command: "down", Execute: CmdDown, Fast: false,
+		Help: "Climb down."}, []string{"d"})
+	addCommand(&Command{Command: "recall", Execute: CmdRecall, Fast: false,
+		Help: "Return to the temple."}, nil)
+	addCommand(&Command{Command: "say", Execute: CmdSay, Fast: true, Usage: "<message>",
+		Help: "Say something to everyone in the room."}, nil)
+	addCommand(&Command{Command: "record", Execute: CmdRecord, Fast: true, Usage: "[on|off]",
+		Help: "Record your sessions, so problems you report can be played back."}, nil)
+	addCommand(&Command{Command: "help", Execute: CmdHelp, Fast: true, Usage: "[<topic>]",
+		Help: "Read about a topic or command.\nA topic can be abbreviated, as in help sa for say."}, []string{"?"})
+
+	// online creation
+	addCommand(&Command{Command: "redit", Execute: CmdRedit, Fast: true, Builder: true,
+		Usage: "[show|name|desc|desc+|terrain|flag|exit] ...",
+		Help:  "Edit the room you are in.\nredit exit <direction> <vnum> links to a room, and redit exit <direction> delete removes an exit."}, nil)
+	addCommand(&Command{Command: "dig", Execute: CmdDig, Fast: true, Builder: true, Usage: "<direction> [<vnum>]",
+		Help: "Create a room in a direction, link it both ways, and go there."}, nil)
+	addCommand(&Command{Command: "medit", Execute: CmdMedit, Fast: true, Builder: true,
+		Usage: "<vnum>|create <vnum>|<field> <value>",
+		Help:  "Select, create, or edit a mobile.\nFields are keywords, short, long, desc, desc+, level, alignment, gold, pronouns, act, and affect."}, nil)
+	addCommand(&Command{Command: "oedit", Execute: CmdOedit, Fast: true, Builder: true,
+		Usage: "<vnum>|create <vnum>|<field> <value>",
+		Help:  "Select, create, or edit an object.\nFields are keywords, short, long, type, weight, cost, value, extra, and wear."}, nil)
+	addCommand(&Command{Command: "resetedit", Execute: CmdResetedit, Fast: true, Builder: true,
+		Usage: "[show|mob <vnum> [<max>]|obj <vnum> [<max>]|give <vnum>|delete <number>]",
+		Help:  "List or change the resets for the area you are in.\nmob and obj resets are placed in the room you are in."}, nil)
+	addCommand(&Command{Command: "asave", Execute: CmdAsave, Fast: true, Builder: true,
+		Help: "Save your changed areas to the database."}, nil)
+
+	addCommand(&Command{Command: "shutdown", Execute: CmdShutdown, Fast: true, Admin: true,
+		Usage: "[<seconds>|now|cancel] [<reason>]",
+		Help:  "Warn players and shut the server down after a countdown."}, nil)
+	addCommand(&Command{Command: "reboot", Execute: CmdReboot, Fast: true, Admin: true,
+		Usage: "[<seconds>|now|cancel] [<reason>]",
+		Help:  "Warn players, shut the server down after a countdown, and start it again."}, nil)
+	addCommand(&Command{Command: "copyover", Execute: CmdCopyover, Fast: true, Admin: true,
+		Usage: "[<seconds>|now|cancel] [<reason>]",
+		Help:  "Restart the server with the latest binary after a countdown, keeping players connected. Only in http mode; use reboot in the TLS modes."}, nil)
+
+	setupCommandHelps()
 }
 
 func ParseCommand(input string) (*Command, string) {
@@ -57,6 +118,7 @@ func ParseCommand(input string) (*Command, string) {
 }
 
 func addCommand(cmd *Command, aliases []string) {
+	cmd.Aliases = aliases
 	Commands[cmd.Command] = cmd
 	for _, alias := range aliases {
 		Commands[alias] = cmd
@@ -0,0 +1,332 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const defaultConfigFile = "/etc/gruffles/config.json"
+
+// ServerConfig is loaded from a JSON file. Any field can be overridden
+// by an environment variable named after its JSON name, so letsEncryptEmail
+// is set by GRUFFLES_LETS_ENCRYPT_EMAIL.
+type ServerConfig struct {
+	Hostname         string `json:"hostname"`
+	Database         string `json:"database"`
+	LetsEncryptCache string `json:"letsEncryptCache"`
+	LetsEncryptEmail string `json:"letsEncryptEmail"`
+	ClientDir        string `json:"clientDir"`
+	SessionSecret    string `json:"sessionSecret"`
+	SessionSeconds   int    `json:"sessionSeconds"`
+	CookieName       string `json:"cookieName"`
+
+	// see server.go for the modes
+	Mode         string `json:"mode"`
+	HTTPAddress  string `json:"httpAddress"`
+	HTTPSAddress string `json:"httpsAddress"`
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+
+	// where to listen for telnet clients, such as ":4000", or "" for none.
+	// Telnet is not encrypted, so passwords cross the network in the clear.
+	TelnetAddress string `json:"telnetAddress"`
+
+	// where session recordings are written
+	RecordingsDir string `json:"recordingsDir"`
+
+	// addresses of reverse proxies whose X-Forwarded-For and X-Real-IP
+	// headers are believed, as a comma-separated list of IPs and CIDR
+	// ranges. Headers from anywhere else are ignored.
+	TrustedProxies string `json:"trustedProxies"`
+}
+
+var Config ServerConfig
+
+// the session secret is stored in base64 and must decode to at least this
+// many bytes
+const minSessionSecret = 32
+
+func defaultConfig() ServerConfig {
+	home := os.Getenv("HOME")
+	config := ServerConfig{
+		Database:         filepath.Join(home, "gruffles.db"),
+		RecordingsDir:    filepath.Join(home, "gruffles-recordings"),
+		ClientDir:        filepath.Join(home, "src/github.com/russross/gruffles/client"),
+		LetsEncryptCache: "/etc/gruffles",
+		SessionSeconds:   90*24*60*60 - 3*60*60,
+		CookieName:       "gruffles",
+		Mode:             ModeAutocert,
+		HTTPAddress:      ":http",
+		HTTPSAddress:     ":https",
+	}
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		config.ClientDir = filepath.Join(gopath, "src/github.com/russross/gruffles/client")
+	}
+	return config
+}
+
+// LoadConfig reads a config file on top of the defaults, applies
+// environment overrides, and checks the result. A missing file is only an
+// error if required is set, so a server can be configured entirely from
+// the environment.
+func LoadConfig(path string, required bool) (ServerConfig, error) {
+	config, err := readConfig(path, required)
+	if err != nil {
+		return config, err
+	}
+	config.SessionSecret = unBase64(config.SessionSecret)
+	if problems := config.Validate(); len(problems) > 0 {
+		return config, fmt.Errorf("invalid config:\n    %s", strings.Join(problems, "\n    "))
+	}
+	return config, nil
+}
+
+// readConfig reads a config file on top of the defaults and applies
+// environment overrides, without checking the result
+func readConfig(path string, required bool) (ServerConfig, error) {
+	config := defaultConfig()
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return config, fmt.Errorf("parsing config file %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !required:
+		log.Printf("no config file found at %s, using defaults and environment", path)
+	default:
+		return config, fmt.Errorf("loading config file: %v", err)
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// configuredDatabase gives the database the server would use, as the
+// default for subcommands that work on it
+func configuredDatabase() string {
+	config, err := readConfig(defaultConfigFile, false)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return config.Database
+}
+
+// parseAddressList parses a comma-separated list of IPs and CIDR ranges
+func parseAddressList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, elt := range strings.Split(list, ",") {
+		elt = strings.TrimSpace(elt)
+		if elt == "" {
+			continue
+		}
+		if !strings.Contains(elt, "/") {
+			ip := net.ParseIP(elt)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", elt)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(elt)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", elt)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// envName gives the environment variable for a JSON field name
+func envName(name string) string {
+	var out []rune
+	for i, ch := range name {
+		if unicode.IsUpper(ch) && i > 0 {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToUpper(ch))
+	}
+	return "GRUFFLES_" + string(out)
+}
+
+func (config *ServerConfig) applyEnv() error {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := envName(t.Field(i).Tag.Get("json"))
+		value, present := os.LookupEnv(name)
+		if !present {
+			continue
+		}
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("environment variable %s must be a number, found %q", name, value)
+			}
+			field.SetInt(int64(n))
+		default:
+			return fmt.Errorf("environment variable %s: unsupported field type %v", name, field.Kind())
+		}
+	}
+	return nil
+}
+
+// Validate checks the config and returns a list of problems, each naming
+// the field involved
+func (config *ServerConfig) Validate() []string {
+	var problems []string
+	bad := func(field, format string, params ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, params...))
+	}
+
+	if config.Database == "" {
+		bad("database", "must be set")
+	}
+	if config.RecordingsDir == "" {
+		bad("recordingsDir", "must be set")
+	}
+	if config.ClientDir == "" {
+		bad("clientDir", "must be set")
+	} else if info, err := os.Stat(config.ClientDir); err != nil || !info.IsDir() {
+		bad("clientDir", "%q is not a directory", config.ClientDir)
+	}
+	if config.SessionSecret == "" {
+		bad("sessionSecret", "must be set: use %s init-config to generate one", os.Args[0])
+	} else if len(config.SessionSecret) < minSessionSecret {
+		bad("sessionSecret", "must be at least %d bytes (after base64 decoding), found %d", minSessionSecret, len(config.SessionSecret))
+	}
+	if config.SessionSeconds <= 0 {
+		bad("sessionSeconds", "must be greater than zero, found %d", config.SessionSeconds)
+	}
+	if config.CookieName == "" {
+		bad("cookieName", "must be set")
+	} else if strings.ContainsAny(config.CookieName, " \t\r\n=;,\"") {
+		bad("cookieName", "%q is not a valid cookie name", config.CookieName)
+	}
+
+	if _, err := parseAddressList(config.TrustedProxies); err != nil {
+		bad("trustedProxies", "%v", err)
+	}
+
+	switch config.Mode {
+	case ModeAutocert:
+		if config.Hostname == "" {
+			bad("hostname", "must be set when mode is %s", config.Mode)
+		}
+		if config.LetsEncryptEmail == "" {
+			bad("letsEncryptEmail", "must be set when mode is %s", config.Mode)
+		}
+		if config.LetsEncryptCache == "" {
+			bad("letsEncryptCache", "must be set when mode is %s", config.Mode)
+		}
+		if config.HTTPSAddress == "" {
+			bad("httpsAddress", "must be set when mode is %s", config.Mode)
+		}
+	case ModeTLS:
+		if config.CertFile == "" {
+			bad("certFile", "must be set when mode is %s", config.Mode)
+		}
+		if config.KeyFile == "" {
+			bad("keyFile", "must be set when mode is %s", config.Mode)
+		}
+		if config.HTTPSAddress == "" {
+			bad("httpsAddress", "must be set when mode is %s", config.Mode)
+		}
+	case ModeSelfSigned:
+		if config.HTTPSAddress == "" {
+			bad("httpsAddress", "must be set when mode is %s", config.Mode)
+		}
+	case ModeHTTP:
+		if config.HTTPAddress == "" {
+			bad("httpAddress", "must be set when mode is %s", config.Mode)
+		}
+	default:
+		bad("mode", "must be %s, %s, %s, or %s, found %q", ModeAutocert, ModeTLS, ModeSelfSigned, ModeHTTP, config.Mode)
+	}
+
+	return problems
+}
+
+// cmdInitConfig writes a new config file with the defaults and a random
+// session secret
+func cmdInitConfig(args []string) {
+	flags := flag.NewFlagSet("init-config", flag.ExitOnError)
+	mode := flags.String("mode", ModeAutocert, "server mode: autocert, tls, selfsigned, or http")
+	hostname := flags.String("hostname", "", "public hostname of the server")
+	email := flags.String("email", "", "contact email for Let's Encrypt")
+	force := flags.Bool("force", false, "overwrite an existing file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s init-config [-mode m] [-hostname h] [-email e] [-force] [<config file>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  with no file, writes %s\n", defaultConfigFile)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	path := defaultConfigFile
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		path = flags.Arg(0)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	secret := make([]byte, 64)
+	if _, err := crand.Read(secret); err != nil {
+		log.Fatalf("generating session secret: %v", err)
+	}
+	config := defaultConfig()
+	config.Mode = *mode
+	config.Hostname = *hostname
+	config.LetsEncryptEmail = *email
+	config.SessionSecret = base64.StdEncoding.EncodeToString(secret)
+	raw, err := json.MarshalIndent(&config, "", "    ")
+	if err != nil {
+		log.Fatalf("encoding config: %v", err)
+	}
+	raw = append(raw, '\n')
+
+	// the file holds a secret, so only the owner can read it
+	openFlags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		openFlags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	fp, err := os.OpenFile(path, openFlags, 0600)
+	if err != nil {
+		log.Fatalf("creating config file: %v", err)
+	}
+	if _, err := fp.Write(raw); err != nil {
+		fp.Close()
+		log.Fatalf("writing config file: %v", err)
+	}
+	if err := fp.Close(); err != nil {
+		log.Fatalf("closing config file: %v", err)
+	}
+	log.Printf("wrote %s", path)
+
+	decoded := config
+	decoded.SessionSecret = unBase64(decoded.SessionSecret)
+	for _, problem := range decoded.Validate() {
+		log.Printf("you will need to fix this before starting the server: %s", problem)
+	}
+}
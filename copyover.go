@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/russross/gruffles/world"
+)
+
+// Copyover replaces the running server with a fresh copy of the binary
+// without disconnecting players. The old process writes the world and the
+// players' mobs to a file and execs the new binary, which inherits the
+// listening sockets and the players' connections and picks up where the
+// old one left off.
+//
+// Only plain TCP connections can be handed over, since the keys for a TLS
+// connection cannot leave the process. Copyover is therefore only offered
+// in http mode, where a proxy in front of the server handles TLS. In the
+// other modes, use reboot: the browser client reconnects on its own and
+// signed-in players pick up their characters if they are still in the
+// world.
+//
+// Input is handed over too. Sockets stop passing input to their readers
+// between commands, and whatever the client sent that the old process had
+// not read yet is replayed in the new one.
+
+// the new process finds the state file through this environment variable
+const copyoverEnv = "GRUFFLES_COPYOVER"
+
+// how long to wait for each player's queued messages to be delivered
+const copyoverDetachTime = 2 * time.Second
+
+var (
+	errNoHandoff = errors.New("only plain TCP connections can be handed over")
+	errLinkDead  = errors.New("player is link-dead")
+	errDetaching = errors.New("connection is being handed over")
+)
+
+// checkCopyover reports why a copyover cannot be done in this mode, or nil
+func checkCopyover() error {
+	if Config.Mode != ModeHTTP {
+		return fmt.Errorf("copyover only works in %s mode, since the encryption for each connection in %s mode "+
+			"cannot be handed to a new process and every browser player would be disconnected; use reboot instead",
+			ModeHTTP, Config.Mode)
+	}
+	return nil
+}
+
+type copyoverState struct {
+	Areas     []*world.AreaFile
+	Changed   []string
+	Listeners map[string]uintptr
+	Players   []*copyoverPlayer
+
+	// kept open until exec
+	files []*os.File
+}
+
+// copyoverPlayer is a player with a connection to hand over, or a
+// link-dead player with the messages waiting for them
+type copyoverPlayer struct {
+	FD       uintptr
+	LinkDead bool
+	Queue    []Msg
+	UserID   int64
+	Mob      *savedMob
+
+	// the saved character the mob is, or zero
+	CharacterID int64
+
+	// the connection's settings, or nil from a server that did not save them
+	Client *ClientSettings
+
+	// set for telnet connections
+	Telnet *TelnetSettings
+
+	// the websocket extensions the client offered, such as compression
+	Extensions string
+
+	// the session recording to carry on with, if any
+	Recording    string
+	RecordForced bool
+
+	// what the client sent that the old process had not handled
+	Input []byte
+}
+
+// savedMob is a mob with rooms recorded by vnum. Everything tied to the
+// old process is cleared.
+type savedMob struct {
+	Mob
+	Location      int
+	StartLocation int
+	Visited       []int
+}
+
+func saveMob(state *State, mob *Mob) *savedMob {
+	saved := &savedMob{Mob: *mob}
+	saved.Mob.Location, saved.Mob.StartLocation, saved.Mob.Visited = nil, nil, nil
+	saved.Mob.Opponent, saved.Mob.Controller, saved.Mob.Player, saved.Mob.Builder = nil, nil, nil, nil
+	if mob.Location != nil {
+		saved.Location = mob.Location.Vnum
+	}
+	if mob.StartLocation != nil {
+		saved.StartLocation = mob.StartLocation.Vnum
+	}
+	for id, seen := range mob.Visited {
+		if seen && id < len(state.Rooms) && state.Rooms[id] != nil {
+			saved.Visited = append(saved.Visited, state.Rooms[id].Vnum)
+		}
+	}
+	return saved
+}
+
+func (saved *savedMob) restore(state *State) *Mob {
+	mob := saved.Mob
+	recall := state.RoomByVnum(RecallLocation)
+	if mob.Location = state.RoomByVnum(saved.Location); mob.Location == nil {
+		mob.Location = recall
+	}
+	if mob.StartLocation = state.RoomByVnum(saved.StartLocation); mob.StartLocation == nil {
+		mob.StartLocation = recall
+	}
+	mob.Visited = make([]bool, len(state.Rooms))
+	for _, vnum := range saved.Visited {
+		if room := state.RoomByVnum(vnum); room != nil {
+			mob.Visited[room.ID] = true
+		}
+	}
+
+	// queued commands were scheduled in the old process
+	now := time.Now()
+	mob.SlowQueue, mob.SlowPending, mob.SlowBlockedUntil = nil, false, now
+	mob.FastQueue, mob.FastPending, mob.FastBlockedUntil = nil, false, now
+	return &mob
+}
+
+// prepareCopyover saves the world and detaches the players. It runs in
+// the event loop as part of the shutdown.
+func (state *State) prepareCopyover() *copyoverState {
+	handoff := &copyoverState{
+		Areas:     liveAreaFiles(state.Areas),
+		Listeners: make(map[string]uintptr),
+	}
+	for area := range state.Changed {
+		handoff.Changed = append(handoff.Changed, area.Name)
+	}
+
+	type detached struct {
+		mob  *Mob
+		file *os.File
+		conn *playerConn
+		err  error
+	}
+	results := make(chan detached)
+	count := 0
+	for _, mob := range state.Mobs {
+		if mob.Player == nil {
+			continue
+		}
+		count++
+		go func(mob *Mob) {
+			file, conn, err := mob.Player.Detach()
+			results <- detached{mob: mob, file: file, conn: conn, err: err}
+		}(mob)
+	}
+	for i := 0; i < count; i++ {
+		elt := <-results
+		if elt.err == errLinkDead {
+			player := elt.mob.Player
+			player.outgoingNotEmpty.L.Lock()
+			queue := append([]Msg{}, player.outgoingQueue...)
+			player.outgoingNotEmpty.L.Unlock()
+			handoff.Players = append(handoff.Players, &copyoverPlayer{
+				LinkDead:     true,
+				Queue:        queue,
+				UserID:       elt.mob.UserID,
+				CharacterID:  elt.mob.CharacterID,
+				Mob:          saveMob(state, elt.mob),
+				Recording:    player.handOffRecording(),
+				RecordForced: player.recordForced,
+			})
+			continue
+		}
+		if elt.err != nil {
+			log.Printf("copyover: keeping %s (user %d) failed: %v", elt.mob.Name, elt.mob.UserID, elt.err)
+			elt.mob.Player.Close(websocket.CloseServiceRestart, "server rebooting")
+			continue
+		}
+		handoff.files = append(handoff.files, elt.file)
+		saved := &copyoverPlayer{
+			FD:           elt.file.Fd(),
+			UserID:       elt.mob.UserID,
+			CharacterID:  elt.mob.CharacterID,
+			Mob:          saveMob(state, elt.mob),
+			Client:       &elt.conn.client,
+			Recording:    elt.mob.Player.handOffRecording(),
+			RecordForced: elt.mob.Player.recordForced,
+			Input:        elt.conn.socket.Unread(),
+		}
+		switch socket := elt.conn.socket.(type) {
+		case *telnetSocket:
+			saved.Telnet = socket.Settings()
+		case wsSocket:
+			saved.Extensions = socket.extensions
+		}
+		handoff.Players = append(handoff.Players, saved)
+	}
+	log.Printf("copyover: handing over %d of %d players", len(handoff.Players), count)
+	return handoff
+}
+
+// Detach stops serving a player without closing the connection, after
+// delivering any queued messages, and returns a copy of the connection
+func (p *Player) Detach() (*os.File, *playerConn, error) {
+	p.outgoingNotEmpty.L.Lock()
+	conn := p.conn
+	if conn == nil && p.outgoingQueue != nil && !p.closing {
+		p.outgoingNotEmpty.L.Unlock()
+		return nil, nil, errLinkDead
+	}
+	if conn == nil || p.closing {
+		p.outgoingNotEmpty.L.Unlock()
+		return nil, nil, errors.New("connection is closed")
+	}
+	tcp, ok := tcpConn(conn.socket.NetConn())
+	if !ok {
+		p.outgoingNotEmpty.L.Unlock()
+		return nil, nil, errNoHandoff
+	}
+	p.detaching = true
+	p.outgoingNotEmpty.Broadcast()
+	p.outgoingNotEmpty.L.Unlock()
+
+	timeout := time.After(copyoverDetachTime)
+	select {
+	case <-conn.detached:
+	case <-timeout:
+		return nil, nil, errors.New("timed out delivering messages")
+	}
+
+	// stop the reader between commands, and interrupt it if it is waiting.
+	// A pong can push the deadline back, so keep at it.
+	conn.socket.StopReading()
+	for stopped := false; !stopped; {
+		conn.socket.NetConn().SetReadDeadline(time.Now())
+		select {
+		case <-conn.readerDone:
+			stopped = true
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			return nil, nil, errors.New("timed out stopping the reader")
+		}
+	}
+	file, err := tcp.File()
+	return file, conn, err
+}
+
+// addListeners records the listening sockets. They are copied before the
+// servers shut down so they stay open for the new process.
+func (handoff *copyoverState) addListeners() {
+	for addr, listener := range activeListeners {
+		file, err := listener.File()
+		if err != nil {
+			log.Printf("copyover: listener on %s: %v", addr, err)
+			continue
+		}
+		handoff.files = append(handoff.files, file)
+		handoff.Listeners[addr] = file.Fd()
+	}
+}
+
+// exec starts the new binary in place of this process. It only returns
+// if something went wrong.
+func (handoff *copyoverState) exec() error {
+	for _, file := range handoff.files {
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), syscall.F_SETFD, 0); errno != 0 {
+			return fmt.Errorf("keeping %s open: %v", file.Name(), errno)
+		}
+	}
+	raw, err := json.Marshal(handoff)
+	if err != nil {
+		return err
+	}
+	fp, err := ioutil.TempFile("", "gruffles-copyover")
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(raw); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	os.Setenv(copyoverEnv, fp.Name())
+	return reexec()
+}
+
+// loadCopyover reads the state left by the old process, or returns nil if
+// this is a normal start
+func loadCopyover() (*copyoverState, error) {
+	path := os.Getenv(copyoverEnv)
+	if path == "" {
+		return nil, nil
+	}
+	os.Unsetenv(copyoverEnv)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+	handoff := new(copyoverState)
+	if err := json.Unmarshal(raw, handoff); err != nil {
+		return nil, err
+	}
+	for addr, fd := range handoff.Listeners {
+		inheritedListeners[addr] = os.NewFile(fd, "listener "+addr)
+	}
+	return handoff, nil
+}
+
+// world rebuilds the world as it was in the old process
+func (handoff *copyoverState) world() ([]*world.Area, []*world.Room, map[int]*world.Room, map[*world.Area]bool, error) {
+	var areas []*world.Area
+	changed := make(map[*world.Area]bool)
+	for _, file := range handoff.Areas {
+		area := file.Area()
+		areas = append(areas, area)
+		for _, name := range handoff.Changed {
+			if name == area.Name {
+				changed[area] = true
+			}
+		}
+	}
+	rooms, vnums, err := indexRooms(areas)
+	return areas, rooms, vnums, changed, err
+}
+
+// restorePlayers resumes serving the players from the old process
+func (handoff *copyoverState) restorePlayers(db *sql.DB, q Queue) {
+	for _, elt := range handoff.Players {
+		go restorePlayer(db, q, elt)
+	}
+}
+
+func restorePlayer(db *sql.DB, q Queue, saved *copyoverPlayer) {
+	if saved.LinkDead {
+		q.Schedule(func(state *State) {
+			mob := saved.Mob.restore(state)
+			mob.Player, mob.UserID, mob.CharacterID = newPlayer(), saved.UserID, saved.CharacterID
+			mob.Player.outgoingQueue = append(mob.Player.outgoingQueue, saved.Queue...)
+			mob.Player.resumeRecording(saved.Recording, saved.RecordForced)
+			state.Mobs = append(state.Mobs, mob)
+			state.LinkDead(mob)
+		}, 0)
+		return
+	}
+
+	file := os.NewFile(saved.FD, "player")
+	netConn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		log.Printf("copyover: restoring connection for user %d: %v", saved.UserID, err)
+		return
+	}
+	var socket playerSocket
+	if saved.Telnet != nil {
+		telnet := newTelnetSocket(netConn, *saved.Telnet)
+		telnet.reader = bufio.NewReader(io.MultiReader(bytes.NewReader(saved.Input), netConn))
+		socket = telnet
+	} else {
+		ws, err := inheritWebsocket(newFrameConn(netConn, saved.Input), saved.Extensions)
+		if err != nil {
+			log.Printf("copyover: restoring websocket for user %d: %v", saved.UserID, err)
+			netConn.Close()
+			return
+		}
+		socket = newWSSocket(ws, saved.Extensions)
+	}
+
+	var builder *Builder
+	if saved.UserID != 0 {
+		if builder, err = LoadBuilder(db, saved.UserID); err != nil {
+			log.Printf("loading builder for user %d: %v", saved.UserID, err)
+		}
+	}
+
+	player := newPlayer()
+	conn, _ := player.attach(socket)
+	if saved.Client != nil {
+		conn.client = *saved.Client
+	}
+	var mob *Mob
+	ready := make(chan struct{})
+	q.Schedule(func(state *State) {
+		mob = saved.Mob.restore(state)
+		mob.Player, mob.Builder, mob.UserID, mob.CharacterID = player, builder, saved.UserID, saved.CharacterID
+		player.resumeRecording(saved.Recording, saved.RecordForced)
+		state.Mobs = append(state.Mobs, mob)
+		mob.Send(MsgEnvironment, "The world comes back into focus.\n")
+		CmdLook(state, mob, "")
+		close(ready)
+	}, 0)
+	<-ready
+	player.serve(conn, mob, builder, q)
+}
+
+// inheritWebsocket wraps a connection whose websocket handshake was done
+// by the old process. It runs the handshake on the server side only: the
+// reply is dropped since the client already has one. The extensions the
+// client offered are offered again, so compression is agreed the same way.
+func inheritWebsocket(conn net.Conn, extensions string) (*websocket.Conn, error) {
+	r := &http.Request{
+		Method: http.MethodGet,
+		Header: http.Header{
+			"Connection":            {"Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key":     {"AAAAAAAAAAAAAAAAAAAAAA=="},
+		},
+	}
+	if extensions != "" {
+		r.Header.Set("Sec-Websocket-Extensions", extensions)
+	}
+	w := &inheritedResponse{conn: &handshakeConn{Conn: conn}, header: make(http.Header)}
+	return playerUpgrader.Upgrade(w, r, nil)
+}
+
+// handshakeConn drops the first write
+type handshakeConn struct {
+	net.Conn
+	replied bool
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	if !c.replied {
+		c.replied = true
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	if elt, ok := conn.(*handshakeConn); ok {
+		conn = elt.Conn
+	}
+	if elt, ok := conn.(*frameConn); ok {
+		conn = elt.Conn
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	return tcp, ok
+}
+
+// inheritedResponse lets the websocket upgrader hijack an inherited
+// connection
+type inheritedResponse struct {
+	conn   net.Conn
+	header http.Header
+}
+
+func (r *inheritedResponse) Header() http.Header         { return r.header }
+func (r *inheritedResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (r *inheritedResponse) WriteHeader(status int)      {}
+
+func (r *inheritedResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.conn, bufio.NewReadWriter(bufio.NewReader(r.conn), bufio.NewWriter(r.conn)), nil
+}
+
+// upgradePlayer upgrades a request to a websocket that reads through a
+// frameConn, so a copyover can hand on its input
+func upgradePlayer(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return playerUpgrader.Upgrade(&framedResponse{ResponseWriter: w}, r, nil)
+}
+
+// framedResponse gives the websocket upgrader a frameConn in place of the
+// hijacked connection
+type framedResponse struct {
+	http.ResponseWriter
+}
+
+func (r *framedResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	early, _ := rw.Reader.Peek(rw.Reader.Buffered())
+	framed := newFrameConn(conn, early)
+	return framed, bufio.NewReadWriter(bufio.NewReader(framed), rw.Writer), nil
+}
+
+// frameConn passes websocket input on one frame at a time, so the
+// websocket never holds more than part of a frame. Once stopped, it passes
+// nothing more on, and whatever the websocket has not finished reading can
+// be handed to a new process.
+type frameConn struct {
+	net.Conn
+
+	// set by stop, which runs outside the reader
+	stopped int32
+
+	// read from the connection and not yet passed on
+	buffered []byte
+
+	// the part of the current frame passed on so far, and how much is left
+	frame []byte
+	left  int
+}
+
+func newFrameConn(conn net.Conn, input []byte) *frameConn {
+	return &frameConn{Conn: conn, buffered: append([]byte{}, input...)}
+}
+
+func (c *frameConn) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.stopped) != 0 {
+		return 0, errDetaching
+	}
+	if c.left == 0 {
+		size, err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.frame, c.left = c.frame[:0], size
+	}
+	if err := c.fill(1); err != nil {
+		return 0, err
+	}
+	n := len(c.buffered)
+	if n > c.left {
+		n = c.left
+	}
+	n = copy(p, c.buffered[:n])
+	c.frame = append(c.frame, c.buffered[:n]...)
+	c.buffered, c.left = c.buffered[n:], c.left-n
+	return n, nil
+}
+
+// nextFrame reads the header of the next frame and gives its size,
+// including the header
+func (c *frameConn) nextFrame() (int, error) {
+	if err := c.fill(2); err != nil {
+		return 0, err
+	}
+	header, length := 2, int(c.buffered[1]&0x7f)
+	if c.buffered[1]&0x80 != 0 {
+		// the mask key
+		header += 4
+	}
+	switch length {
+	case 126:
+		header += 2
+	case 127:
+		header += 8
+	}
+	if err := c.fill(header); err != nil {
+		return 0, err
+	}
+	switch length {
+	case 126:
+		length = int(binary.BigEndian.Uint16(c.buffered[2:]))
+	case 127:
+		// the websocket refuses anything this big, so only the header
+		// needs to get through
+		if size := binary.BigEndian.Uint64(c.buffered[2:]); size < 1<<30 {
+			length = int(size)
+		} else {
+			length = 0
+		}
+	}
+	return header + length, nil
+}
+
+// fill reads until at least n bytes are buffered
+func (c *frameConn) fill(n int) error {
+	var chunk [4096]byte
+	for len(c.buffered) < n {
+		count, err := c.Conn.Read(chunk[:])
+		c.buffered = append(c.buffered, chunk[:count]...)
+		if err != nil && len(c.buffered) < n {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *frameConn) stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+}
+
+// unread gives the input the websocket has not finished reading: the
+// start of a frame it has only part of, and everything after that. It is
+// only safe once the reader has stopped.
+func (c *frameConn) unread() []byte {
+	if c.left == 0 {
+		return c.buffered
+	}
+	return append(append([]byte{}, c.frame...), c.buffered...)
+}
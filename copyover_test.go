@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// scriptedConn gives its input to the first read and then reports the end
+// of the stream
+type scriptedConn struct {
+	net.Conn
+	input []byte
+}
+
+func (c *scriptedConn) Read(p []byte) (int, error) {
+	if len(c.input) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.input)
+	c.input = c.input[n:]
+	return n, nil
+}
+
+func (c *scriptedConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *scriptedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// clientFrame is a masked text frame as a browser sends it. The mask key
+// is zero, so the payload is readable.
+func clientFrame(text string) []byte {
+	return append([]byte{0x81, 0x80 | byte(len(text)), 0, 0, 0, 0}, text...)
+}
+
+func TestFrameConnHandsOnInput(t *testing.T) {
+	south := clientFrame("south")
+	input := append(append(clientFrame("look"), clientFrame("north")...), south[:8]...)
+	frames := newFrameConn(&scriptedConn{input: input}, nil)
+	ws, err := inheritWebsocket(frames, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "look" {
+		t.Fatalf("read %q, %v", msg, err)
+	}
+
+	// stopped between frames, the next frame is not passed on
+	frames.stop()
+	if _, msg, err := ws.ReadMessage(); err == nil {
+		t.Fatalf("read %q after stopping", msg)
+	}
+	expected := append(clientFrame("north"), south[:8]...)
+	if unread := frames.unread(); !bytes.Equal(unread, expected) {
+		t.Fatalf("unread input is %q, expected %q", unread, expected)
+	}
+
+	// the next process reads what was left
+	frames = newFrameConn(&scriptedConn{}, expected)
+	if ws, err = inheritWebsocket(frames, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "north" {
+		t.Fatalf("read %q, %v", msg, err)
+	}
+
+	// interrupted partway through a frame, the whole frame is handed on
+	if _, msg, err := ws.ReadMessage(); err == nil {
+		t.Fatalf("read %q from a partial frame", msg)
+	}
+	if unread := frames.unread(); !bytes.Equal(unread, south[:8]) {
+		t.Fatalf("unread input is %q, expected %q", unread, south[:8])
+	}
+	frames = newFrameConn(&scriptedConn{input: south[8:]}, frames.unread())
+	if ws, err = inheritWebsocket(frames, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "south" {
+		t.Fatalf("read %q, %v", msg, err)
+	}
+}
+
+func TestTelnetHandsOnInput(t *testing.T) {
+	socket := newTelnetSocket(&scriptedConn{input: []byte("look\r\nnorth\r\nso")}, TelnetSettings{})
+	if line, err := socket.readLine(); err != nil || line != "look" {
+		t.Fatalf("read %q, %v", line, err)
+	}
+	socket.StopReading()
+	if line, err := socket.readLine(); err == nil {
+		t.Fatalf("read %q after stopping", line)
+	}
+	if unread := string(socket.Unread()); unread != "north\r\nso" {
+		t.Errorf("unread input is %q", unread)
+	}
+
+	// a line cut off by the end of the input is handed on whole
+	socket = newTelnetSocket(&scriptedConn{input: []byte("look\r\nsou")}, TelnetSettings{})
+	socket.readLine()
+	if line, err := socket.readLine(); err == nil {
+		t.Fatalf("read %q from a partial line", line)
+	}
+	if unread := string(socket.Unread()); unread != "sou" {
+		t.Errorf("unread input is %q", unread)
+	}
+}
+
+func TestCheckCopyover(t *testing.T) {
+	saved := Config
+	defer func() { Config = saved }()
+	for _, mode := range []string{ModeAutocert, ModeTLS, ModeSelfSigned} {
+		Config.Mode = mode
+		if err := checkCopyover(); err == nil {
+			t.Errorf("copyover allowed in %s mode", mode)
+		}
+	}
+	Config.Mode = ModeHTTP
+	if err := checkCopyover(); err != nil {
+		t.Errorf("copyover refused in http mode: %v", err)
+	}
+}
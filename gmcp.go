@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/russross/gruffles/world"
+)
+
+// Structured data about a player's character and surroundings goes out
+// alongside the text so clients can draw gauges and maps. The browser
+// client gets it as messages with a data field, and telnet clients get it
+// through GMCP under these package names.
+var gmcpPackages = map[MsgType]string{
+	MsgVitals:    "Char.Vitals",
+	MsgStatus:    "Char.Status",
+	MsgRoom:      "Room.Info",
+	MsgChannel:   "Comm.Channel.Text",
+	MsgInventory: "Char.Items.List",
+}
+
+type VitalsData struct {
+	HP      int `json:"hp"`
+	MaxHP   int `json:"maxhp"`
+	Mana    int `json:"mana"`
+	MaxMana int `json:"maxmana"`
+	Move    int `json:"move"`
+	MaxMove int `json:"maxmove"`
+}
+
+type StatusData struct {
+	Name       string `json:"name"`
+	Level      int    `json:"level"`
+	State      string `json:"state"`
+	Experience int    `json:"xp"`
+	Gold       int    `json:"gold"`
+	Alignment  int    `json:"alignment"`
+}
+
+type RoomData struct {
+	Num         int            `json:"num"`
+	Name        string         `json:"name"`
+	Area        string         `json:"area"`
+	Environment string         `json:"environment"`
+	Exits       map[string]int `json:"exits"`
+}
+
+type ChannelData struct {
+	Channel string `json:"channel"`
+	Talker  string `json:"talker"`
+	Text    string `json:"text"`
+}
+
+// names for mob states, in order
+var stateNames = []string{
+	"standing", "sleeping", "resting", "sitting", "fighting", "dead", "zombie", "fighting zombie",
+}
+
+func (mob *Mob) SendData(msgType MsgType, data interface{}) {
+	if mob.Player != nil {
+		mob.Player.Post(Msg{Type: msgType, Data: data})
+	}
+}
+
+// SendLocation sends the map and room information after the player moves
+// or the room changes
+func (mob *Mob) SendLocation(state *State) {
+	if mob.Player == nil || mob.Location == nil {
+		return
+	}
+	mob.Send(MsgMap, GetMap(state, mob.Location, mob.Visited, mob.Player.mapDepth()))
+	room := mob.Location
+	data := RoomData{Num: room.Vnum, Name: room.Name, Exits: make(map[string]int)}
+	if area := state.AreaOfRoom(room); area != nil {
+		data.Area = area.Name
+	}
+	if room.Terrain >= 0 && room.Terrain < len(world.Terrains) {
+		data.Environment = world.Terrains[room.Terrain]
+	}
+	for _, door := range room.Doors {
+		if door.ToRoom >= 0 && door.ToRoom < len(state.Rooms) && state.Rooms[door.ToRoom] != nil {
+			data.Exits[world.Directions[door.Direction][:1]] = state.Rooms[door.ToRoom].Vnum
+		}
+	}
+	mob.SendData(MsgRoom, data)
+}
+
+// sendUpdates tells players about changes to their vitals, status, and
+// inventory, and sends everything posted to them. It runs after every
+// event.
+func (state *State) sendUpdates() {
+	for _, mob := range state.Mobs {
+		player := mob.Player
+		if player == nil {
+			continue
+		}
+		vitals := VitalsData{
+			HP: mob.HP, MaxHP: mob.HPMax,
+			Mana: mob.Mana, MaxMana: mob.ManaMax,
+			Move: mob.Move, MaxMove: mob.MoveMax,
+		}
+		if player.sentVitals == nil || *player.sentVitals != vitals {
+			player.sentVitals = &vitals
+			mob.SendData(MsgVitals, vitals)
+		}
+		status := StatusData{
+			Name:       mob.Name,
+			Level:      mob.Level,
+			Experience: mob.Experience,
+			Gold:       mob.Gold,
+			Alignment:  mob.Alignment,
+		}
+		if int(mob.State) < len(stateNames) {
+			status.State = stateNames[mob.State]
+		}
+		if player.sentStatus == nil || *player.sentStatus != status {
+			player.sentStatus = &status
+			mob.SendData(MsgStatus, status)
+		}
+		if player.sentInventory == nil || !sameItems(player.sentInventory, mob.Inventory) {
+			player.sentInventory = append([]*Item{}, mob.Inventory...)
+			mob.SendData(MsgInventory, inventoryData(mob))
+		}
+		player.Flush()
+	}
+}
+
+func sameItems(a, b []*Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func CmdSay(state *State, mob *Mob, cmd string) time.Duration {
+	text := strings.TrimSpace(cmd)
+	if text == "" {
+		mob.Send(MsgError, "Say what?\n")
+		return 0
+	}
+	for _, elt := range state.Mobs {
+		if elt.Location != mob.Location {
+			continue
+		}
+		if elt == mob {
+			elt.Send(MsgSocial, fmt.Sprintf("You say '%s'\n", text))
+		} else {
+			elt.Send(MsgSocial, fmt.Sprintf("%s says '%s'\n", mob.Name, text))
+		}
+		elt.SendData(MsgChannel, ChannelData{Channel: "say", Talker: mob.Name, Text: text})
+	}
+	return 0
+}
+
+// gmcpMessage formats data for a GMCP subnegotiation
+func gmcpMessage(pkg string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte{telnetIAC, telnetSB, telnetOptGMCP}
+	msg = append(msg, pkg...)
+	msg = append(msg, ' ')
+	msg = append(msg, raw...)
+	return append(msg, telnetIAC, telnetSE), nil
+}
+
+// gmcpSupports handles the Core.Supports messages, which list the
+// packages a client wants, like ["Char 1", "Room 1"]. The set is replaced
+// rather than changed, since copies of the settings share it.
+func (settings *TelnetSettings) gmcpSupports(command string, data []byte) {
+	var modules []string
+	if err := json.Unmarshal(data, &modules); err != nil {
+		return
+	}
+	supports := make(map[string]bool)
+	if command != "core.supports.set" {
+		for name, present := range settings.GMCPSupports {
+			supports[name] = present
+		}
+	}
+	for _, module := range modules {
+		name := strings.ToLower(strings.Fields(module + " ")[0])
+		if command == "core.supports.remove" {
+			delete(supports, name)
+		} else {
+			supports[name] = true
+		}
+	}
+	settings.GMCPSupports = supports
+}
+
+// gmcpWants reports whether the client asked for a package. Clients that
+// never said get everything.
+func (settings *TelnetSettings) gmcpWants(pkg string) bool {
+	if settings.GMCPSupports == nil {
+		return true
+	}
+	name := strings.ToLower(pkg)
+	for {
+		if settings.GMCPSupports[name] {
+			return true
+		}
+		dot := strings.LastIndex(name, ".")
+		if dot < 0 {
+			return false
+		}
+		name = name[:dot]
+	}
+}
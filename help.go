@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/russross/gruffles/world"
+	"github.com/russross/meddler"
+)
+
+// maximum number of suggestions to list when a help topic is ambiguous
+const maxHelpSuggestions = 10
+
+func CmdHelp(state *State, mob *Mob, cmd string) time.Duration {
+	topic := strings.ToLower(strings.TrimSpace(cmd))
+	if topic == "" {
+		topic = "summary"
+	}
+
+	matches := findHelps(state, mob, topic)
+	switch {
+	case len(matches) == 0 && cmd == "":
+		mob.Send(MsgEnvironment, commandSummary(mob.Builder))
+	case len(matches) == 0:
+		mob.Send(MsgEnvironment, "No help on that word.\n")
+	case len(matches) == 1:
+		mob.Send(MsgEnvironment, formatHelp(matches[0]))
+	default:
+		var buf bytes.Buffer
+		buf.WriteString("Did you mean:\n")
+		for i, help := range matches {
+			if i == maxHelpSuggestions {
+				fmt.Fprintf(&buf, "  ... and %d more\n", len(matches)-i)
+				break
+			}
+			fmt.Fprintf(&buf, "  %s\n", strings.Join(helpKeywords(help), " "))
+		}
+		mob.Send(MsgEnvironment, buf.String())
+	}
+
+	return 0
+}
+
+// findHelps finds the help entries for a topic that are visible to a mob.
+// If any entries have a keyword that matches the topic exactly, only those
+// are returned. Otherwise an entry matches if every word in the topic is a
+// prefix of one of its keywords.
+func findHelps(state *State, mob *Mob, topic string) []*world.Help {
+	words := strings.Fields(topic)
+	var exact, prefix []*world.Help
+	for _, help := range allHelps(state, mob.Builder) {
+		if help.Level > mob.Level {
+			continue
+		}
+		keywords := helpKeywords(help)
+		found := false
+		for _, keyword := range keywords {
+			if keyword == topic {
+				found = true
+				break
+			}
+		}
+		if found {
+			exact = append(exact, help)
+			continue
+		}
+		all := len(words) > 0
+		for _, word := range words {
+			matched := false
+			for _, keyword := range keywords {
+				if strings.HasPrefix(keyword, word) {
+					matched = true
+					break
+				}
+			}
+			all = all && matched
+		}
+		if all {
+			prefix = append(prefix, help)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return prefix
+}
+
+// allHelps combines the help entries from the database with the
+// generated entries for commands that do not have one of their own.
+// Builder and admin commands are left out for players who cannot use
+// them.
+func allHelps(state *State, builder *Builder) []*world.Help {
+	covered := make(map[string]bool)
+	for _, help := range state.Helps {
+		for _, keyword := range helpKeywords(help) {
+			covered[keyword] = true
+		}
+	}
+	helps := append([]*world.Help{}, state.Helps...)
+	for _, help := range CommandHelps {
+		if !covered[help.Keywords[0]] && Commands[help.Keywords[0]].allowed(builder) {
+			helps = append(helps, help)
+		}
+	}
+	return helps
+}
+
+// helpKeywords normalizes the keywords of a help entry. Merc quotes
+// keywords with spaces in them, as in 'DEATH TRAP'.
+func helpKeywords(help *world.Help) []string {
+	var keywords []string
+	for _, keyword := range help.Keywords {
+		keyword = strings.ToLower(strings.Trim(keyword, `'"`))
+		if keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+func formatHelp(help *world.Help) string {
+	// Merc uses a leading . to preserve leading whitespace
+	text := strings.TrimPrefix(help.Text, ".")
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
+// CommandHelps has a generated help entry for each command
+var CommandHelps []*world.Help
+
+func setupCommandHelps() {
+	CommandHelps = nil
+	var names []string
+	for name, cmd := range Commands {
+		if name == cmd.Command {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := Commands[name]
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "Usage: %s", cmd.Command)
+		if cmd.Usage != "" {
+			fmt.Fprintf(&buf, " %s", cmd.Usage)
+		}
+		buf.WriteString("\n")
+		if len(cmd.Aliases) > 0 {
+			fmt.Fprintf(&buf, "Also: %s\n", strings.Join(cmd.Aliases, ", "))
+		}
+		if cmd.Help != "" {
+			fmt.Fprintf(&buf, "\n%s\n", cmd.Help)
+		}
+		CommandHelps = append(CommandHelps, &world.Help{
+			Keywords: append([]string{cmd.Command}, cmd.Aliases...),
+			Text:     buf.String(),
+		})
+	}
+}
+
+// commandSummary lists the commands a player can use
+func commandSummary(builder *Builder) string {
+	var buf bytes.Buffer
+	buf.WriteString("Commands:\n")
+	for _, help := range CommandHelps {
+		cmd := Commands[help.Keywords[0]]
+		if !cmd.allowed(builder) {
+			continue
+		}
+		fmt.Fprintf(&buf, "  %-10s %s\n", cmd.Command, firstLine(cmd.Help))
+	}
+	buf.WriteString("\nType help <topic> for more.\n")
+	return buf.String()
+}
+
+func firstLine(s string) string {
+	if newline := strings.IndexByte(s, '\n'); newline >= 0 {
+		return s[:newline]
+	}
+	return s
+}
+
+//
+// API handlers for authors
+//
+
+func GetHelps(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	helps, err := world.LoadHelpsSQL(tx)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if helps == nil {
+		helps = []*world.Help{}
+	}
+	render.JSON(http.StatusOK, helps)
+}
+
+func GetHelp(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	helpID, err := parseID(w, "help_id", params["help_id"])
+	if err != nil {
+		return
+	}
+
+	help := new(world.Help)
+	if err = meddler.Load(tx, "helps", help, helpID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	render.JSON(http.StatusOK, help)
+}
+
+func CreateHelp(w http.ResponseWriter, tx *sql.Tx, help world.Help, q Queue, render render.Render) {
+	help.ID = 0
+	if err := checkHelp(w, tx, &help); err != nil {
+		return
+	}
+	if err := meddler.Insert(tx, "helps", &help); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	scheduleHelpUpdate(q, &help)
+	render.JSON(http.StatusOK, &help)
+}
+
+func UpdateHelp(w http.ResponseWriter, tx *sql.Tx, params martini.Params, help world.Help, q Queue, render render.Render) {
+	helpID, err := parseID(w, "help_id", params["help_id"])
+	if err != nil {
+		return
+	}
+
+	old := new(world.Help)
+	if err = meddler.Load(tx, "helps", old, helpID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	help.ID = old.ID
+	if help.AreaID == 0 {
+		help.AreaID = old.AreaID
+	}
+	if err := checkHelp(w, tx, &help); err != nil {
+		return
+	}
+	if err := meddler.Update(tx, "helps", &help); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	scheduleHelpUpdate(q, &help)
+	render.JSON(http.StatusOK, &help)
+}
+
+// checkHelp validates and normalizes a help entry from the API
+func checkHelp(w http.ResponseWriter, tx *sql.Tx, help *world.Help) error {
+	if help.Level < 0 || help.Level > 100 {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "level must be between 0 and 100")
+	}
+	var keywords []string
+	for _, keyword := range help.Keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	if len(keywords) == 0 {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "help must have at least one keyword")
+	}
+	help.Keywords = keywords
+	if strings.TrimSpace(help.Text) == "" {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "help text cannot be empty")
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM areas WHERE id = ?`, help.AreaID).Scan(&count); err != nil {
+		return loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	}
+	if count == 0 {
+		return loggedHTTPErrorf(w, http.StatusBadRequest, "area %d not found", help.AreaID)
+	}
+	return nil
+}
+
+// scheduleHelpUpdate makes a new or edited help entry visible in the game
+func scheduleHelpUpdate(q Queue, help *world.Help) {
+	elt := *help
+	q.Schedule(func(state *State) {
+		for i, old := range state.Helps {
+			if old.ID == elt.ID {
+				state.Helps[i] = &elt
+				return
+			}
+		}
+		state.Helps = append(state.Helps, &elt)
+	}, 0)
+}
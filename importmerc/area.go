@@ -1 +0,0 @@
-../area.go
\ No newline at end of file
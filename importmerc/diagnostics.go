@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// A Diagnostic is a single problem found while importing. Line and
+// Column are 1-based and zero when the problem is not tied to a spot in
+// the file.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+type location struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// A Report collects every diagnostic from an import run. When KeepGoing
+// is false the first error is fatal, which was the original behavior.
+type Report struct {
+	Files       []string      `json:"files"`
+	Errors      int           `json:"errors"`
+	Warnings    int           `json:"warnings"`
+	Diagnostics []*Diagnostic `json:"diagnostics"`
+
+	KeepGoing bool                     `json:"-"`
+	sources   map[interface{}]location `json:"-"`
+}
+
+func NewReport(keepGoing bool) *Report {
+	return &Report{
+		Files:       []string{},
+		Diagnostics: []*Diagnostic{},
+		KeepGoing:   keepGoing,
+		sources:     make(map[interface{}]location),
+	}
+}
+
+func (r *Report) add(severity string, loc location, format string, params ...interface{}) {
+	msg := fmt.Sprintf(format, params...)
+	r.Diagnostics = append(r.Diagnostics, &Diagnostic{
+		Severity: severity,
+		File:     loc.File,
+		Line:     loc.Line,
+		Column:   loc.Column,
+		Message:  msg,
+	})
+	if severity == SeverityError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+
+	prefix := loc.File
+	if loc.Line > 0 {
+		prefix = fmt.Sprintf("%s:%d:%d", loc.File, loc.Line, loc.Column)
+	}
+	if severity == SeverityError && !r.KeepGoing {
+		log.Fatalf("%s: %s", prefix, msg)
+	}
+	log.Printf("%s: %s: %s", prefix, severity, msg)
+}
+
+func (r *Report) Errorf(loc location, format string, params ...interface{}) {
+	r.add(SeverityError, loc, format, params...)
+}
+
+func (r *Report) Warnf(loc location, format string, params ...interface{}) {
+	r.add(SeverityWarning, loc, format, params...)
+}
+
+// mark remembers where an element was found in its source file so that
+// problems found after parsing (like broken references) can point at it
+func (r *Report) mark(elt interface{}, loc location) {
+	r.sources[elt] = loc
+}
+
+func (r *Report) locate(elt interface{}) location {
+	return r.sources[elt]
+}
+
+// Write saves the report as JSON to the given file, or to stdout if the
+// name is "-"
+func (r *Report) Write(filename string) error {
+	raw, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	if filename == "-" {
+		_, err = os.Stdout.Write(raw)
+		return err
+	}
+	return ioutil.WriteFile(filename, raw, 0644)
+}
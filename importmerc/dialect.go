@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/russross/gruffles/world"
+)
+
+// A Dialect is one family of area file formats. Each section header
+// (the word after the #) maps to a function that parses that section
+// into the current area.
+type Dialect struct {
+	Name     string
+	Sections map[string]sectionFunc
+}
+
+type sectionFunc func(in *input, file *areaFile)
+
+var Dialects = map[string]*Dialect{
+	"merc":  mercDialect(),
+	"rom":   romDialect(),
+	"smaug": smaugDialect(),
+}
+
+func dialectNames() []string {
+	var names []string
+	for name := range Dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// areaFile tracks the areas found while parsing a single file
+type areaFile struct {
+	areas   []*world.Area
+	area    *world.Area
+	version int
+}
+
+func (file *areaFile) startArea(name string) *world.Area {
+	log.Printf("starting AREA %s", name)
+	file.area = &world.Area{Name: name}
+	file.areas = append(file.areas, file.area)
+	return file.area
+}
+
+// current returns the area that a section belongs to
+func (file *areaFile) current(in *input, section string) *world.Area {
+	if file.area == nil {
+		in.Failf("%s found outside an area", section)
+	}
+	return file.area
+}
+
+var romAreaHeader = regexp.MustCompile(`^#AREA\s+[^~]*~\s*[^~]*~\s*[^~]*~\s*-?\d+\s+-?\d+`)
+
+// detectDialect guesses the dialect of an area file from the sections it
+// contains and the shape of its #AREA header
+func detectDialect(raw []byte) *Dialect {
+	sections := make(map[string]bool)
+	for _, line := range bytes.Split(raw, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 1 && line[0] == '#' {
+			r, _ := utf8.DecodeRune(line[1:])
+			if unicode.IsLetter(r) {
+				sections[string(bytes.Fields(line[1:])[0])] = true
+			}
+		}
+	}
+
+	switch {
+	case sections["AUTHOR"] || sections["RANGES"] || sections["ECONOMY"] ||
+		sections["CLIMATE"] || sections["RESETMSG"] || sections["REPAIRS"]:
+		return Dialects["smaug"]
+	case sections["AREADATA"] || sections["MOBOLD"] || sections["OBJOLD"]:
+		return Dialects["rom"]
+	}
+	if start := bytes.Index(raw, []byte("#AREA")); start >= 0 && romAreaHeader.Match(raw[start:]) {
+		return Dialects["rom"]
+	}
+	return Dialects["merc"]
+}
+
+func mercDialect() *Dialect {
+	return &Dialect{
+		Name: "merc",
+		Sections: map[string]sectionFunc{
+			"AREA": func(in *input, file *areaFile) {
+				file.startArea(in.parseString())
+			},
+			"HELPS":    sectionHelps,
+			"MOBILES":  func(in *input, file *areaFile) { sectionMobiles(in, file, parseMobiles) },
+			"OBJECTS":  func(in *input, file *areaFile) { sectionObjects(in, file, parseObjects) },
+			"ROOMS":    func(in *input, file *areaFile) { sectionRooms(in, file, parseRooms) },
+			"RESETS":   func(in *input, file *areaFile) { sectionResets(in, file, nil) },
+			"SHOPS":    sectionShops,
+			"SPECIALS": sectionSpecials,
+		},
+	}
+}
+
+//
+// sections shared by all dialects
+//
+
+func sectionHelps(in *input, file *areaFile) {
+	area := file.current(in, "HELPS")
+	area.Helps = append(area.Helps, parseHelps(in)...)
+	log.Printf("found %d HELPS", len(area.Helps))
+}
+
+func sectionMobiles(in *input, file *areaFile, parse func(*input) []*world.Mobile) {
+	area := file.current(in, "MOBILES")
+	area.Mobiles = append(area.Mobiles, parse(in)...)
+	log.Printf("found %d MOBILES", len(area.Mobiles))
+}
+
+func sectionObjects(in *input, file *areaFile, parse func(*input) []*world.Object) {
+	area := file.current(in, "OBJECTS")
+	area.Objects = append(area.Objects, parse(in)...)
+	log.Printf("found %d OBJECTS", len(area.Objects))
+}
+
+func sectionRooms(in *input, file *areaFile, parse func(*input) []*world.Room) {
+	area := file.current(in, "ROOMS")
+	area.Rooms = append(area.Rooms, parse(in)...)
+	log.Printf("found %d ROOMS", len(area.Rooms))
+}
+
+func sectionResets(in *input, file *areaFile, hook func(*input, *world.Reset) bool) {
+	area := file.current(in, "RESETS")
+	area.Resets = append(area.Resets, parseResets(in, area.ID, hook)...)
+	log.Printf("found %d RESETS", len(area.Resets))
+}
+
+func sectionShops(in *input, file *areaFile) {
+	file.current(in, "SHOPS")
+	shops := parseShops(in)
+	log.Printf("found %d SHOPS", len(shops))
+}
+
+func sectionSpecials(in *input, file *areaFile) {
+	file.current(in, "SPECIALS")
+	specials := parseSpecials(in)
+	log.Printf("found %d SPECIALS", len(specials))
+}
+
+//
+// parsing helpers for the extended dialects
+//
+
+// parseFlagSet parses a flag field in ROM notation: each letter is one
+// bit (A-Z are bits 0-25, a-z are bits 26 and up), and numbers are taken
+// as-is. Any of these can be joined with |, as in Merc files.
+func (in *input) parseFlagSet() world.BitSet {
+	word := in.parseWord()
+	set, err := world.ParseMercFlags(word)
+	if err != nil {
+		in.Failf("%v", err)
+	}
+	return set
+}
+
+// parseFlags parses a field in flag notation that we keep as a number,
+// like the object values that hold flags
+func (in *input) parseFlags() int {
+	return in.parseFlagSet().Int()
+}
+
+// parseQuotedWord parses a single word, or a phrase wrapped in single or
+// double quotes like 'cure light'
+func (in *input) parseQuotedWord() string {
+	in.rest = bytes.TrimLeftFunc(in.rest, unicode.IsSpace)
+	if len(in.rest) == 0 {
+		in.Failf("unexpected end-of-file while parsing a word")
+	}
+	quote := in.rest[0]
+	if quote != '\'' && quote != '"' {
+		return in.parseWord()
+	}
+	end := bytes.IndexByte(in.rest[1:], quote)
+	if end < 0 {
+		in.Failf("unterminated quoted word")
+	}
+	word := string(in.rest[1 : end+1])
+	in.rest = in.rest[end+2:]
+	return word
+}
+
+// parseNumbersToEOL parses the next line as a list of numbers. SMAUG
+// reads some fields this way so that trailing values are optional.
+func (in *input) parseNumbersToEOL(min, max int) []int {
+	in.rest = bytes.TrimLeftFunc(in.rest, unicode.IsSpace)
+	fields := strings.Fields(in.parseToEOL())
+	if len(fields) < min || len(fields) > max {
+		in.Failf("expected %d to %d numbers on the line but found %d", min, max, len(fields))
+	}
+	var out []int
+	for _, field := range fields {
+		n, err := interpretNumber(field)
+		if err != nil {
+			in.Failf("error interpretting number %q: %v", field, err)
+		}
+		out = append(out, n)
+	}
+	for len(out) < max {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// skipPrograms skips a list of mud programs attached to a mobile, object,
+// or room. Each one starts with > and the list ends with |.
+func (in *input) skipPrograms() int {
+	count := 0
+	for in.hasLetter(">") {
+		in.expectLetter(">")
+		in.parseString()
+		in.parseString()
+		count++
+	}
+	in.expectLetter("|")
+	return count
+}
+
+// skipSection skips a section we do not import, ending at the next
+// line that matches end
+func (in *input) skipSection(name, end string) {
+	in.Warnf("ignoring %s section", name)
+	for {
+		line := strings.TrimSpace(in.parseToEOL())
+		if line == end {
+			return
+		}
+	}
+}
+
+// lookup finds a word in a table, ignoring case. Words that are numbers
+// are used directly.
+func (in *input) lookup(kind, word string, table []string) int {
+	if n, err := interpretNumber(word); err == nil {
+		return n
+	}
+	for i, elt := range table {
+		if strings.EqualFold(elt, word) {
+			return i
+		}
+	}
+	in.Warnf("unknown %s %q", kind, word)
+	return 0
+}
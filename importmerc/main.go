@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
@@ -14,22 +16,45 @@ import (
 	"unicode/utf8"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/russross/meddler"
+	"github.com/russross/gruffles/world"
 )
 
 type input struct {
-	data []byte
-	rest []byte
+	filename string
+	data     []byte
+	rest     []byte
+	report   *Report
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("Usage: %s <areafile1> ...", os.Args[0])
-	}
+// parseError is the panic value used to abandon a record after the
+// problem has been added to the report
+type parseError struct{}
 
-	var areas []*Area
-	filenames := make(map[*Area]string)
-	for _, filename := range os.Args[1:] {
+func main() {
+	dialectName := flag.String("dialect", "auto", "area file format: auto, "+strings.Join(dialectNames(), ", "))
+	reportFile := flag.String("report", "", "keep going after errors and write a JSON diagnostics report to this file (- for stdout)")
+	checkOnly := flag.Bool("check", false, "parse and check the area files without writing to the database")
+	partial := flag.Bool("partial", false, "with -report, write what could be imported even when there are errors")
+	dbFile := flag.String("db", "gruffles.db", "database file to import into")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <areafile1> ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, exists := Dialects[*dialectName]; !exists && *dialectName != "auto" {
+		log.Fatalf("unknown dialect %q", *dialectName)
+	}
+
+	report := NewReport(*reportFile != "")
+	var areas []*world.Area
+	filenames := make(map[*world.Area]string)
+	for _, filename := range flag.Args() {
+		report.Files = append(report.Files, filename)
 		basename := filename
 		if strings.HasSuffix(basename, ".are") {
 			basename = basename[:len(basename)-len(".are")]
@@ -37,97 +62,81 @@ func main() {
 
 		raw, err := ioutil.ReadFile(filename)
 		if err != nil {
-			log.Fatal(err)
+			report.Errorf(location{File: filename}, "%v", err)
+			continue
 		}
 		if !utf8.Valid(raw) {
-			log.Fatalf("file is not valid utf8")
+			report.Errorf(location{File: filename}, "file is not valid utf8")
+			continue
 		}
 		in := &input{
-			data: raw,
-			rest: raw,
+			filename: filename,
+			data:     raw,
+			rest:     raw,
+			report:   report,
 		}
 
-		log.Printf("parsing file %s", filename)
-		var area *Area
+		dialect := Dialects[*dialectName]
+		if dialect == nil {
+			dialect = detectDialect(raw)
+		}
 
-		for len(in.rest) > 0 {
-			section := in.parseHeader()
+		log.Printf("parsing file %s as %s", filename, dialect.Name)
+		for _, area := range parseFile(in, dialect) {
+			areas = append(areas, area)
+			filenames[area] = basename
+		}
+	}
 
-			switch section {
-			case "$":
-				log.Printf("end of file marker found, quitting")
-				in.rest = nil
+	if !*checkOnly {
+		writeDB(*dbFile, areas, filenames, report, *partial)
+	} else {
+		checkReferences(areas, report)
+	}
+
+	log.Printf("found %d errors and %d warnings", report.Errors, report.Warnings)
+	if *reportFile != "" {
+		if err := report.Write(*reportFile); err != nil {
+			log.Fatalf("writing report: %v", err)
+		}
+	}
+	if report.Errors > 0 {
+		os.Exit(1)
+	}
+}
 
-			case "AREA":
-				name := in.parseString()
-				log.Printf("starting AREA %s", name)
-				area = &Area{Name: name}
-				areas = append(areas, area)
-				filenames[area] = basename
-
-			case "HELPS":
-				log.Printf("starting HELPS section")
-				if area == nil {
-					in.Failf("HELPS found outside an area")
-				}
-				area.Helps = parseHelps(in)
-				log.Printf("found %d HELPS", len(area.Helps))
-
-			case "MOBILES":
-				log.Printf("starting MOBILES section")
-				if area == nil {
-					in.Failf("MOBILES found outside an area")
-				}
-				area.Mobiles = parseMobiles(in)
-				log.Printf("found %d MOBILES", len(area.Mobiles))
-
-			case "OBJECTS":
-				log.Printf("starting OBJECTS section")
-				if area == nil {
-					in.Failf("OBJECTS found outside an area")
-				}
-				area.Objects = parseObjects(in)
-				log.Printf("found %d OBJECTS", len(area.Objects))
-
-			case "ROOMS":
-				log.Printf("starting ROOMS section")
-				if area == nil {
-					in.Failf("ROOMS found outside an area")
-				}
-				area.Rooms = parseRooms(in)
-				log.Printf("found %d ROOMS", len(area.Rooms))
-
-			case "RESETS":
-				log.Printf("starting RESETS section")
-				if area == nil {
-					in.Failf("RESETS found outside an area")
-				}
-				area.Resets = parseResets(in, area.ID)
-				log.Printf("found %d RESETS", len(area.Resets))
-
-			case "SHOPS":
-				log.Printf("starting SHOPS section")
-				if area == nil {
-					in.Failf("SHOPS found outside an area")
-				}
-				shops := parseShops(in)
-				log.Printf("found %d SHOPS", len(shops))
-
-			case "SPECIALS":
-				log.Printf("starting SPECIALS section")
-				if area == nil {
-					in.Failf("SPECIALS found outside an area")
-				}
-				specials := parseSpecials(in)
-				log.Printf("found %d SPECIALS", len(specials))
+func parseFile(in *input, dialect *Dialect) []*world.Area {
+	file := new(areaFile)
 
-			default:
-				in.Failf("unimplemented section: %s", section)
+	for len(in.rest) > 0 {
+		ok := in.try(func() {
+			section := in.parseHeader()
+			if section == "$" {
+				log.Printf("end of file marker found, quitting")
+				in.rest = nil
+				return
+			}
+			parse, exists := dialect.Sections[section]
+			if !exists {
+				in.Failf("unimplemented %s section: %s", dialect.Name, section)
 			}
+			log.Printf("starting %s section", section)
+			parse(in, file)
+		})
+		if !ok {
+			// skip the rest of the broken section
+			in.skipToSection()
 		}
 	}
 
-	db, err := sql.Open("sqlite3", "gruffles.db")
+	return file.areas
+}
+
+// writeDB stores the areas in one transaction. If any errors were found,
+// including broken references found here, nothing is written unless
+// partial is set.
+func writeDB(filename string, areas []*world.Area, filenames map[*world.Area]string, report *Report, partial bool) {
+	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
 		log.Fatalf("opening db: %v", err)
 	}
@@ -140,16 +149,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("starting transaction: %v", err)
 	}
-	defer func() {
-		if err := tx.Commit(); err != nil {
-			log.Fatalf("committing transaction: %v", err)
-		}
-	}()
+	defer tx.Rollback()
 
 	now := time.Now()
-	roomIDs := make(map[int]int)
-	mobIDs := make(map[int]int)
-	objectIDs := make(map[int]int)
+	ids := world.NewVnumIDs()
 
 	for _, elt := range areas {
 		elt.CreatedAt = now
@@ -157,19 +160,76 @@ func main() {
 		log.Printf("saving area %s (file %s) with %d helps, %d rooms, %d mobs, %d objects, %d resets",
 			elt.Name, filenames[elt],
 			len(elt.Helps), len(elt.Rooms), len(elt.Mobiles), len(elt.Objects), len(elt.Resets))
-		writeAreaSQL(tx, elt)
-		writeHelpsSQL(tx, elt)
-		writeRoomsSQL(tx, elt, roomIDs)
-		writeMobsSQL(tx, elt, mobIDs)
-		writeObjectsSQL(tx, elt, objectIDs)
+		if err := world.InsertArea(tx, elt, ids); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 	log.Printf("saving doors and resets")
 	for _, elt := range areas {
-		writeDoorsSQL(tx, elt, roomIDs, objectIDs)
-		writeResetsSQL(tx, elt, roomIDs, mobIDs, objectIDs)
+		fixReferences(elt, ids, report)
+		if err := world.InsertDoorsAndResets(tx, elt); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if report.Errors > 0 && !partial {
+		log.Printf("found errors, so nothing was written to the database (use -partial to write anyway)")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("committing transaction: %v", err)
+	}
+}
+
+// checkReferences reports the same broken references that writeDB would,
+// but without touching the database
+func checkReferences(areas []*world.Area, report *Report) {
+	ids := world.NewVnumIDs()
+	for _, area := range areas {
+		for _, room := range area.Rooms {
+			ids.Rooms[room.Vnum] = room.Vnum
+		}
+		for _, mob := range area.Mobiles {
+			ids.Mobiles[mob.Vnum] = mob.Vnum
+		}
+		for _, object := range area.Objects {
+			ids.Objects[object.Vnum] = object.Vnum
+		}
+	}
+	for _, area := range areas {
+		fixReferences(area, ids, report)
 	}
 }
 
+// fixReferences maps every door and reset in an area from vnums to
+// database IDs
+func fixReferences(area *world.Area, ids *world.VnumIDs, report *Report) {
+	for _, room := range area.Rooms {
+		for i := range room.Doors {
+			fixDoorReferences(area, room, i, ids.Rooms, ids.Objects, report)
+		}
+	}
+	// a reset that names something missing is dropped, along with the
+	// give and equip resets that depend on a dropped mobile reset
+	var resets []*world.Reset
+	mobileDropped := false
+	for _, reset := range area.Resets {
+		switch {
+		case (reset.Type == "G" || reset.Type == "E") && mobileDropped:
+			report.Warnf(report.locate(reset), "area %s: dropping reset %s because its mobile reset was dropped",
+				area.Name, reset.Type)
+		case fixResetReferences(area, reset, ids.Rooms, ids.Mobiles, ids.Objects, report):
+			resets = append(resets, reset)
+			if reset.Type == "M" {
+				mobileDropped = false
+			}
+		case reset.Type == "M":
+			mobileDropped = true
+		}
+	}
+	area.Resets = resets
+}
+
 func (in *input) parseHeader() string {
 	in.rest = bytes.TrimLeftFunc(in.rest, unicode.IsSpace)
 	in.expectLetter("#")
@@ -263,7 +323,7 @@ func (in *input) parseNumber() int {
 	if length == 0 {
 		in.Failf("found zero-length while parsing a number")
 	}
-	if length == 1 && (start[0] == '+' || start[1] == '-') {
+	if length == 1 && (start[0] == '+' || start[0] == '-') {
 		in.Failf("found only a + or - while parsing a number")
 	}
 
@@ -308,7 +368,7 @@ func (in *input) parseToEOL() string {
 	return string(start[:length])
 }
 
-func (in *input) Failf(fmt string, params ...interface{}) {
+func (in *input) here() location {
 	parsed := in.data[:len(in.data)-len(in.rest)]
 	newlines := bytes.Count(parsed, []byte{'\n'})
 	lastNewline := bytes.LastIndex(parsed, []byte{'\n'})
@@ -316,12 +376,105 @@ func (in *input) Failf(fmt string, params ...interface{}) {
 		lastNewline = 0
 	}
 	lastLine := parsed[lastNewline:]
-	log.Printf("at line %d column %d", newlines+1, len(lastLine))
-	log.Fatalf(fmt, params...)
+	return location{File: in.filename, Line: newlines + 1, Column: len(lastLine)}
+}
+
+// Failf reports an error at the current position and abandons the
+// current record. It does not return.
+func (in *input) Failf(format string, params ...interface{}) {
+	in.report.Errorf(in.here(), format, params...)
+	panic(parseError{})
+}
+
+func (in *input) Warnf(format string, params ...interface{}) {
+	in.report.Warnf(in.here(), format, params...)
+}
+
+// try runs one step of the parser and reports whether it finished.
+// If it fails, the error has already been reported and the caller
+// is expected to skip ahead to somewhere it can resume.
+func (in *input) try(f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isParseError := r.(parseError); !isParseError {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+// skipToRecord moves to the next line that starts with a '#',
+// which is the start of either a record or a section
+func (in *input) skipToRecord() {
+	for len(in.rest) > 0 {
+		newline := bytes.IndexByte(in.rest, '\n')
+		if newline < 0 {
+			in.rest = nil
+			return
+		}
+		in.rest = in.rest[newline+1:]
+		if len(in.rest) > 0 && in.rest[0] == '#' {
+			return
+		}
+	}
+}
+
+// skipToSection moves to the next section header
+func (in *input) skipToSection() {
+	for len(in.rest) > 0 {
+		in.skipToRecord()
+		if in.atSection() {
+			return
+		}
+	}
+}
+
+// atSection reports whether the next thing in the input is a section
+// header like #ROOMS or #$ (as opposed to a record like #3001)
+func (in *input) atSection() bool {
+	rest := bytes.TrimLeftFunc(in.rest, unicode.IsSpace)
+	if len(rest) < 2 || rest[0] != '#' {
+		return false
+	}
+	r, _ := utf8.DecodeRune(rest[1:])
+	return r == '$' || unicode.IsLetter(r)
+}
+
+// records calls parse once for each #vnum record in a section until it
+// finds the closing #0. A record that fails to parse is skipped.
+func (in *input) records(parse func(id int)) {
+	for {
+		done := false
+		ok := in.try(func() {
+			in.expectLetter("#")
+			id := in.parseNumber()
+			if id == 0 {
+				done = true
+				return
+			}
+			parse(id)
+		})
+		if done {
+			return
+		}
+		if !ok {
+			in.skipToRecord()
+			if len(in.rest) == 0 {
+				return
+			}
+			if in.atSection() {
+				in.Warnf("section ended without #0")
+				return
+			}
+		}
+	}
 }
 
-func parseHelps(in *input) []*Help {
-	helps := []*Help{}
+func parseHelps(in *input) []*world.Help {
+	helps := []*world.Help{}
 	for {
 		level := in.parseNumber()
 		keywords := in.parseString()
@@ -329,7 +482,7 @@ func parseHelps(in *input) []*Help {
 			break
 		}
 		text := in.parseString()
-		help := &Help{
+		help := &world.Help{
 			Level:    level,
 			Keywords: parseKeywords(keywords),
 			Text:     text,
@@ -339,51 +492,32 @@ func parseHelps(in *input) []*Help {
 	return helps
 }
 
-func parseMobiles(in *input) []*Mobile {
-	mobiles := []*Mobile{}
-	for {
-		in.expectLetter("#")
-		id := in.parseNumber()
-		if id == 0 {
-			break
-		}
+func parseMobiles(in *input) []*world.Mobile {
+	mobiles := []*world.Mobile{}
+	in.records(func(id int) {
 		keywords := in.parseString()
 		short := in.parseString()
 		long := in.parseString()
 		desc := in.parseString()
-		actionFlags := in.parseNumber()
-		affFlags := in.parseNumber()
+		actionFlags := world.ActFlags{BitSet: in.parseFlagSet()}
+		affFlags := world.AffectFlags{BitSet: in.parseFlagSet()}
 		alignment := in.parseNumber()
 		in.expectLetter("S")
 		level := in.parseNumber()
 		hitroll := in.parseNumber()
 		armor := in.parseNumber()
-		hitNumberDice := in.parseNumber()
-		if in.hasLetter("D") {
-			in.expectLetter("D")
-		} else {
-			in.expectLetter("d")
-		}
-		hitSizeDice := in.parseNumber()
-		in.expectLetter("+")
-		hitPlus := in.parseNumber()
-		damNumberDice := in.parseNumber()
-		if in.hasLetter("D") {
-			in.expectLetter("D")
-		} else {
-			in.expectLetter("d")
-		}
-		damSizeDice := in.parseNumber()
-		in.expectLetter("+")
-		damPlus := in.parseNumber()
+		hitDice := in.parseDice()
+		damDice := in.parseDice()
 		gold := in.parseNumber()
 		exp := in.parseNumber()
-		position1 := in.parseNumber()
-		position2 := in.parseNumber()
-		sex := []string{"it", "he", "she"}[in.parseNumber()]
+		startPosition := parsePosition(in)
+		defaultPosition := parsePosition(in)
+		sex := parseSex(in)
+		dodge, absorb := armorRolls(hitroll, armor)
 
-		mob := &Mobile{
+		mob := &world.Mobile{
 			ID:               id,
+			Vnum:             id,
 			Keywords:         parseKeywords(keywords),
 			ShortDescription: short,
 			LongDescription:  long,
@@ -392,10 +526,12 @@ func parseMobiles(in *input) []*Mobile {
 			AffectedFlags:    affFlags,
 			Alignment:        alignment,
 			Level:            level,
-			HitRoll:          makeRoll(hitNumberDice, hitSizeDice, hitPlus),
-			DamageRoll:       makeRoll(damNumberDice, damSizeDice, damPlus),
-			DodgeRoll:        makeRoll(0, 0, 0),
-			AbsorbRoll:       makeRoll(0, 0, 0),
+			HitBonus:         hitroll,
+			Armor:            armor,
+			HitRoll:          hitDice,
+			DamageRoll:       damDice,
+			DodgeRoll:        dodge,
+			AbsorbRoll:       absorb,
 			FireRoll:         makeRoll(0, 0, 0),
 			IceRoll:          makeRoll(0, 0, 0),
 			PoisonRoll:       makeRoll(0, 0, 0),
@@ -403,118 +539,108 @@ func parseMobiles(in *input) []*Mobile {
 			Gold:             gold,
 			Experience:       exp,
 			Pronouns:         sex,
+			StartPosition:    startPosition,
+			DefaultPosition:  defaultPosition,
 		}
 		mobiles = append(mobiles, mob)
-		_, _, _, _ = hitroll, armor, position1, position2
-	}
+	})
 	return mobiles
 }
 
-func parseObjects(in *input) []*Object {
-	objects := []*Object{}
-	for {
-		in.expectLetter("#")
-		id := in.parseNumber()
-		if id == 0 {
-			break
-		}
+func parseObjects(in *input) []*world.Object {
+	objects := []*world.Object{}
+	in.records(func(id int) {
 		keywords := parseKeywords(in.parseString())
 		shortDescription := in.parseString()
 		longDescription := in.parseString()
-		// ActionDescription
-		_ = in.parseString()
+		actionDescription := in.parseString()
 		itemType := in.parseNumber()
-		extraFlags := in.parseNumber()
-		wearFlags := in.parseNumber()
+		extraFlags := world.ExtraFlags{BitSet: in.parseFlagSet()}
+		wearFlags := world.WearFlags{BitSet: in.parseFlagSet()}
 		value0 := in.parseNumber()
 		value1 := in.parseNumber()
 		value2 := in.parseNumber()
 		value3 := in.parseNumber()
 		weight := in.parseNumber()
 		cost := in.parseNumber()
-		// CostPerDat
-		_ = in.parseNumber()
-		obj := &Object{
-			ID:               id,
-			Keywords:         keywords,
-			ShortDescription: shortDescription,
-			LongDescription:  longDescription,
-			ItemType:         itemType,
-			ExtraFlags:       extraFlags,
-			WearFlags:        wearFlags,
-			Value0:           value0,
-			Value1:           value1,
-			Value2:           value2,
-			Value3:           value3,
-			Weight:           weight,
-			Cost:             cost,
-			Extras:           []ObjectExtraDescription{},
-			Applies:          []ObjectApply{},
+		costPerDay := in.parseNumber()
+		obj := &world.Object{
+			ID:                id,
+			Vnum:              id,
+			Keywords:          keywords,
+			ShortDescription:  shortDescription,
+			LongDescription:   longDescription,
+			ActionDescription: actionDescription,
+			ItemType:          itemType,
+			ExtraFlags:        extraFlags,
+			WearFlags:         wearFlags,
+			Value0:            value0,
+			Value1:            value1,
+			Value2:            value2,
+			Value3:            value3,
+			Weight:            weight,
+			Cost:              cost,
+			CostPerDay:        costPerDay,
+			Extras:            []world.ObjectExtraDescription{},
+			Applies:           []world.ObjectApply{},
 		}
 		for {
 			if in.hasLetter("E") {
 				in.expectLetter("E")
-				extra := ObjectExtraDescription{
-					Keywords:    parseKeywords(in.parseString()),
-					Description: in.parseString(),
-				}
-				obj.Extras = append(obj.Extras, extra)
+				parseObjectExtra(in, obj)
 			} else if in.hasLetter("A") {
 				in.expectLetter("A")
-				apply := ObjectApply{
-					Type:  in.parseNumber(),
-					Value: in.parseNumber(),
-				}
-				obj.Applies = append(obj.Applies, apply)
+				parseObjectApply(in, obj)
 			} else {
 				break
 			}
 		}
 
 		objects = append(objects, obj)
-	}
+	})
 	return objects
 }
 
-func parseRooms(in *input) []*Room {
-	rooms := []*Room{}
-	for {
-		in.expectLetter("#")
-		id := in.parseNumber()
-		if id == 0 {
-			break
-		}
-		room := &Room{
+// parseObjectExtra parses an extra description after its E marker
+func parseObjectExtra(in *input, obj *world.Object) {
+	extra := world.ObjectExtraDescription{
+		Keywords:    parseKeywords(in.parseString()),
+		Description: in.parseString(),
+	}
+	obj.Extras = append(obj.Extras, extra)
+}
+
+// parseObjectApply parses an apply after its A marker
+func parseObjectApply(in *input, obj *world.Object) {
+	apply := world.ObjectApply{
+		Type:  in.parseNumber(),
+		Value: in.parseNumber(),
+	}
+	obj.Applies = append(obj.Applies, apply)
+}
+
+func parseRooms(in *input) []*world.Room {
+	rooms := []*world.Room{}
+	in.records(func(id int) {
+		room := &world.Room{
 			ID:          id,
+			Vnum:        id,
 			Name:        in.parseString(),
 			Description: in.parseString(),
 			AreaID:      in.parseNumber(),
-			Flags:       in.parseNumber(),
+			Flags:       world.RoomFlags{BitSet: in.parseFlagSet()},
 			Terrain:     in.parseNumber(),
-			Doors:       []Door{},
-			Extras:      []RoomExtraDescription{},
+			Doors:       []world.Door{},
+			Extras:      []world.RoomExtraDescription{},
 		}
 	optionals:
 		for {
 			kind := in.parseLetter()
 			switch kind {
 			case "D":
-				door := Door{
-					RoomID:      room.ID,
-					Direction:   in.parseNumber(),
-					Description: in.parseString(),
-					Keywords:    parseKeywords(in.parseString()),
-					Lock:        in.parseNumber(),
-					Key:         in.parseNumber(),
-					ToRoom:      in.parseNumber(),
-				}
-				room.Doors = append(room.Doors, door)
+				parseDoor(in, room)
 			case "E":
-				extra := RoomExtraDescription{
-					Keywords:    parseKeywords(in.parseString()),
-					Description: in.parseString(),
-				}
-				room.Extras = append(room.Extras, extra)
+				parseRoomExtra(in, room)
 			case "S":
 				break optionals
 			default:
@@ -523,22 +649,57 @@ func parseRooms(in *input) []*Room {
 		}
 
 		rooms = append(rooms, room)
-	}
+	})
 	return rooms
 }
 
-func parseResets(in *input, areaID int) []*Reset {
+// parseDoor parses a door after its D marker
+func parseDoor(in *input, room *world.Room) {
+	loc := in.here()
+	door := world.Door{
+		RoomID:      room.ID,
+		Direction:   in.parseNumber(),
+		Description: in.parseString(),
+		Keywords:    parseKeywords(in.parseString()),
+		Lock:        in.parseNumber(),
+		Key:         in.parseNumber(),
+		ToRoom:      in.parseNumber(),
+	}
+	room.Doors = append(room.Doors, door)
+	in.report.mark(doorRef{room, len(room.Doors) - 1}, loc)
+}
+
+// parseRoomExtra parses an extra description after its E marker
+func parseRoomExtra(in *input, room *world.Room) {
+	extra := world.RoomExtraDescription{
+		Keywords:    parseKeywords(in.parseString()),
+		Description: in.parseString(),
+	}
+	room.Extras = append(room.Extras, extra)
+}
+
+// parseResets parses a RESETS section. Dialects that add reset types or
+// arguments can supply a hook, which is given the chance to handle each
+// reset (after its type letter is read) before the Merc rules apply.
+func parseResets(in *input, areaID int, hook func(in *input, reset *world.Reset) bool) []*world.Reset {
 	sequence := 1
-	resets := []*Reset{}
+	resets := []*world.Reset{}
 loop:
 	for {
-		reset := &Reset{
+		loc := in.here()
+		reset := &world.Reset{
 			Type:     in.parseLetter(),
 			AreaID:   areaID,
 			Sequence: sequence,
 		}
+		in.report.mark(reset, loc)
 		sequence++
 
+		if hook != nil && hook(in, reset) {
+			resets = append(resets, reset)
+			continue
+		}
+
 		switch reset.Type {
 		case "*":
 			reset.Comment = in.parseToEOL()
@@ -670,146 +831,153 @@ func parseKeywords(s string) []string {
 	return out
 }
 
-func makeRoll(dice, faces, plus int) []int {
-	if dice < 0 || faces < 0 || plus < 0 {
-		log.Fatalf("makeRoll: invalid inputs: dice=%d, faces=%d, plus=%d",
-			dice, faces, plus)
+func parsePosition(in *input) string {
+	n := in.parseNumber()
+	if n < 0 || n >= len(world.Positions) {
+		in.Failf("unknown position %d", n)
 	}
-	mean := float64(dice)*float64(faces+1)/2.0 + float64(plus)
-	stddev := math.Sqrt(float64(dice*(faces*faces-1)) / 12.0)
-	meanInt := int(100.0*mean + 0.5)
-	stddevInt := int(100.0*stddev + 0.5)
-
-	return []int{meanInt, stddevInt}
+	return world.Positions[n]
 }
 
-func writeAreaSQL(tx *sql.Tx, area *Area) {
-	area.ID = 0
-	if err := meddler.Insert(tx, "areas", area); err != nil {
-		log.Fatalf("insert area: %v", err)
+// mercSexes gives the pronouns for the sex numbers in Merc and SMAUG
+// mobiles
+var mercSexes = []string{"it", "he", "she"}
+
+func parseSex(in *input) string {
+	n := in.parseNumber()
+	if n < 0 || n >= len(mercSexes) {
+		in.Warnf("unknown sex %d", n)
+		return "it"
 	}
+	return mercSexes[n]
 }
 
-func writeHelpsSQL(tx *sql.Tx, area *Area) {
-	for _, help := range area.Helps {
-		help.AreaID = area.ID
-		help.ID = 0
-		if err := meddler.Insert(tx, "helps", help); err != nil {
-			log.Fatalf("insert help: %v", err)
-		}
-	}
+// armorRolls converts a Merc hitroll and armor class into dodge and
+// absorb rolls. Armor class runs from 10 (unarmored) down to -10, so the
+// better the armor, the more dice. Half of the protection goes to dodging
+// (helped by the hitroll, which measures fighting skill) and half goes to
+// absorbing blows that land.
+func armorRolls(hitroll, armor int) ([]int, []int) {
+	defense := 10 - armor
+	if defense < 0 {
+		defense = 0
+	}
+	skill := hitroll
+	if skill < 0 {
+		skill = 0
+	}
+	dodge := makeRoll(defense/2, 4, skill)
+	absorb := makeRoll((defense+1)/2, 4, 0)
+	return dodge, absorb
 }
 
-func writeRoomsSQL(tx *sql.Tx, area *Area, roomIDs map[int]int) {
-	for _, room := range area.Rooms {
-		oldID := room.ID
-		room.ID = 0
-		room.AreaID = area.ID
-		if err := meddler.Insert(tx, "rooms", room); err != nil {
-			log.Fatalf("insert room: %v", err)
-		}
-		roomIDs[oldID] = room.ID
+// parseDice parses a roll in the form 1d8+2 and converts it using makeRoll
+func (in *input) parseDice() []int {
+	dice := in.parseNumber()
+	if in.hasLetter("D") {
+		in.expectLetter("D")
+	} else {
+		in.expectLetter("d")
+	}
+	faces := in.parseNumber()
+	in.expectLetter("+")
+	plus := in.parseNumber()
+	if dice < 0 || faces < 0 || plus < 0 {
+		in.Failf("invalid dice roll: dice=%d, faces=%d, plus=%d", dice, faces, plus)
 	}
+	return makeRoll(dice, faces, plus)
 }
 
-func writeMobsSQL(tx *sql.Tx, area *Area, mobIDs map[int]int) {
-	for _, mob := range area.Mobiles {
-		oldID := mob.ID
-		mob.ID = 0
-		mob.AreaID = area.ID
-		if err := meddler.Insert(tx, "mobiles", mob); err != nil {
-			log.Fatalf("insert mobile: %v", err)
-		}
-		mobIDs[oldID] = mob.ID
-	}
+func makeRoll(dice, faces, plus int) []int {
+	mean := float64(dice)*float64(faces+1)/2.0 + float64(plus)
+	stddev := math.Sqrt(float64(dice*(faces*faces-1)) / 12.0)
+	meanInt := int(100.0*mean + 0.5)
+	stddevInt := int(100.0*stddev + 0.5)
+
+	return []int{meanInt, stddevInt}
 }
 
-func writeObjectsSQL(tx *sql.Tx, area *Area, objectIDs map[int]int) {
-	for _, object := range area.Objects {
-		oldID := object.ID
-		object.ID = 0
-		object.AreaID = area.ID
-		if err := meddler.Insert(tx, "objects", object); err != nil {
-			log.Fatalf("insert object: %v", err)
-		}
-		objectIDs[oldID] = object.ID
-	}
+// doorRef identifies a door in the report, since doors are stored by value
+type doorRef struct {
+	room  *world.Room
+	index int
 }
 
-func writeDoorsSQL(tx *sql.Tx, area *Area, roomIDs, objectIDs map[int]int) {
-	for _, room := range area.Rooms {
-		for _, door := range room.Doors {
-			door.RoomID = room.ID
-			if door.Key != 0 {
-				if _, exists := objectIDs[door.Key]; exists {
-					door.Key = objectIDs[door.Key]
-				} else {
-					if door.Key > 0 {
-						log.Printf("door from area %s requires key %d that does not exist",
-							area.Name, door.Key)
-					}
-					door.Key = 0
-				}
-			}
-			if door.ToRoom != 0 {
-				if _, exists := roomIDs[door.ToRoom]; exists {
-					door.ToRoom = roomIDs[door.ToRoom]
-				} else {
-					log.Printf("room from area %s has door to non-existent room %d",
-						area.Name, door.ToRoom)
-					door.ToRoom = 0
-				}
+// fixDoorReferences maps the key and target room of a door from vnums to
+// database IDs, dropping (and reporting) any that do not exist
+func fixDoorReferences(area *world.Area, room *world.Room, i int, roomIDs, objectIDs map[int]int, report *Report) {
+	door := &room.Doors[i]
+	loc := report.locate(doorRef{room, i})
+	if door.Key != 0 {
+		if _, exists := objectIDs[door.Key]; exists {
+			door.Key = objectIDs[door.Key]
+		} else {
+			if door.Key > 0 {
+				report.Warnf(loc, "door from area %s requires key %d that does not exist",
+					area.Name, door.Key)
 			}
-			door.ID = 0
-			if err := meddler.Insert(tx, "doors", &door); err != nil {
-				log.Fatalf("insert door: %v", err)
+			door.Key = 0
+		}
+	}
+	if door.ToRoom != 0 {
+		if _, exists := roomIDs[door.ToRoom]; exists {
+			door.ToRoom = roomIDs[door.ToRoom]
+		} else {
+			// -1 is how Merc writes an exit that leads nowhere
+			if door.ToRoom > 0 {
+				report.Warnf(loc, "room from area %s has door to non-existent room %d",
+					area.Name, door.ToRoom)
 			}
+			door.ToRoom = 0
 		}
 	}
 }
 
-func writeResetsSQL(tx *sql.Tx, area *Area, roomIDs, mobIDs, objectIDs map[int]int) {
-	for _, reset := range area.Resets {
-		reset.ID = 0
-		reset.AreaID = area.ID
-		if reset.RoomID != 0 {
-			if _, exists := roomIDs[reset.RoomID]; exists {
-				reset.RoomID = roomIDs[reset.RoomID]
-			} else {
-				log.Printf("area %s has a reset for room %d that does not exist",
-					area.Name, reset.RoomID)
-				reset.RoomID = 0
-			}
-		}
-		if reset.MobileID != 0 {
-			if _, exists := mobIDs[reset.MobileID]; exists {
-				reset.MobileID = mobIDs[reset.MobileID]
-			} else {
-				log.Printf("area %s has a reset for mobile %d that does not exist",
-					area.Name, reset.MobileID)
-				reset.MobileID = 0
-			}
+// fixResetReferences maps the rooms, mobiles, and objects named by a reset
+// from vnums to database IDs. It reports any that do not exist and gives
+// false if there were any.
+func fixResetReferences(area *world.Area, reset *world.Reset, roomIDs, mobIDs, objectIDs map[int]int, report *Report) bool {
+	loc := report.locate(reset)
+	ok := true
+	if reset.RoomID != 0 {
+		if _, exists := roomIDs[reset.RoomID]; exists {
+			reset.RoomID = roomIDs[reset.RoomID]
+		} else {
+			report.Warnf(loc, "area %s has a reset for room %d that does not exist",
+				area.Name, reset.RoomID)
+			reset.RoomID = 0
+			ok = false
 		}
-		if reset.ObjectID != 0 {
-			if _, exists := objectIDs[reset.ObjectID]; exists {
-				reset.ObjectID = objectIDs[reset.ObjectID]
-			} else {
-				log.Printf("area %s has a reset for object %d that does not exist",
-					area.Name, reset.ObjectID)
-				reset.ObjectID = 0
-			}
+	}
+	if reset.MobileID != 0 {
+		if _, exists := mobIDs[reset.MobileID]; exists {
+			reset.MobileID = mobIDs[reset.MobileID]
+		} else {
+			report.Warnf(loc, "area %s has a reset for mobile %d that does not exist",
+				area.Name, reset.MobileID)
+			reset.MobileID = 0
+			ok = false
 		}
-		if reset.ContainerID != 0 {
-			if _, exists := objectIDs[reset.ContainerID]; exists {
-				reset.ContainerID = objectIDs[reset.ContainerID]
-			} else {
-				log.Printf("area %s has a reset for container object %d that does not exist",
-					area.Name, reset.ContainerID)
-				reset.ContainerID = 0
-			}
+	}
+	if reset.ObjectID != 0 {
+		if _, exists := objectIDs[reset.ObjectID]; exists {
+			reset.ObjectID = objectIDs[reset.ObjectID]
+		} else {
+			report.Warnf(loc, "area %s has a reset for object %d that does not exist",
+				area.Name, reset.ObjectID)
+			reset.ObjectID = 0
+			ok = false
 		}
-		if err := meddler.Insert(tx, "resets", reset); err != nil {
-			log.Fatalf("insert reset: %v", err)
+	}
+	if reset.ContainerID != 0 {
+		if _, exists := objectIDs[reset.ContainerID]; exists {
+			reset.ContainerID = objectIDs[reset.ContainerID]
+		} else {
+			report.Warnf(loc, "area %s has a reset for container object %d that does not exist",
+				area.Name, reset.ContainerID)
+			reset.ContainerID = 0
+			ok = false
 		}
 	}
+	return ok
 }
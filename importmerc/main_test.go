@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/russross/gruffles/world"
+)
+
+// parseText parses an area file held in a string, collecting problems
+// instead of stopping at the first one
+func parseText(t *testing.T, dialect, text string) ([]*world.Area, *Report) {
+	t.Helper()
+	report := NewReport(true)
+	in := &input{
+		filename: "test.are",
+		data:     []byte(text),
+		rest:     []byte(text),
+		report:   report,
+	}
+	return parseFile(in, Dialects[dialect]), report
+}
+
+func checkDiagnostics(t *testing.T, report *Report, errors, warnings int) {
+	t.Helper()
+	if report.Errors != errors || report.Warnings != warnings {
+		for _, elt := range report.Diagnostics {
+			t.Logf("%s line %d: %s", elt.Severity, elt.Line, elt.Message)
+		}
+		t.Fatalf("found %d errors and %d warnings, expected %d and %d",
+			report.Errors, report.Warnings, errors, warnings)
+	}
+}
+
+// two mobiles from the stock Merc 2.2 midgaard.are
+const mercMobiles = `#AREA	{ All } Merc    Midgaard~
+
+#MOBILES
+#3000
+wizard~
+the wizard~
+A wizard walks around behind the counter, talking to himself.
+~
+The wizard looks old and senile, and yet he looks like a very powerful
+wizard.  He is equipped with fine clothing, and is wearing many fine
+rings and bracelets.
+~
+1|2|64 8|32 900 S
+33 20 -10 6d10+990 4d8+33
+10000 0
+8 8 1
+#3062
+fido dog~
+the beastly fido~
+A beastly fido is mucking through the garbage looking for food here.
+~
+The fido is a small dog that has a foul smell and pieces of rotted meat hanging
+around his teeth.
+~
+1|32|128 0 -200 S
+0 0 10 1d4+0 1d4+0
+0 0
+4 8 2
+#0
+
+#$
+`
+
+func TestParseMercMobiles(t *testing.T) {
+	areas, report := parseText(t, "merc", mercMobiles)
+	checkDiagnostics(t, report, 0, 0)
+	if len(areas) != 1 || len(areas[0].Mobiles) != 2 {
+		t.Fatalf("expected one area with two mobiles, found %d areas", len(areas))
+	}
+	wizard, fido := areas[0].Mobiles[0], areas[0].Mobiles[1]
+
+	if wizard.Vnum != 3000 || wizard.ID != 3000 {
+		t.Errorf("wizard vnum %d, id %d, expected 3000", wizard.Vnum, wizard.ID)
+	}
+	if !reflect.DeepEqual(wizard.Keywords, []string{"wizard"}) {
+		t.Errorf("wizard keywords %q", wizard.Keywords)
+	}
+	if wizard.ShortDescription != "the wizard" {
+		t.Errorf("wizard short description %q", wizard.ShortDescription)
+	}
+	if got := wizard.ActionFlags.Members(); !reflect.DeepEqual(got, []uint{0, 1, 6}) {
+		t.Errorf("wizard action flags %v, expected [0 1 6]", got)
+	}
+	if got := wizard.AffectedFlags.Members(); !reflect.DeepEqual(got, []uint{3, 5}) {
+		t.Errorf("wizard affected flags %v, expected [3 5]", got)
+	}
+	if wizard.Alignment != 900 || wizard.Level != 33 || wizard.HitBonus != 20 || wizard.Armor != -10 {
+		t.Errorf("wizard alignment %d, level %d, hitroll %d, armor %d",
+			wizard.Alignment, wizard.Level, wizard.HitBonus, wizard.Armor)
+	}
+	if !reflect.DeepEqual(wizard.HitRoll, makeRoll(6, 10, 990)) || !reflect.DeepEqual(wizard.DamageRoll, makeRoll(4, 8, 33)) {
+		t.Errorf("wizard hit roll %v, damage roll %v", wizard.HitRoll, wizard.DamageRoll)
+	}
+	if wizard.Gold != 10000 || wizard.Experience != 0 {
+		t.Errorf("wizard gold %d, experience %d", wizard.Gold, wizard.Experience)
+	}
+	if wizard.StartPosition != "standing" || wizard.DefaultPosition != "standing" || wizard.Pronouns != "he" {
+		t.Errorf("wizard positions %q and %q, pronouns %q", wizard.StartPosition, wizard.DefaultPosition, wizard.Pronouns)
+	}
+
+	if fido.Vnum != 3062 || fido.StartPosition != "sleeping" || fido.DefaultPosition != "standing" || fido.Pronouns != "she" {
+		t.Errorf("fido vnum %d, positions %q and %q, pronouns %q",
+			fido.Vnum, fido.StartPosition, fido.DefaultPosition, fido.Pronouns)
+	}
+	if fido.Alignment != -200 || fido.Armor != 10 {
+		t.Errorf("fido alignment %d, armor %d", fido.Alignment, fido.Armor)
+	}
+}
+
+func TestParseMercPosition(t *testing.T) {
+	for n, name := range []string{"dead", "mortal", "incapacitated", "stunned", "sleeping", "resting", "sitting", "fighting", "standing"} {
+		report := NewReport(true)
+		text := []byte(string(rune('0'+n)) + " ")
+		in := &input{filename: "test.are", data: text, rest: text, report: report}
+		var got string
+		if !in.try(func() { got = parsePosition(in) }) || got != name {
+			t.Errorf("position %d parsed as %q, expected %q", n, got, name)
+		}
+	}
+
+	report := NewReport(true)
+	text := []byte("9 ")
+	in := &input{filename: "test.are", data: text, rest: text, report: report}
+	if in.try(func() { parsePosition(in) }) || report.Errors != 1 {
+		t.Errorf("position 9 was accepted")
+	}
+}
+
+func smaugMobile(vnum int, sex string) string {
+	return "#" + strconv.Itoa(vnum) + `
+guard~
+a guard~
+A guard stands here.
+~
+He looks bored.
+~
+1 0 0 S
+10 20 5 2d8+50 1d6+2
+100 0
+112 112 ` + sex + `
+`
+}
+
+func TestParseSMAUGSex(t *testing.T) {
+	text := "#AREA Test~\n\n#MOBILES\n" +
+		smaugMobile(100, "2") + smaugMobile(101, "3") + smaugMobile(102, "-1") +
+		"#0\n\n#$\n"
+	areas, report := parseText(t, "smaug", text)
+	checkDiagnostics(t, report, 0, 2)
+	if len(areas) != 1 || len(areas[0].Mobiles) != 3 {
+		t.Fatalf("expected one area with three mobiles")
+	}
+	for i, expected := range []string{"she", "it", "it"} {
+		if got := areas[0].Mobiles[i].Pronouns; got != expected {
+			t.Errorf("mobile %d has pronouns %q, expected %q", areas[0].Mobiles[i].Vnum, got, expected)
+		}
+	}
+	for _, elt := range report.Diagnostics {
+		if elt.Message != "unknown sex 3" && elt.Message != "unknown sex -1" {
+			t.Errorf("unexpected diagnostic: %s", elt.Message)
+		}
+	}
+}
+
+func TestWriteDBWithErrors(t *testing.T) {
+	// the fido has an unknown position, so only the wizard is parsed
+	text := strings.Replace(mercMobiles, "4 8 2\n", "4 9 2\n", 1)
+	for _, partial := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts, err := filepath.Glob("../setup/migrations/*.up.sql")
+		if err != nil || len(scripts) == 0 {
+			t.Fatalf("finding migrations: %v", err)
+		}
+		sort.Strings(scripts)
+		for _, script := range scripts {
+			raw, err := ioutil.ReadFile(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(string(raw)); err != nil {
+				t.Fatalf("%s: %v", script, err)
+			}
+		}
+
+		areas, report := parseText(t, "merc", text)
+		checkDiagnostics(t, report, 1, 0)
+		writeDB(path, areas, map[*world.Area]string{}, report, partial)
+
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM mobiles`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		if expected := map[bool]int{false: 0, true: 1}[partial]; count != expected {
+			t.Errorf("with partial %v, wrote %d mobiles, expected %d", partial, count, expected)
+		}
+	}
+}
+
+func TestCheckReferencesDropsBrokenResets(t *testing.T) {
+	text := strings.Replace(mercMobiles, "#0\n\n#$\n", "#0\n\n", 1) + `#ROOMS
+#3001
+The Temple~
+A temple.
+~
+0 8 0
+D0
+~
+~
+0 -1 3002
+D1
+~
+~
+0 -1 -1
+D2
+~
+~
+0 -1 9999
+S
+#3002
+North~
+North room.
+~
+0 0 1
+S
+#0
+
+#RESETS
+M 0 3000 1 3001
+G 0 3000 1
+M 0 3000 1 9999
+G 0 3000 1
+E 0 3000 1 16
+M 0 3062 1 3002
+S
+
+#$
+`
+	areas, report := parseText(t, "merc", text)
+	checkDiagnostics(t, report, 0, 0)
+	checkReferences(areas, report)
+
+	// the door to 9999, the give after the first mobile (no object 3000),
+	// the mobile in room 9999, and the give and equip after it
+	checkDiagnostics(t, report, 0, 5)
+	area := areas[0]
+	doors := area.Rooms[0].Doors
+	if len(doors) != 3 || doors[0].ToRoom != 3002 || doors[1].ToRoom != 0 || doors[2].ToRoom != 0 {
+		t.Errorf("doors are %+v", doors)
+	}
+	var kept []string
+	for _, reset := range area.Resets {
+		kept = append(kept, reset.Type+strconv.Itoa(reset.RoomID))
+	}
+	if !reflect.DeepEqual(kept, []string{"M3001", "M3002"}) {
+		t.Errorf("kept resets %v, expected the two mobile resets in real rooms", kept)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/russross/gruffles/world"
+)
+
+// ROM 2.4 area files. Stock ROM starts with an #AREA header that also
+// names the file, the credits, and the vnum range, and OLC-edited files
+// use #AREADATA instead. Mobiles and objects use a new layout with
+// letter flags and words for many values, while #MOBOLD and #OBJOLD
+// hold records in the Merc layout.
+func romDialect() *Dialect {
+	return &Dialect{
+		Name: "rom",
+		Sections: map[string]sectionFunc{
+			"AREA":     romArea,
+			"AREADATA": romAreaData,
+			"HELPS":    sectionHelps,
+			"MOBOLD":   func(in *input, file *areaFile) { sectionMobiles(in, file, parseMobiles) },
+			"MOBILES":  func(in *input, file *areaFile) { sectionMobiles(in, file, parseROMMobiles) },
+			"OBJOLD":   func(in *input, file *areaFile) { sectionObjects(in, file, parseObjects) },
+			"OBJECTS":  func(in *input, file *areaFile) { sectionObjects(in, file, parseROMObjects) },
+			"ROOMS":    func(in *input, file *areaFile) { sectionRooms(in, file, parseROMRooms) },
+			"RESETS":   func(in *input, file *areaFile) { sectionResets(in, file, romReset) },
+			"SHOPS":    sectionShops,
+			"SPECIALS": sectionSpecials,
+			"MOBPROGS": romMobPrograms,
+			"SOCIALS": func(in *input, file *areaFile) {
+				in.skipSection("SOCIALS", "#0")
+			},
+		},
+	}
+}
+
+var romItemTypes = []string{
+	"", "light", "scroll", "wand", "staff", "weapon", "", "", "treasure", "armor",
+	"potion", "clothing", "furniture", "trash", "", "container", "", "drink", "key", "food",
+	"money", "", "boat", "npc_corpse", "pc_corpse", "fountain", "pill", "protect", "map", "portal",
+	"warp_stone", "room_key", "gem", "jewelry", "jukebox",
+}
+
+const (
+	romItemScroll    = 2
+	romItemWand      = 3
+	romItemStaff     = 4
+	romItemWeapon    = 5
+	romItemPotion    = 10
+	romItemContainer = 15
+	romItemDrink     = 17
+	romItemFountain  = 25
+	romItemPill      = 26
+)
+
+var romWeaponClasses = []string{
+	"exotic", "sword", "dagger", "spear", "mace", "axe", "flail", "whip", "polearm",
+}
+
+var romAttackTypes = []string{
+	"none", "slice", "stab", "slash", "whip", "claw", "blast", "pound", "crush", "grep",
+	"bite", "pierce", "suction", "beating", "digestion", "charge", "slap", "punch", "wrath", "magic",
+	"divine", "cleave", "scratch", "peck", "peckb", "chop", "sting", "smash", "shbite", "flbite",
+	"frbite", "acbite", "chomp", "drain", "thrust", "slime", "shock", "thwack", "flame", "chill",
+}
+
+var romLiquids = []string{
+	"water", "beer", "red wine", "ale", "dark ale", "whisky", "lemonade", "firebreather", "local specialty", "slime mold juice",
+	"milk", "tea", "coffee", "blood", "salt water", "coke", "root beer", "elvish wine", "white wine", "champagne",
+	"mead", "rose wine", "benedictine wine", "vodka", "cranberry juice", "orange juice", "absinthe", "brandy", "aquavit", "schnapps",
+	"icewine", "amontillado", "sherry", "framboise", "rum", "cordial",
+}
+
+var romSexes = map[string]string{
+	"none":    "it",
+	"neutral": "it",
+	"male":    "he",
+	"female":  "she",
+	"either":  "they",
+}
+
+func romArea(in *input, file *areaFile) {
+	// file name, area name, credits, low and high vnums
+	in.parseString()
+	name := in.parseString()
+	in.parseString()
+	in.parseNumber()
+	in.parseNumber()
+	file.startArea(name)
+}
+
+func romAreaData(in *input, file *areaFile) {
+	area := file.startArea("")
+	for {
+		key := in.parseWord()
+		switch key {
+		case "Name":
+			area.Name = in.parseString()
+		case "Builders", "Credits":
+			in.parseString()
+		case "VNUMs":
+			in.parseNumber()
+			in.parseNumber()
+		case "Security", "Recall", "Flags":
+			in.parseNumber()
+		case "End":
+			if area.Name == "" {
+				in.Warnf("AREADATA has no name")
+			}
+			return
+		default:
+			in.Warnf("unknown AREADATA field %q", key)
+			in.parseToEOL()
+		}
+	}
+}
+
+// romPosition looks up a position word like "stand", which ROM matches
+// as a prefix of the full name
+func romPosition(in *input) string {
+	word := strings.ToLower(in.parseWord())
+	for _, position := range world.Positions {
+		if strings.HasPrefix(position, word) {
+			return position
+		}
+	}
+	in.Warnf("unknown position %q", word)
+	return "standing"
+}
+
+func parseROMMobiles(in *input) []*world.Mobile {
+	mobiles := []*world.Mobile{}
+	in.records(func(id int) {
+		keywords := in.parseString()
+		short := in.parseString()
+		long := in.parseString()
+		desc := in.parseString()
+		// race
+		in.parseString()
+		actionFlags := world.ActFlags{BitSet: in.parseFlagSet()}
+		affFlags := world.AffectFlags{BitSet: in.parseFlagSet()}
+		alignment := in.parseNumber()
+		// group
+		in.parseNumber()
+		level := in.parseNumber()
+		hitroll := in.parseNumber()
+		hitDice := in.parseDice()
+		// mana
+		in.parseDice()
+		damDice := in.parseDice()
+		// damage type
+		in.parseWord()
+
+		// armor class against pierce, bash, slash, and exotic
+		// attacks. we only keep the average of the physical ones
+		armor := (in.parseNumber() + in.parseNumber() + in.parseNumber()) / 3
+		in.parseNumber()
+
+		// offensive, immunity, resistance, and vulnerability flags
+		for i := 0; i < 4; i++ {
+			in.parseFlags()
+		}
+		startPosition := romPosition(in)
+		defaultPosition := romPosition(in)
+		sexWord := strings.ToLower(in.parseWord())
+		sex, exists := romSexes[sexWord]
+		if !exists {
+			in.Warnf("unknown sex %q", sexWord)
+			sex = "it"
+		}
+		gold := in.parseNumber()
+		// form, parts, size, material
+		in.parseFlags()
+		in.parseFlags()
+		in.parseWord()
+		in.parseWord()
+
+		// flag removal and mob programs
+		for {
+			if in.hasLetter("F") {
+				in.expectLetter("F")
+				kind := in.parseWord()
+				flags := in.parseFlagSet()
+				switch {
+				case strings.HasPrefix(kind, "act"):
+					actionFlags.Difference(flags)
+				case strings.HasPrefix(kind, "aff"):
+					affFlags.Difference(flags)
+				}
+			} else if in.hasLetter("M") {
+				in.expectLetter("M")
+				in.parseWord()
+				in.parseNumber()
+				in.parseString()
+			} else {
+				break
+			}
+		}
+
+		dodge, absorb := armorRolls(hitroll, armor)
+		mob := &world.Mobile{
+			ID:               id,
+			Vnum:             id,
+			Keywords:         parseKeywords(keywords),
+			ShortDescription: short,
+			LongDescription:  long,
+			Description:      desc,
+			ActionFlags:      actionFlags,
+			AffectedFlags:    affFlags,
+			Alignment:        alignment,
+			Level:            level,
+			HitBonus:         hitroll,
+			Armor:            armor,
+			HitRoll:          hitDice,
+			DamageRoll:       damDice,
+			DodgeRoll:        dodge,
+			AbsorbRoll:       absorb,
+			FireRoll:         makeRoll(0, 0, 0),
+			IceRoll:          makeRoll(0, 0, 0),
+			PoisonRoll:       makeRoll(0, 0, 0),
+			LightningRoll:    makeRoll(0, 0, 0),
+			Gold:             gold,
+			Pronouns:         sex,
+			StartPosition:    startPosition,
+			DefaultPosition:  defaultPosition,
+		}
+		mobiles = append(mobiles, mob)
+	})
+	return mobiles
+}
+
+// romSpell reads a spell name. We have no spell table to map it to, so
+// the name is noted and dropped.
+func romSpell(in *input) int {
+	name := in.parseQuotedWord()
+	if name != "" && name != "0" && name != "-1" {
+		in.Warnf("dropping spell %q from object values", name)
+	}
+	return 0
+}
+
+func parseROMObjects(in *input) []*world.Object {
+	objects := []*world.Object{}
+	in.records(func(id int) {
+		keywords := parseKeywords(in.parseString())
+		shortDescription := in.parseString()
+		longDescription := in.parseString()
+		// material
+		in.parseString()
+		itemType := in.lookup("item type", in.parseWord(), romItemTypes)
+		extraFlags := world.ExtraFlags{BitSet: in.parseFlagSet()}
+		wearFlags := world.WearFlags{BitSet: in.parseFlagSet()}
+
+		// the meaning (and notation) of the values depends on the item type
+		v := make([]int, 5)
+		switch itemType {
+		case romItemWeapon:
+			v[0] = in.lookup("weapon class", in.parseWord(), romWeaponClasses)
+			v[1] = in.parseNumber()
+			v[2] = in.parseNumber()
+			v[3] = in.lookup("attack type", in.parseQuotedWord(), romAttackTypes)
+			v[4] = in.parseFlags()
+		case romItemContainer:
+			v[0] = in.parseNumber()
+			v[1] = in.parseFlags()
+			v[2] = in.parseNumber()
+			v[3] = in.parseNumber()
+			v[4] = in.parseNumber()
+		case romItemDrink, romItemFountain:
+			v[0] = in.parseNumber()
+			v[1] = in.parseNumber()
+			v[2] = in.lookup("liquid", in.parseQuotedWord(), romLiquids)
+			v[3] = in.parseNumber()
+			v[4] = in.parseNumber()
+		case romItemWand, romItemStaff:
+			v[0] = in.parseNumber()
+			v[1] = in.parseNumber()
+			v[2] = in.parseNumber()
+			v[3] = romSpell(in)
+			v[4] = in.parseNumber()
+		case romItemPotion, romItemPill, romItemScroll:
+			v[0] = in.parseNumber()
+			v[1] = romSpell(in)
+			v[2] = romSpell(in)
+			v[3] = romSpell(in)
+			v[4] = romSpell(in)
+		default:
+			for i := range v {
+				v[i] = in.parseFlags()
+			}
+		}
+
+		// level
+		in.parseNumber()
+		weight := in.parseNumber()
+		cost := in.parseNumber()
+		// condition
+		in.parseLetter()
+
+		obj := &world.Object{
+			ID:               id,
+			Vnum:             id,
+			Keywords:         keywords,
+			ShortDescription: shortDescription,
+			LongDescription:  longDescription,
+			ItemType:         itemType,
+			ExtraFlags:       extraFlags,
+			WearFlags:        wearFlags,
+			Value0:           v[0],
+			Value1:           v[1],
+			Value2:           v[2],
+			Value3:           v[3],
+			Value4:           v[4],
+			Weight:           weight,
+			Cost:             cost,
+			Extras:           []world.ObjectExtraDescription{},
+			Applies:          []world.ObjectApply{},
+		}
+		for {
+			if in.hasLetter("E") {
+				in.expectLetter("E")
+				parseObjectExtra(in, obj)
+			} else if in.hasLetter("A") {
+				in.expectLetter("A")
+				parseObjectApply(in, obj)
+			} else if in.hasLetter("F") {
+				// an affect that sets flags on the wearer
+				in.expectLetter("F")
+				in.parseLetter()
+				in.parseNumber()
+				in.parseNumber()
+				in.parseFlags()
+			} else {
+				break
+			}
+		}
+
+		objects = append(objects, obj)
+	})
+	return objects
+}
+
+func parseROMRooms(in *input) []*world.Room {
+	rooms := []*world.Room{}
+	in.records(func(id int) {
+		room := &world.Room{
+			ID:          id,
+			Vnum:        id,
+			Name:        in.parseString(),
+			Description: in.parseString(),
+			AreaID:      in.parseNumber(),
+			Flags:       world.RoomFlags{BitSet: in.parseFlagSet()},
+			Terrain:     in.parseNumber(),
+			Doors:       []world.Door{},
+			Extras:      []world.RoomExtraDescription{},
+		}
+	optionals:
+		for {
+			kind := in.parseLetter()
+			switch kind {
+			case "D":
+				parseDoor(in, room)
+			case "E":
+				parseRoomExtra(in, room)
+			case "H", "M":
+				// heal and mana rates
+				in.parseNumber()
+			case "C", "O":
+				// clan and owner
+				in.parseString()
+			case "S":
+				break optionals
+			default:
+				in.Failf("unknown optional field %q in room", kind)
+			}
+		}
+
+		rooms = append(rooms, room)
+	})
+	return rooms
+}
+
+// romReset handles the extra argument that ROM adds to the end of M
+// resets (the limit per room) and P resets (the count per container)
+func romReset(in *input, reset *world.Reset) bool {
+	switch reset.Type {
+	case "M":
+		in.parseNumber()
+		reset.MobileID = in.parseNumber()
+		reset.MaxInstances = in.parseNumber()
+		reset.RoomID = in.parseNumber()
+		in.parseNumber()
+	case "P":
+		in.parseNumber()
+		reset.ObjectID = in.parseNumber()
+		in.parseNumber()
+		reset.ContainerID = in.parseNumber()
+		in.parseNumber()
+	default:
+		return false
+	}
+	reset.Comment = in.parseToEOL()
+	return true
+}
+
+func romMobPrograms(in *input, file *areaFile) {
+	count := 0
+	in.records(func(id int) {
+		in.parseString()
+		count++
+	})
+	log.Printf("skipped %d MOBPROGS", count)
+}
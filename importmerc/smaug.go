@@ -0,0 +1,315 @@
+package main
+
+import (
+	"log"
+
+	"github.com/russross/gruffles/world"
+)
+
+// SMAUG area files. The header is spread across several small sections
+// (#AUTHOR, #RANGES, #FLAGS, and so on), records read several fields a
+// line at a time so trailing values are optional, and mobiles, objects,
+// and rooms can carry mud programs.
+func smaugDialect() *Dialect {
+	return &Dialect{
+		Name: "smaug",
+		Sections: map[string]sectionFunc{
+			"AREA": func(in *input, file *areaFile) {
+				file.startArea(in.parseString())
+			},
+			"VERSION": func(in *input, file *areaFile) {
+				file.version = in.parseNumber()
+			},
+			"AUTHOR":   smaugAreaString,
+			"RESETMSG": smaugAreaString,
+			"NEIGHBOR": smaugAreaString,
+			"RANGES":   smaugRanges,
+			"FLAGS":    smaugAreaNumbers,
+			"ECONOMY":  smaugAreaNumbers,
+			"CLIMATE":  smaugAreaNumbers,
+			"HELPS":    sectionHelps,
+			"MOBILES": func(in *input, file *areaFile) {
+				sectionMobiles(in, file, parseSMAUGMobiles)
+			},
+			"OBJECTS": func(in *input, file *areaFile) {
+				sectionObjects(in, file, func(in *input) []*world.Object { return parseSMAUGObjects(in, file.version) })
+			},
+			"ROOMS":    func(in *input, file *areaFile) { sectionRooms(in, file, parseSMAUGRooms) },
+			"RESETS":   func(in *input, file *areaFile) { sectionResets(in, file, smaugReset) },
+			"SHOPS":    sectionShops,
+			"REPAIRS":  smaugRepairs,
+			"SPECIALS": sectionSpecials,
+		},
+	}
+}
+
+// positions in the numbering used by SMAUG, which are written to area
+// files with 100 added to tell them apart from the Diku numbering
+var smaugPositions = []string{
+	"dead", "mortal", "incapacitated", "stunned", "sleeping", "fighting", "resting", "fighting", "sitting", "fighting",
+	"fighting", "fighting", "standing", "standing", "standing", "standing",
+}
+
+const (
+	smaugItemScroll = 2
+	smaugItemWand   = 3
+	smaugItemStaff  = 4
+	smaugItemPotion = 10
+	smaugItemPill   = 26
+)
+
+func smaugAreaString(in *input, file *areaFile) {
+	file.current(in, "area header")
+	in.parseString()
+}
+
+func smaugAreaNumbers(in *input, file *areaFile) {
+	file.current(in, "area header")
+	in.parseNumbersToEOL(1, 10)
+}
+
+func smaugRanges(in *input, file *areaFile) {
+	file.current(in, "RANGES")
+	for !in.hasLetter("$") {
+		in.parseNumbersToEOL(4, 4)
+	}
+	in.expectLetter("$")
+}
+
+func smaugPosition(in *input, n int) string {
+	switch {
+	case n >= 100 && n-100 < len(smaugPositions):
+		return smaugPositions[n-100]
+	case n >= 0 && n < len(world.Positions):
+		return world.Positions[n]
+	}
+	in.Warnf("unknown position %d", n)
+	return "standing"
+}
+
+func parseSMAUGMobiles(in *input) []*world.Mobile {
+	mobiles := []*world.Mobile{}
+	in.records(func(id int) {
+		keywords := in.parseString()
+		short := in.parseString()
+		long := in.parseString()
+		desc := in.parseString()
+		actionFlags := world.ActFlags{BitSet: in.parseFlagSet()}
+		affFlags := world.AffectFlags{BitSet: in.parseFlagSet()}
+		alignment := in.parseNumber()
+		complex := false
+		switch kind := in.parseLetter(); kind {
+		case "S":
+		case "C":
+			complex = true
+		default:
+			in.Failf("unknown mobile type %q", kind)
+		}
+		level := in.parseNumber()
+		// thac0
+		in.parseNumber()
+		armor := in.parseNumber()
+		hitDice := in.parseDice()
+		damDice := in.parseDice()
+		gold := in.parseNumber()
+		exp := in.parseNumber()
+		startPosition := smaugPosition(in, in.parseNumber())
+		defaultPosition := smaugPosition(in, in.parseNumber())
+		sex := parseSex(in)
+
+		hitroll := 0
+		if complex {
+			// attributes, saving throws, and race/class/size
+			in.parseNumbersToEOL(7, 7)
+			in.parseNumbersToEOL(5, 5)
+			in.parseNumbersToEOL(7, 7)
+			fields := in.parseNumbersToEOL(2, 8)
+			hitroll = fields[0]
+		}
+		if in.hasLetter(">") {
+			in.skipPrograms()
+		}
+
+		dodge, absorb := armorRolls(hitroll, armor)
+		mob := &world.Mobile{
+			ID:               id,
+			Vnum:             id,
+			Keywords:         parseKeywords(keywords),
+			ShortDescription: short,
+			LongDescription:  long,
+			Description:      desc,
+			ActionFlags:      actionFlags,
+			AffectedFlags:    affFlags,
+			Alignment:        alignment,
+			Level:            level,
+			HitBonus:         hitroll,
+			Armor:            armor,
+			HitRoll:          hitDice,
+			DamageRoll:       damDice,
+			DodgeRoll:        dodge,
+			AbsorbRoll:       absorb,
+			FireRoll:         makeRoll(0, 0, 0),
+			IceRoll:          makeRoll(0, 0, 0),
+			PoisonRoll:       makeRoll(0, 0, 0),
+			LightningRoll:    makeRoll(0, 0, 0),
+			Gold:             gold,
+			Experience:       exp,
+			Pronouns:         sex,
+			StartPosition:    startPosition,
+			DefaultPosition:  defaultPosition,
+		}
+		mobiles = append(mobiles, mob)
+	})
+	return mobiles
+}
+
+func parseSMAUGObjects(in *input, version int) []*world.Object {
+	objects := []*world.Object{}
+	in.records(func(id int) {
+		keywords := parseKeywords(in.parseString())
+		shortDescription := in.parseString()
+		longDescription := in.parseString()
+		actionDescription := in.parseString()
+
+		// type, extra flags, wear flags, and optional layers
+		header := in.parseNumbersToEOL(3, 4)
+		v := in.parseNumbersToEOL(4, 6)
+		costs := in.parseNumbersToEOL(3, 4)
+
+		// newer files name spells instead of using slot numbers
+		if version >= 1 {
+			switch header[0] {
+			case smaugItemPotion, smaugItemPill, smaugItemScroll:
+				v[1], v[2], v[3] = romSpell(in), romSpell(in), romSpell(in)
+			case smaugItemWand, smaugItemStaff:
+				v[3] = romSpell(in)
+			}
+		}
+
+		obj := &world.Object{
+			ID:                id,
+			Vnum:              id,
+			Keywords:          keywords,
+			ShortDescription:  shortDescription,
+			LongDescription:   longDescription,
+			ActionDescription: actionDescription,
+			ItemType:          header[0],
+			ExtraFlags:        world.ExtraFlags{BitSet: world.BitSetFromInt(header[1])},
+			WearFlags:         world.WearFlags{BitSet: world.BitSetFromInt(header[2])},
+			Value0:            v[0],
+			Value1:            v[1],
+			Value2:            v[2],
+			Value3:            v[3],
+			Value4:            v[4],
+			Value5:            v[5],
+			Weight:            costs[0],
+			Cost:              costs[1],
+			CostPerDay:        costs[2],
+			Extras:            []world.ObjectExtraDescription{},
+			Applies:           []world.ObjectApply{},
+		}
+		for {
+			if in.hasLetter("E") {
+				in.expectLetter("E")
+				parseObjectExtra(in, obj)
+			} else if in.hasLetter("A") {
+				in.expectLetter("A")
+				parseObjectApply(in, obj)
+			} else if in.hasLetter(">") {
+				in.skipPrograms()
+			} else {
+				break
+			}
+		}
+
+		objects = append(objects, obj)
+	})
+	return objects
+}
+
+func parseSMAUGRooms(in *input) []*world.Room {
+	rooms := []*world.Room{}
+	in.records(func(id int) {
+		room := &world.Room{
+			ID:          id,
+			Vnum:        id,
+			Name:        in.parseString(),
+			Description: in.parseString(),
+			Doors:       []world.Door{},
+			Extras:      []world.RoomExtraDescription{},
+		}
+
+		// area number, flags, sector, and optional teleport and tunnel fields
+		fields := in.parseNumbersToEOL(3, 6)
+		room.AreaID, room.Terrain = fields[0], fields[2]
+		room.Flags = world.RoomFlags{BitSet: world.BitSetFromInt(fields[1])}
+
+	optionals:
+		for {
+			if in.hasLetter(">") {
+				in.skipPrograms()
+				continue
+			}
+			kind := in.parseLetter()
+			switch kind {
+			case "D":
+				loc := in.here()
+				door := world.Door{
+					RoomID:      room.ID,
+					Direction:   in.parseNumber(),
+					Description: in.parseString(),
+					Keywords:    parseKeywords(in.parseString()),
+				}
+				// lock flags, key, destination, and optional distance
+				fields := in.parseNumbersToEOL(3, 4)
+				door.Lock, door.Key, door.ToRoom = fields[0], fields[1], fields[2]
+				room.Doors = append(room.Doors, door)
+				in.report.mark(doorRef{room, len(room.Doors) - 1}, loc)
+			case "E":
+				parseRoomExtra(in, room)
+			case "M":
+				// overland map coordinates
+				in.parseNumbersToEOL(0, 4)
+			case "S":
+				break optionals
+			default:
+				in.Failf("unknown optional field %q in room", kind)
+			}
+		}
+
+		rooms = append(rooms, room)
+	})
+	return rooms
+}
+
+// smaugReset handles the reset types that SMAUG adds: traps (T), hidden
+// objects (H), and bit toggles (B). Only hidden objects fit our model;
+// the others are kept as comments.
+func smaugReset(in *input, reset *world.Reset) bool {
+	switch reset.Type {
+	case "T", "B":
+		reset.Comment = in.parseToEOL()
+	case "H":
+		in.parseNumber()
+		reset.ObjectID = in.parseNumber()
+		reset.Comment = in.parseToEOL()
+	default:
+		return false
+	}
+	return true
+}
+
+func smaugRepairs(in *input, file *areaFile) {
+	file.current(in, "REPAIRS")
+	count := 0
+	for {
+		keeper := in.parseNumber()
+		if keeper == 0 {
+			break
+		}
+		// fix types, profit, shop type, and hours
+		in.parseToEOL()
+		count++
+	}
+	log.Printf("found %d REPAIRS", count)
+}
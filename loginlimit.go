@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/russross/meddler"
+)
+
+// Failed sign-ins are tracked by username and by client address. After a
+// few failures, each new failure locks that username or address out for
+// twice as long as the last one, up to a limit. Addresses get more room
+// than usernames since many players can share one.
+const (
+	loginFreeFailuresUser   = 3
+	loginFreeFailuresClient = 10
+	loginFirstLockout       = 2 * time.Second
+	loginMaxLockout         = 15 * time.Minute
+
+	// failures are forgotten after this long with no new ones
+	loginFailureMemory = time.Hour
+)
+
+type loginFailures struct {
+	Count       int
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+type loginLimiter struct {
+	sync.Mutex
+	users   map[string]*loginFailures
+	clients map[string]*loginFailures
+}
+
+var loginLimits = &loginLimiter{
+	users:   make(map[string]*loginFailures),
+	clients: make(map[string]*loginFailures),
+}
+
+// Wait reports how long a username or client is locked out for
+func (limiter *loginLimiter) Wait(username, client string) time.Duration {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, elt := range []*loginFailures{limiter.users[username], limiter.clients[client]} {
+		if elt != nil && elt.LockedUntil.Sub(now) > wait {
+			wait = elt.LockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// Failure records a failed sign-in
+func (limiter *loginLimiter) Failure(username, client string) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	now := time.Now()
+	limiter.prune(now)
+	record := func(m map[string]*loginFailures, key string, free int) {
+		elt := m[key]
+		if elt == nil {
+			elt = new(loginFailures)
+			m[key] = elt
+		}
+		elt.Count++
+		elt.Last = now
+		if elt.Count > free {
+			lockout := loginMaxLockout
+			if shift := uint(elt.Count - free - 1); shift < 20 && loginFirstLockout<<shift < loginMaxLockout {
+				lockout = loginFirstLockout << shift
+			}
+			elt.LockedUntil = now.Add(lockout)
+		}
+	}
+	record(limiter.users, username, loginFreeFailuresUser)
+	record(limiter.clients, client, loginFreeFailuresClient)
+}
+
+// Success clears the failures for a username. Failures from the client
+// address are kept, so signing in to one account does not reset the count
+// for guesses at others.
+func (limiter *loginLimiter) Success(username string) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	delete(limiter.users, username)
+}
+
+func (limiter *loginLimiter) prune(now time.Time) {
+	for _, m := range []map[string]*loginFailures{limiter.users, limiter.clients} {
+		for key, elt := range m {
+			if now.Sub(elt.Last) > loginFailureMemory && now.After(elt.LockedUntil) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// LoginAttempt is an audit record of a sign-in attempt
+type LoginAttempt struct {
+	ID          int64     `meddler:"id,pk"`
+	Username    string    `meddler:"username"`
+	UserID      int64     `meddler:"user_id,zeroisnull"`
+	Client      string    `meddler:"client"`
+	Succeeded   bool      `meddler:"succeeded"`
+	Reason      string    `meddler:"reason"`
+	AttemptedAt time.Time `meddler:"attempted_at"`
+}
+
+// recordLoginAttempt writes an audit record. It does not use the request
+// transaction, since that is rolled back when the sign-in fails, so it
+// runs in the background and waits for the transaction to finish.
+func recordLoginAttempt(db *sql.DB, attempt *LoginAttempt) {
+	attempt.AttemptedAt = time.Now()
+	go func() {
+		if err := meddler.Insert(db, "login_attempts", attempt); err != nil {
+			log.Printf("recording login attempt for %q: %v", attempt.Username, err)
+		}
+	}()
+}
@@ -2,76 +2,62 @@ package main
 
 import (
 	"container/heap"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"math/rand"
-	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/russross/gruffles/world"
 	"github.com/russross/meddler"
 )
 
-var Config struct {
-	Hostname         string `json:"hostname"`
-	Database         string `json:"database"`
-	LetsEncryptCache string `json:"letsEncryptCache"`
-	LetsEncryptEmail string `json:"letsEncryptEmail"`
-	ClientDir        string `json:"clientDir"`
-	SessionSecret    string `json:"sessionSecret"`
-	SessionSeconds   int    `json:"sessionSeconds"`
-	CookieName       string `json:"cookieName"`
-}
-
 type State struct {
-	Areas  []*Area
-	Rooms  []*Room
-	Events Queue
+	Areas     []*world.Area
+	Helps     []*world.Help
+	Rooms     []*world.Room
+	RoomVnums map[int]*world.Room
+	Mobs      []*Mob
+	Events    Queue
+	DB        *sql.DB
+
+	// areas with unsaved changes from builders
+	Changed map[*world.Area]bool
+
+	// the shutdown countdown, if any, and the shutdown once it is underway
+	Shutdown *Shutdown
+	Stopped  *Shutdown
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
+	// subcommands for working with area files and recordings
+	if len(os.Args) > 1 {
+		if cmd, present := subcommands[os.Args[1]]; present {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	// load config
-	var configFile string
+	configFile, required := defaultConfigFile, false
 	switch len(os.Args) {
 	case 1:
-		configFile = "/etc/gruffles/config.json"
 	case 2:
-		configFile = os.Args[1]
+		configFile, required = os.Args[1], true
 	default:
-		log.Fatalf("Usage: %s [<config file>]", os.Args[0])
-	}
-	home := os.Getenv("HOME")
-	Config.Database = filepath.Join(home, "gruffles.db")
-	if gopath := os.Getenv("GOPATH"); gopath != "" {
-		Config.ClientDir = filepath.Join(gopath, "src/github.com/russross/gruffles/client")
-	} else {
-		Config.ClientDir = filepath.Join(home, "src/github.com/russross/gruffles/client")
-	}
-	Config.LetsEncryptCache = "/etc/gruffles"
-	if raw, err := ioutil.ReadFile(configFile); err != nil {
-		log.Fatalf("loading config file: %v", err)
-	} else if err := json.Unmarshal(raw, &Config); err != nil {
-		log.Fatalf("parsing config file: %v", err)
+		log.Fatalf("Usage: %s [<config file>] or %s init-config|migrate|validate|import-areas|export-areas|replay ...", os.Args[0], os.Args[0])
 	}
-	Config.SessionSecret = unBase64(Config.SessionSecret)
-	Config.CookieName = "gruffles"
-	Config.SessionSeconds = 90*24*60*60 - 3*60*60
-	if Config.Hostname == "" {
-		log.Fatalf("cannot run with no hostname in the config file")
-	}
-	if Config.LetsEncryptEmail == "" {
-		log.Fatalf("cannot run with no letsEncryptEmail in the config file")
-	}
-	if Config.SessionSecret == "" {
-		log.Fatalf("cannot run with no sessionSeconds in the config file")
+	config, err := LoadConfig(configFile, required)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
+	Config = config
 
 	meddler.Default = meddler.SQLite
 	dbPath := Config.Database + "?_busy_timeout=10000&_loc=auto&_foreign_keys=1"
@@ -79,41 +65,100 @@ func main() {
 	if err != nil {
 		log.Fatalf("opening database: %v", err)
 	}
+	if err := MigrateUp(db, 0); err != nil {
+		log.Fatalf("migrating database: %v", err)
+	}
 
-	// load worlds
-	paths, err := filepath.Glob("areas/*.json")
+	// pick up from the previous process after a copyover
+	handoff, err := loadCopyover()
 	if err != nil {
-		log.Fatalf("glob error: %v", err)
+		log.Fatalf("loading copyover state: %v", err)
 	}
 
-	state := new(State)
-	state.Areas, state.Rooms = LoadAreas(paths)
-	q := make(chan Event)
+	// load worlds
+	state := &State{DB: db, Changed: make(map[*world.Area]bool)}
+	if handoff != nil {
+		state.Areas, state.Rooms, state.RoomVnums, state.Changed, err = handoff.world()
+		if err != nil {
+			log.Fatalf("restoring world after copyover: %v", err)
+		}
+		log.Printf("restored %d areas after copyover", len(state.Areas))
+	} else {
+		state.Areas, state.Rooms, state.RoomVnums = loadWorld(db)
+	}
+	if state.Helps, err = world.LoadHelpsSQL(db); err != nil {
+		log.Fatalf("loading helps: %v", err)
+	}
+	log.Printf("loaded %d helps", len(state.Helps))
+	// Schedule only falls back to a goroutine when this is full, so events
+	// scheduled one after another, like a player's commands, run in order
+	q := make(chan Event, maxPendingEvents)
 	state.Events = q
 
 	SetupCommands()
 
-	// listen for player connections
-	http.HandleFunc("/server", func(w http.ResponseWriter, r *http.Request) {
-		HandleIncommingConnection(w, r, q)
-	})
-
-	// listen for API requests
-	server := setupAPI(db)
+	// listen for player connections and API requests
+	handler := setupAPI(db, q)
 
 	// start the server
-	go func() {
-		log.Printf("accepting https connections")
-		if err := server.ListenAndServeTLS("", ""); err != nil {
-			log.Fatalf("ListenAndServeTLS: %v", err)
+	servers, err := StartServers(handler)
+	if err != nil {
+		log.Fatalf("server: %v", err)
+	}
+	telnet, err := StartTelnet(db, q)
+	if err != nil {
+		log.Fatalf("telnet server: %v", err)
+	}
+	handleSignals(q)
+	if handoff != nil {
+		handoff.restorePlayers(db, q)
+	}
+
+	// start the main loop
+	plan := mainEventLoop(state, q)
+	close(eventsStopped)
+	if plan.handoff != nil {
+		plan.handoff.addListeners()
+	}
+
+	// stop taking requests and give players time to receive their last messages
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownWaitTime)
+	defer cancel()
+	if telnet != nil {
+		telnet.Close()
+	}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("stopping server on %s: %v", server.Addr, err)
 		}
+	}
+	done := make(chan struct{})
+	go func() {
+		playerConnections.Wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("gave up waiting for players to disconnect")
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("closing database: %v", err)
+	}
 
-	// start the main loop
-	mainEventLoop(state, q)
+	if plan.handoff != nil {
+		log.Printf("starting copyover")
+		err := plan.handoff.exec()
+		log.Fatalf("copyover failed: %v", err)
+	}
+	if plan.Reboot {
+		log.Printf("rebooting")
+		err := reexec()
+		log.Fatalf("reboot failed: %v", err)
+	}
+	log.Printf("shut down")
 }
 
-var directions = []string{"north", "east", "south", "west", "up", "down"}
 var deltaX = []int{0, 1, 0, -1, 0, 0}
 var deltaY = []int{1, 0, -1, 0, 0, 0}
 
@@ -124,6 +169,12 @@ type Event struct {
 
 type Queue chan<- Event
 
+// eventsStopped is closed when the event loop stops, so code waiting for
+// an event to run can give up
+var eventsStopped = make(chan struct{})
+
+const maxPendingEvents = 1000
+
 func (q Queue) Schedule(f func(*State), delay time.Duration) {
 	e := Event{When: time.Now().Add(delay), What: f}
 	select {
@@ -225,7 +276,8 @@ func startEventQueue() Queue {
 	return incoming
 }
 
-func mainEventLoop(state *State, incoming <-chan Event) {
+// mainEventLoop runs events until the server shuts down
+func mainEventLoop(state *State, incoming <-chan Event) *Shutdown {
 	q := EventSlice{}
 
 	// create a timer that is not running
@@ -264,6 +316,12 @@ func mainEventLoop(state *State, incoming <-chan Event) {
 			// run an event now
 			e := heap.Pop(&q).(Event)
 			e.What(state)
+			state.sendUpdates()
+
+			if state.Stopped != nil {
+				drainEvents(state, &q, incoming)
+				return state.Stopped
+			}
 		}
 
 		if !timerRunning && len(q) > 0 {
@@ -274,27 +332,69 @@ func mainEventLoop(state *State, incoming <-chan Event) {
 	}
 }
 
-func LoadAreas(paths []string) ([]*Area, []*Room) {
-	var areas []*Area
-	var rooms []*Room
+// RoomByVnum finds a room by the vnum it had in its original area file
+func (state *State) RoomByVnum(vnum int) *world.Room {
+	return state.RoomVnums[vnum]
+}
+
+// loadWorld loads the areas from the database, which is the source of
+// truth since asave and import-areas write there. A new database is
+// filled from the areas directory first.
+func loadWorld(db *sql.DB) ([]*world.Area, []*world.Room, map[int]*world.Room) {
+	files, err := world.ReadAreasSQL(db)
+	if err != nil {
+		log.Fatalf("loading areas: %v", err)
+	}
+	if len(files) > 0 {
+		var names []string
+		for _, file := range files {
+			names = append(names, "area "+file.Name)
+		}
+		return buildAreas(names, files)
+	}
 
-	// load the raw area files
+	log.Printf("no areas in the database, importing the areas directory")
+	paths := areaPaths("areas")
 	for _, path := range paths {
-		fp, err := os.Open(path)
+		file, err := world.ReadAreaFile(path)
 		if err != nil {
-			log.Fatalf("opening %s: %v", path, err)
+			log.Fatalf("loading area: %v", err)
 		}
-		j := json.NewDecoder(fp)
-		area := new(Area)
-		if err = j.Decode(area); err != nil {
-			log.Fatalf("decoding %s: %v", path, err)
+		files = append(files, file)
+	}
+	areas, rooms, vnums := buildAreas(paths, files)
+	if err := world.WriteAreasSQL(db, files); err != nil {
+		log.Fatalf("importing areas: %v", err)
+	}
+	return areas, rooms, vnums
+}
+
+// buildAreas checks a set of area files together and converts them to
+// areas, indexing the rooms. names labels the files in problem reports.
+func buildAreas(names []string, files []*world.AreaFile) ([]*world.Area, []*world.Room, map[int]*world.Room) {
+	if problems := world.ValidateAreaFiles(names, files); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Printf("%s", problem)
 		}
-		fp.Close()
-		areas = append(areas, area)
+		log.Fatalf("found %d problems in areas", len(problems))
+	}
+	var areas []*world.Area
+	for _, file := range files {
+		areas = append(areas, file.Area())
 	}
 	log.Printf("loaded %d areas", len(areas))
 
-	// create a sparse slice of rooms mapping ID -> Room
+	rooms, vnums, err := indexRooms(areas)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return areas, rooms, vnums
+}
+
+// indexRooms creates a sparse slice of rooms mapping ID -> Room and a map
+// from vnum -> Room
+func indexRooms(areas []*world.Area) ([]*world.Room, map[int]*world.Room, error) {
+	vnums := make(map[int]*world.Room)
 	max := 0
 	for _, area := range areas {
 		for _, room := range area.Rooms {
@@ -303,14 +403,21 @@ func LoadAreas(paths []string) ([]*Area, []*Room) {
 			}
 		}
 	}
-	rooms = make([]*Room, max+1)
+	rooms := make([]*world.Room, max+1)
 	for _, area := range areas {
 		for _, room := range area.Rooms {
 			if rooms[room.ID] != nil {
-				log.Fatalf("duplicate room id %d found in area %q", room.ID, area.Name)
+				return nil, nil, fmt.Errorf("duplicate room id %d found in area %q", room.ID, area.Name)
 			}
 			rooms[room.ID] = room
+			if room.Vnum == 0 {
+				continue
+			}
+			if vnums[room.Vnum] != nil {
+				return nil, nil, fmt.Errorf("duplicate room vnum %d found in area %q", room.Vnum, area.Name)
+			}
+			vnums[room.Vnum] = room
 		}
 	}
-	return areas, rooms
+	return rooms, vnums, nil
 }
@@ -1,13 +1,16 @@
 package main
 
-import "bytes"
+import (
+	"bytes"
+
+	"github.com/russross/gruffles/world"
+)
 
 type pair struct {
 	x, y int
 }
 
-func GetMap(state *State, current *Room, visited []bool) string {
-	depth := 3
+func GetMap(state *State, current *world.Room, visited []bool, depth int) string {
 	var buf bytes.Buffer
 	text := trace(state, current, visited, depth)
 	for y := depth*4 + 2; y >= -depth*4-2; y-- {
@@ -23,9 +26,9 @@ func GetMap(state *State, current *Room, visited []bool) string {
 	return buf.String()
 }
 
-func trace(state *State, start *Room, visited []bool, depth int) map[pair]rune {
+func trace(state *State, start *world.Room, visited []bool, depth int) map[pair]rune {
 	q := []pair{pair{0, 0}}
-	grid := map[pair]*Room{pair{0, 0}: start}
+	grid := map[pair]*world.Room{pair{0, 0}: start}
 	text := make(map[pair]rune)
 
 	for len(q) > 0 {
@@ -54,13 +57,13 @@ func trace(state *State, start *Room, visited []bool, depth int) map[pair]rune {
 
 		// draw the exits and follow them
 		handleDir := func(forward, reverse rune, bi, uni, out, new rune, dx, dy int) {
-			target := room.Exit(state, forward)
+			target := room.Exit(state.Rooms, forward)
 			if target == nil {
 				return
 			}
 			seen := visited[target.ID]
 			existing := grid[pair{here.x + dx, here.y + dy}]
-			back := target.Exit(state, reverse)
+			back := target.Exit(state.Rooms, reverse)
 
 			switch {
 			case room.Zone() != target.Zone():
@@ -88,7 +91,7 @@ func trace(state *State, start *Room, visited []bool, depth int) map[pair]rune {
 		handleDir('e', 'w', '↔', '→', '⇒', '⇢', 1, 0)
 		handleDir('w', 'e', '↔', '←', '⇐', '⇠', -1, 0)
 
-		if target := room.Exit(state, 'u'); target != nil {
+		if target := room.Exit(state.Rooms, 'u'); target != nil {
 			if room.Zone() != target.Zone() {
 				text[pair{x + 1, y + 1}] = '⇗'
 			} else if !visited[target.ID] {
@@ -97,7 +100,7 @@ func trace(state *State, start *Room, visited []bool, depth int) map[pair]rune {
 				text[pair{x + 1, y + 1}] = '↗'
 			}
 		}
-		if target := room.Exit(state, 'd'); target != nil {
+		if target := room.Exit(state.Rooms, 'd'); target != nil {
 			if room.Zone() != target.Zone() {
 				text[pair{x - 1, y - 1}] = '⇙'
 			} else if !visited[target.ID] {
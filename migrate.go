@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Schema migrations live in setup/migrations, named like 0002_add_things.up.sql
+// with a matching .down.sql that undoes it. Versions start at 1 and have no
+// gaps. Applied versions are recorded in the schema_migrations table, and
+// the server applies any new ones when it starts.
+//
+//go:embed setup/migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir("setup/migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		groups := migrationName.FindStringSubmatch(entry.Name())
+		if groups == nil {
+			return nil, fmt.Errorf("migration file name %q is not in the form 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(groups[1])
+		raw, err := migrationFiles.ReadFile(path.Join("setup/migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: groups[2]}
+			byVersion[version] = migration
+		} else if migration.Name != groups[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, groups[2])
+		}
+		if groups[3] == "up" {
+			migration.Up = string(raw)
+		} else {
+			migration.Down = string(raw)
+		}
+	}
+
+	var migrations []*Migration
+	for _, migration := range byVersion {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down file", migration.Version, migration.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// appliedMigrations gives the applied versions and when they were applied,
+// creating the schema_migrations table if necessary
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL)`)
+	if err != nil {
+		return nil, fmt.Errorf("creating schema_migrations table: %v", err)
+	}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("loading applied migrations: %v", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("loading applied migrations: %v", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration runs one step in a transaction, so a failed step leaves
+// the database as it was. Foreign keys are switched off while it runs, so a
+// step can rebuild a table without its DROP TABLE cascading into the tables
+// that refer to it, and are checked before the step commits.
+func runMigration(db *sql.DB, migration *Migration, up bool) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	if foreignKeys {
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	script, record, args := migration.Down, `DELETE FROM schema_migrations WHERE version = ?`, []interface{}{migration.Version}
+	if up {
+		script, record = migration.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`
+		args = append(args, migration.Name, time.Now())
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	broken := rows.Next()
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if broken {
+		return fmt.Errorf("the migration left rows that break foreign keys")
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies migrations up to and including target, or all of them
+// if target is zero
+func MigrateUp(db *sql.DB, target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		// a database created from the old schema.sql has tables but no history
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("database has tables but no migration history: if it matches migration 1, use %s migrate baseline", os.Args[0])
+		}
+	}
+	for _, migration := range migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, present := applied[migration.Version]; present {
+			continue
+		}
+		if err := runMigration(db, migration, true); err != nil {
+			return fmt.Errorf("applying migration %d (%s): %v", migration.Version, migration.Name, err)
+		}
+		log.Printf("applied migration %d (%s)", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// MigrateDown undoes the most recent steps migrations
+func MigrateDown(db *sql.DB, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := migrations[i]
+		if _, present := applied[migration.Version]; !present {
+			continue
+		}
+		if err := runMigration(db, migration, false); err != nil {
+			return fmt.Errorf("undoing migration %d (%s): %v", migration.Version, migration.Name, err)
+		}
+		log.Printf("undid migration %d (%s)", migration.Version, migration.Name)
+		steps--
+	}
+	return nil
+}
+
+func cmdMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbPath := flags.String("db", configuredDatabase(), "database file")
+	to := flags.Int("to", 0, "for up: stop after this version (default: all)")
+	steps := flags.Int("steps", 1, "for down: number of migrations to undo")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s migrate [-db <file>] [-to n] [-steps n] up|down|status|baseline\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  up:       apply new migrations\n")
+		fmt.Fprintf(os.Stderr, "  down:     undo the most recent migrations\n")
+		fmt.Fprintf(os.Stderr, "  status:   list migrations and when they were applied\n")
+		fmt.Fprintf(os.Stderr, "  baseline: record migration 1 as applied to a database made from the old schema.sql\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("sqlite3", *dbPath+"?_busy_timeout=10000&_loc=auto&_foreign_keys=1")
+	if err != nil {
+		log.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	switch flags.Arg(0) {
+	case "up":
+		err = MigrateUp(db, *to)
+	case "down":
+		err = MigrateDown(db, *steps)
+	case "status":
+		err = migrationStatus(db)
+	case "baseline":
+		err = baselineMigrations(db)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func migrationStatus(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		status := "pending"
+		if at, present := applied[migration.Version]; present {
+			status = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d %-30s %s\n", migration.Version, migration.Name, status)
+	}
+	for version := range applied {
+		if version > len(migrations) {
+			fmt.Printf("%04d %-30s applied, but unknown to this version of the server\n", version, "?")
+		}
+	}
+	return nil
+}
+
+func baselineMigrations(db *sql.DB) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		return fmt.Errorf("database already has a migration history")
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		migrations[0].Version, migrations[0].Name, time.Now())
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openMigrateDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_loc=auto&_foreign_keys=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// schema describes every table and index except schema_migrations
+func schema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master ` +
+		`WHERE name != 'schema_migrations' ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var s strings.Builder
+	for rows.Next() {
+		var kind, name, text string
+		if err := rows.Scan(&kind, &name, &text); err != nil {
+			t.Fatal(err)
+		}
+		s.WriteString(kind + " " + name + "\n" + text + "\n")
+	}
+	return s.String()
+}
+
+func appliedVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for i := 1; i <= len(applied); i++ {
+		if _, present := applied[i]; !present {
+			t.Fatalf("applied migrations have a gap: %v", applied)
+		}
+		versions = append(versions, i)
+	}
+	return versions
+}
+
+func columns(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, name)
+	}
+	return list
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) < 2 {
+		t.Fatalf("found %d migrations", len(migrations))
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 || migration.Name == "" || migration.Up == "" || migration.Down == "" {
+			t.Errorf("migration %d is %d (%s) with %d and %d bytes of up and down",
+				i+1, migration.Version, migration.Name, len(migration.Up), len(migration.Down))
+		}
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := openMigrateDB(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations) {
+		t.Fatalf("applied %v, expected %d migrations", got, len(migrations))
+	}
+	for _, table := range []string{"users", "sessions", "characters", "areas", "area_authors", "helps", "mobiles", "objects", "rooms", "doors", "resets"} {
+		if len(columns(t, db, table)) == 0 {
+			t.Errorf("table %s is missing", table)
+		}
+	}
+	cols := strings.Join(columns(t, db, "users"), " ")
+	if !strings.Contains(cols, "record_sessions") || !strings.Contains(cols, "record_forced") {
+		t.Errorf("users has columns %s, expected the recording settings", cols)
+	}
+	full := schema(t, db)
+
+	// running it again does nothing
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); got != full {
+		t.Errorf("migrating an up to date database changed the schema")
+	}
+
+	// one step down and back up
+	if err := MigrateDown(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations)-1 {
+		t.Errorf("after one step down, applied %v", got)
+	}
+	if cols := columns(t, db, "characters"); len(cols) == 0 || cols[0] != "user_id" || strings.Contains(strings.Join(cols, " "), "name") {
+		t.Errorf("undoing the last migration left characters with columns %v", cols)
+	}
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); got != full {
+		t.Errorf("one step down and up changed the schema:\n%s\nexpected\n%s", got, full)
+	}
+
+	// all the way down and back up
+	if err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != 0 {
+		t.Errorf("after undoing everything, applied %v", got)
+	}
+	if got := schema(t, db); got != "" {
+		t.Errorf("undoing every migration left this behind:\n%s", got)
+	}
+	if err := MigrateUp(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("migrating up to 1 applied %v", got)
+	}
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); got != full {
+		t.Errorf("reapplying every migration gave a different schema:\n%s\nexpected\n%s", got, full)
+	}
+}
+
+func TestBaselineMigrations(t *testing.T) {
+	db := openMigrateDB(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// testdata/schema.sql is setup/schema.sql as it was before migrations,
+	// which is what migration 1 must match for baseline to be right
+	old, err := ioutil.ReadFile("testdata/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrations[0].Up != string(old) {
+		t.Fatalf("migration 1 is not the old schema.sql")
+	}
+
+	// a database made from the old schema.sql, with no history and some
+	// rows that later migrations have to carry forward
+	if _, err := db.Exec(string(old)); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO users VALUES (1, 'alice', 1, 1, x'00', 'scrypt', x'00', '2020-01-01', '2020-01-01', '2020-01-01')`,
+		`INSERT INTO areas VALUES (1, 'Midgaard', '2020-01-01', '2020-01-01')`,
+		`INSERT INTO rooms VALUES (3001, 1, 'The Temple', 'A temple.', 10, 0, '[]')`,
+		`INSERT INTO mobiles VALUES (3000, 1, '["wizard"]', 'the wizard', 'A wizard is here.', 'He is old.', ` +
+			`10, 0, 900, 30, '[]', '[]', '[]', '[]', '[]', '[]', '[]', '[]', 0, 0, 'he')`,
+		`INSERT INTO objects VALUES (3010, 1, '["sword"]', 'a sword', 'A sword lies here.', 5, 10, 8193, 0, 1, 8, 3, 10, 100, '[]', '[]')`,
+		`INSERT INTO resets (id, reset_type, area_id, sequence, room_id, mobile_id) VALUES (1, 'M', 1, 1, 3001, 3000)`,
+		`INSERT INTO resets (id, reset_type, area_id, sequence, object_id) VALUES (2, 'G', 1, 2, 3010)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := MigrateUp(db, 0); err == nil {
+		t.Fatalf("migrating a database with no history gave no error")
+	}
+	if err := baselineMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := baselineMigrations(db); err == nil {
+		t.Errorf("a second baseline gave no error")
+	}
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations) {
+		t.Errorf("after a baseline, applied %v", got)
+	}
+
+	// the result matches a database built by the migrations alone
+	fresh := openMigrateDB(t)
+	if err := MigrateUp(fresh, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := schema(t, db), schema(t, fresh); got != expected {
+		t.Errorf("after a baseline, the schema is\n%s\nexpected\n%s", got, expected)
+	}
+
+	var resets int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM resets`).Scan(&resets); err != nil {
+		t.Fatal(err)
+	}
+	if resets != 2 {
+		t.Errorf("rebuilding tables left %d resets, expected 2", resets)
+	}
+	var mobFlags, position, roomFlags, extraFlags, wearFlags string
+	var value4 int
+	var banned bool
+	row := db.QueryRow(`SELECT m.action_flags, m.start_position, r.flags, o.extra_flags, o.wear_flags, o.value_4, u.banned ` +
+		`FROM mobiles m, rooms r, objects o, users u`)
+	if err := row.Scan(&mobFlags, &position, &roomFlags, &extraFlags, &wearFlags, &value4, &banned); err != nil {
+		t.Fatal(err)
+	}
+	if mobFlags != "0xa" || roomFlags != "0xa" || extraFlags != "0xa" || wearFlags != "0x2001" {
+		t.Errorf("flags became %s, %s, %s, and %s", mobFlags, roomFlags, extraFlags, wearFlags)
+	}
+	if position != "standing" || value4 != 0 || banned {
+		t.Errorf("new columns are %q, %d, and %v", position, value4, banned)
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
-import "time"
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/russross/gruffles/world"
+)
 
 type MobController interface {
 	SendMessage(string)
@@ -44,8 +51,8 @@ type Mob struct {
 	Description string
 	Title       string
 
-	Location      *Room
-	StartLocation *Room
+	Location      *world.Room
+	StartLocation *world.Room
 	Visited       []bool
 
 	Skills    []*Skill
@@ -86,11 +93,18 @@ type Mob struct {
 	// Controller info
 	Controller *MobController
 	Player     *Player
+	Builder    *Builder
+
+	// the user who is playing, or zero if not signed in
+	UserID int64
+
+	// the saved character this mob is, or zero if it has not been saved
+	CharacterID int64
 }
 
 func (mob *Mob) Send(msgType MsgType, msg string) {
 	if mob.Player != nil {
-		mob.Player.Send(Msg{
+		mob.Player.Post(Msg{
 			Type:    msgType,
 			Message: msg,
 		})
@@ -98,3 +112,67 @@ func (mob *Mob) Send(msgType MsgType, msg string) {
 		// TODO: send message to non-player mob
 	}
 }
+
+// SaveCharacter stores a signed-in player's character, in the same form
+// copyover uses, so they can pick it up when they next join. A character
+// saved for the first time gets its ID here.
+func (state *State) SaveCharacter(mob *Mob) {
+	if mob.Player == nil || mob.UserID == 0 {
+		return
+	}
+	raw, err := json.Marshal(saveMob(state, mob))
+	if err != nil {
+		log.Printf("saving character %s for user %d: %v", mob.Name, mob.UserID, err)
+		return
+	}
+	if mob.CharacterID == 0 {
+		var result sql.Result
+		result, err = state.DB.Exec(`INSERT INTO characters (user_id, name, mob, saved_at) VALUES (?, ?, ?, ?)`,
+			mob.UserID, mob.Name, string(raw), time.Now())
+		if err == nil {
+			mob.CharacterID, err = result.LastInsertId()
+		}
+	} else {
+		_, err = state.DB.Exec(`UPDATE characters SET mob = ?, saved_at = ? WHERE id = ?`,
+			string(raw), time.Now(), mob.CharacterID)
+	}
+	if err != nil {
+		log.Printf("saving character %s for user %d: %v", mob.Name, mob.UserID, err)
+	}
+}
+
+// LoadCharacter gives a saved character, or nil if there is no such
+// character. The mob has no player yet.
+func (state *State) LoadCharacter(id int64) *Mob {
+	var userID int64
+	var name, raw string
+	err := state.DB.QueryRow(`SELECT user_id, name, mob FROM characters WHERE id = ?`, id).Scan(&userID, &name, &raw)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("loading character %d: %v", id, err)
+		return nil
+	}
+	saved := new(savedMob)
+	if err := json.Unmarshal([]byte(raw), saved); err != nil {
+		log.Printf("loading character %d: %v", id, err)
+		return nil
+	}
+	mob := saved.restore(state)
+	mob.CharacterID, mob.UserID, mob.Name = id, userID, name
+	return mob
+}
+
+// RemoveMob takes a mob out of the world
+func (state *State) RemoveMob(mob *Mob) {
+	if mob.Player != nil {
+		mob.StopRecording("left the game")
+	}
+	for i, elt := range state.Mobs {
+		if elt == mob {
+			state.Mobs = append(state.Mobs[:i], state.Mobs[i+1:]...)
+			return
+		}
+	}
+}
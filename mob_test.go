@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/russross/gruffles/world"
+)
+
+// characterState gives a state with two rooms and a database with one user
+func characterState(t *testing.T) *State {
+	t.Helper()
+	db := openMigrateDB(t)
+	if err := MigrateUp(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`INSERT INTO users (id, username, admin, author, salt, scheme, password_hash, last_signed_in_at, created_at, modified_at) ` +
+		`VALUES (7, 'alice', 0, 0, x'00', 'scrypt', x'00', '2020-01-01', '2020-01-01', '2020-01-01')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temple := &world.Room{ID: 1, Vnum: 3001, Name: "The Temple"}
+	square := &world.Room{ID: 2, Vnum: 3005, Name: "Market Square"}
+	return &State{
+		DB:        db,
+		Rooms:     []*world.Room{nil, temple, square},
+		RoomVnums: map[int]*world.Room{3001: temple, 3005: square},
+	}
+}
+
+func TestSaveCharacter(t *testing.T) {
+	state := characterState(t)
+	mob := &Mob{
+		Name:          "Gnoric",
+		Location:      state.Rooms[2],
+		StartLocation: state.Rooms[1],
+		Visited:       []bool{false, false, true},
+		Gold:          12,
+		Player:        newPlayer(),
+		UserID:        7,
+	}
+	state.SaveCharacter(mob)
+	mob.Gold = 20
+	state.SaveCharacter(mob)
+
+	if mob.CharacterID == 0 {
+		t.Fatalf("saving did not give the character an id")
+	}
+	loaded := state.LoadCharacter(mob.CharacterID)
+	if loaded == nil {
+		t.Fatalf("no character was saved")
+	}
+	if loaded.Name != "Gnoric" || loaded.Gold != 20 || loaded.UserID != 7 || loaded.CharacterID != mob.CharacterID || loaded.Player != nil {
+		t.Errorf("loaded %s with %d gold for user %d", loaded.Name, loaded.Gold, loaded.UserID)
+	}
+	if loaded.Location != state.Rooms[2] || loaded.StartLocation != state.Rooms[1] {
+		t.Errorf("loaded in %v starting from %v", loaded.Location, loaded.StartLocation)
+	}
+	if len(loaded.Visited) != 3 || loaded.Visited[1] || !loaded.Visited[2] {
+		t.Errorf("loaded with visited rooms %v", loaded.Visited)
+	}
+
+	if state.LoadCharacter(mob.CharacterID+1) != nil {
+		t.Errorf("loaded a character that was never saved")
+	}
+
+	// guests are not saved
+	state.SaveCharacter(&Mob{Name: "Gnoric", Location: state.Rooms[1], Player: newPlayer()})
+	var count int
+	if err := state.DB.QueryRow(`SELECT COUNT(*) FROM characters`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("found %d saved characters, expected 1", count)
+	}
+}
+
+func TestLinkDeadSavesCharacter(t *testing.T) {
+	state := characterState(t)
+	mob := &Mob{Name: "Gnoric", Location: state.Rooms[2], StartLocation: state.Rooms[1], Player: newPlayer(), UserID: 7}
+	state.Mobs = []*Mob{mob}
+
+	// a player whose connection was closed leaves right away
+	mob.Player.closing = true
+	state.LinkDead(mob)
+	if len(state.Mobs) != 0 {
+		t.Errorf("the mob is still in the world")
+	}
+	loaded := state.LoadCharacter(mob.CharacterID)
+	if loaded == nil || loaded.Location != state.Rooms[2] {
+		t.Errorf("the character was not saved before leaving: %+v", loaded)
+	}
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"time"
+
+	"github.com/russross/gruffles/world"
 )
 
 const RecallLocation = 3001
@@ -14,42 +15,33 @@ func CmdLook(state *State, mob *Mob, cmd string) time.Duration {
 	}
 
 	mob.Send(MsgEnvironment, mob.Location.GetDescription())
-	mob.Send(MsgMap, GetMap(state, mob.Location, mob.Visited))
+	mob.SendLocation(state)
 
 	return 0
 }
 
-const (
-	DirNorth int = iota
-	DirEast
-	DirSouth
-	DirWest
-	DirUp
-	DirDown
-)
-
 func CmdNorth(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirNorth)
+	return cmdDirection(state, mob, cmd, world.DirNorth)
 }
 
 func CmdEast(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirEast)
+	return cmdDirection(state, mob, cmd, world.DirEast)
 }
 
 func CmdSouth(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirSouth)
+	return cmdDirection(state, mob, cmd, world.DirSouth)
 }
 
 func CmdWest(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirWest)
+	return cmdDirection(state, mob, cmd, world.DirWest)
 }
 
 func CmdUp(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirUp)
+	return cmdDirection(state, mob, cmd, world.DirUp)
 }
 
 func CmdDown(state *State, mob *Mob, cmd string) time.Duration {
-	return cmdDirection(state, mob, cmd, DirDown)
+	return cmdDirection(state, mob, cmd, world.DirDown)
 }
 
 func cmdDirection(state *State, mob *Mob, cmd string, dir int) time.Duration {
@@ -60,7 +52,7 @@ func cmdDirection(state *State, mob *Mob, cmd string, dir int) time.Duration {
 
 	// see if there is a door in that direction
 	for _, door := range mob.Location.Doors {
-		if door.Door == dir {
+		if door.Direction == dir && door.ToRoom != 0 {
 			id := door.ToRoom
 			if id < 0 || id >= len(state.Rooms) || state.Rooms[id] == nil {
 				mob.Send(MsgEnvironment, "Error trying to move in that direction\n")
@@ -76,7 +68,7 @@ func cmdDirection(state *State, mob *Mob, cmd string, dir int) time.Duration {
 			}
 			mob.Visited[mob.Location.ID] = true
 			mob.Send(MsgEnvironment, msg)
-			mob.Send(MsgMap, GetMap(state, mob.Location, mob.Visited))
+			mob.SendLocation(state)
 			return TimeToMove
 		}
 	}
@@ -91,50 +83,13 @@ func CmdRecall(state *State, mob *Mob, cmd string) time.Duration {
 		return TimeToMove
 	}
 
-	mob.Location = state.Rooms[RecallLocation]
+	recall := state.RoomByVnum(RecallLocation)
+	if recall == nil {
+		mob.Send(MsgEnvironment, "Error trying to recall\n")
+		return TimeToMove
+	}
+	mob.Location = recall
 	mob.Send(MsgEnvironment, mob.Location.GetShortDescription())
-	mob.Send(MsgMap, GetMap(state, mob.Location, mob.Visited))
+	mob.SendLocation(state)
 	return TimeToMove
 }
-
-func (r *Room) Zone() int {
-	return r.ID / 100
-}
-
-func (r *Room) Exit(state *State, dir rune) *Room {
-	for _, door := range r.Doors {
-		exit := rune(directions[door.Door][0])
-		if exit == dir && door.ToRoom >= 0 && door.ToRoom < len(state.Rooms) {
-			return state.Rooms[door.ToRoom]
-		}
-	}
-	return nil
-}
-
-func (r *Room) GetShortDescription() string {
-	var buf bytes.Buffer
-	buf.WriteString(r.Name)
-	buf.WriteString("\nExits [")
-	for i, door := range r.Doors {
-		if i > 0 {
-			buf.WriteString(" ")
-		}
-		buf.WriteString(directions[door.Door][0:1])
-	}
-	buf.WriteString("]\n")
-	return buf.String()
-}
-
-func (r *Room) GetDescription() string {
-	var buf bytes.Buffer
-	buf.WriteString(r.Description)
-	buf.WriteString("\nExits [")
-	for i, door := range r.Doors {
-		if i > 0 {
-			buf.WriteString(" ")
-		}
-		buf.WriteString(directions[door.Door][0:1])
-	}
-	buf.WriteString("]\n")
-	return buf.String()
-}
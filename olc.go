@@ -0,0 +1,795 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/russross/gruffles/world"
+	"github.com/russross/meddler"
+)
+
+// Online creation: builders edit the live world with these commands and
+// then save it to the database with asave. A player is a builder if they
+// connected with the session of a user who is an author or an admin.
+// Admins can edit any area, and authors can edit the areas they are
+// assigned to in area_authors.
+
+type Builder struct {
+	User  *User
+	Areas map[string]bool
+
+	// selected with medit and oedit
+	Mobile *world.Mobile
+	Object *world.Object
+}
+
+// LoadBuilder returns nil if the user is not an author or admin
+func LoadBuilder(db *sql.DB, userID int64) (*Builder, error) {
+	user := new(User)
+	if err := meddler.Load(db, "users", user, userID); err != nil {
+		return nil, err
+	}
+	if !user.Admin && !user.Author {
+		return nil, nil
+	}
+	builder := &Builder{User: user, Areas: make(map[string]bool)}
+	rows, err := db.Query(`SELECT areas.name FROM areas JOIN area_authors ON areas.id = area_authors.area_id `+
+		`WHERE area_authors.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		builder.Areas[name] = true
+	}
+	return builder, rows.Err()
+}
+
+func (builder *Builder) CanEdit(area *world.Area) bool {
+	return area != nil && (builder.User.Admin || builder.Areas[area.Name])
+}
+
+// AreaOfRoom finds the area that a room belongs to
+func (state *State) AreaOfRoom(room *world.Room) *world.Area {
+	for _, area := range state.Areas {
+		for _, elt := range area.Rooms {
+			if elt == room {
+				return area
+			}
+		}
+	}
+	return nil
+}
+
+func (state *State) MobileByVnum(vnum int) (*world.Area, *world.Mobile) {
+	for _, area := range state.Areas {
+		for _, elt := range area.Mobiles {
+			if elt.Vnum == vnum {
+				return area, elt
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (state *State) ObjectByVnum(vnum int) (*world.Area, *world.Object) {
+	for _, area := range state.Areas {
+		for _, elt := range area.Objects {
+			if elt.Vnum == vnum {
+				return area, elt
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AddRoom puts a new room into an area in the live world. Rooms in the
+// world use their vnum as their ID.
+func (state *State) AddRoom(area *world.Area, room *world.Room) {
+	room.ID = room.Vnum
+	area.Rooms = append(area.Rooms, room)
+	for len(state.Rooms) <= room.ID {
+		state.Rooms = append(state.Rooms, nil)
+	}
+	state.Rooms[room.ID] = room
+	state.RoomVnums[room.Vnum] = room
+	for _, mob := range state.Mobs {
+		for len(mob.Visited) < len(state.Rooms) {
+			mob.Visited = append(mob.Visited, true)
+		}
+	}
+}
+
+// editArea finds the area a builder is standing in and makes sure they
+// can edit it
+func editArea(state *State, mob *Mob) *world.Area {
+	area := state.AreaOfRoom(mob.Location)
+	if !mob.Builder.CanEdit(area) {
+		mob.Send(MsgError, "You are not a builder for this area.\n")
+		return nil
+	}
+	return area
+}
+
+func nextWord(s string) (string, string) {
+	fields := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	if len(fields) < 2 {
+		return strings.ToLower(fields[0]), ""
+	}
+	return strings.ToLower(fields[0]), strings.TrimSpace(fields[1])
+}
+
+// lookupName finds a name in a list, allowing abbreviations
+func lookupName(list []string, name string) int {
+	name = strings.ToLower(name)
+	if name == "" {
+		return -1
+	}
+	for i, elt := range list {
+		if elt == name {
+			return i
+		}
+	}
+	for i, elt := range list {
+		if strings.HasPrefix(elt, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+//
+// rooms
+//
+
+func CmdRedit(state *State, mob *Mob, cmd string) time.Duration {
+	area := editArea(state, mob)
+	if area == nil {
+		return 0
+	}
+	room := mob.Location
+
+	field, value := nextWord(cmd)
+	switch field {
+	case "", "show":
+		mob.Send(MsgEnvironment, showRoom(state, room))
+		return 0
+	case "name":
+		if value == "" {
+			mob.Send(MsgError, "Usage: redit name <name>\n")
+			return 0
+		}
+		room.Name = value
+	case "desc":
+		room.Description = value + "\n"
+	case "desc+":
+		room.Description += value + "\n"
+	case "terrain":
+		terrain := lookupName(world.Terrains, value)
+		if terrain < 0 {
+			mob.Send(MsgError, fmt.Sprintf("Terrains are: %s\n", strings.Join(world.Terrains, " ")))
+			return 0
+		}
+		room.Terrain = terrain
+	case "flag":
+		if _, err := room.Flags.ToggleName(value); err != nil {
+			mob.Send(MsgError, err.Error()+"\n")
+			return 0
+		}
+	case "exit":
+		dirName, target := nextWord(value)
+		dir := lookupName(world.Directions, dirName)
+		if dir < 0 || target == "" {
+			mob.Send(MsgError, "Usage: redit exit <direction> <vnum>|delete\n")
+			return 0
+		}
+		if target == "delete" {
+			if !removeExit(room, dir) {
+				mob.Send(MsgError, "There is no exit in that direction.\n")
+				return 0
+			}
+			break
+		}
+		vnum, err := strconv.Atoi(target)
+		if err != nil || state.RoomByVnum(vnum) == nil {
+			mob.Send(MsgError, "There is no room with that vnum.\n")
+			return 0
+		}
+		setExit(room, dir, state.RoomByVnum(vnum))
+	default:
+		mob.Send(MsgError, "Usage: redit [show|name|desc|desc+|terrain|flag|exit] ...\n")
+		return 0
+	}
+
+	state.Changed[area] = true
+	mob.Send(MsgEnvironment, showRoom(state, room))
+	mob.SendLocation(state)
+	return 0
+}
+
+func showRoom(state *State, room *world.Room) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Room %d: %s\n", room.Vnum, room.Name)
+	fmt.Fprintf(&buf, "Terrain: %s  Flags: %s\n", world.Terrains[room.Terrain], room.Flags.String())
+	buf.WriteString(room.Description)
+	for _, door := range room.Doors {
+		fmt.Fprintf(&buf, "Exit %s to %d", world.Directions[door.Direction], door.ToRoom)
+		if door.Key != 0 {
+			fmt.Fprintf(&buf, " (key %d)", door.Key)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func setExit(room *world.Room, dir int, target *world.Room) {
+	door := world.Door{RoomID: room.ID, Direction: dir, Keywords: []string{}, ToRoom: target.ID}
+	for i := range room.Doors {
+		if room.Doors[i].Direction == dir {
+			room.Doors[i].ToRoom = target.ID
+			return
+		}
+	}
+	room.Doors = append(room.Doors, door)
+}
+
+func removeExit(room *world.Room, dir int) bool {
+	for i, door := range room.Doors {
+		if door.Direction == dir {
+			room.Doors = append(room.Doors[:i], room.Doors[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// CmdDig creates a room in a direction and links it both ways. With a
+// vnum it links to that room, creating it if needed.
+func CmdDig(state *State, mob *Mob, cmd string) time.Duration {
+	area := editArea(state, mob)
+	if area == nil {
+		return 0
+	}
+	room := mob.Location
+
+	dirName, vnumText := nextWord(cmd)
+	dir := lookupName(world.Directions, dirName)
+	if dir < 0 {
+		mob.Send(MsgError, "Usage: dig <direction> [<vnum>]\n")
+		return 0
+	}
+	if room.Exit(state.Rooms, rune(world.Directions[dir][0])) != nil {
+		mob.Send(MsgError, "There is already an exit in that direction.\n")
+		return 0
+	}
+
+	// find or make the target room
+	var target *world.Room
+	if vnumText != "" {
+		vnum, err := strconv.Atoi(vnumText)
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "The vnum must be a positive number.\n")
+			return 0
+		}
+		target = state.RoomByVnum(vnum)
+		if target == nil {
+			target = newRoom(vnum, room)
+			state.AddRoom(area, target)
+		}
+	} else {
+		vnum := 0
+		for _, elt := range area.Rooms {
+			if elt.Vnum > vnum {
+				vnum = elt.Vnum
+			}
+		}
+		for vnum++; state.RoomByVnum(vnum) != nil; vnum++ {
+		}
+		target = newRoom(vnum, room)
+		state.AddRoom(area, target)
+	}
+	targetArea := state.AreaOfRoom(target)
+
+	// link it up
+	setExit(room, dir, target)
+	state.Changed[area] = true
+	reverse := world.ReverseDirections[dir]
+	if mob.Builder.CanEdit(targetArea) && target.Exit(state.Rooms, rune(world.Directions[reverse][0])) == nil {
+		setExit(target, reverse, room)
+		state.Changed[targetArea] = true
+	}
+
+	mob.Location = target
+	mob.Send(MsgEnvironment, showRoom(state, target))
+	mob.SendLocation(state)
+	return 0
+}
+
+func newRoom(vnum int, from *world.Room) *world.Room {
+	return &world.Room{
+		Vnum:    vnum,
+		Name:    "An unfinished room",
+		Terrain: from.Terrain,
+		Extras:  []world.RoomExtraDescription{},
+		Doors:   []world.Door{},
+	}
+}
+
+//
+// mobiles
+//
+
+func CmdMedit(state *State, mob *Mob, cmd string) time.Duration {
+	field, value := nextWord(cmd)
+
+	// select or create a mobile
+	if vnum, err := strconv.Atoi(field); err == nil {
+		_, elt := state.MobileByVnum(vnum)
+		if elt == nil {
+			mob.Send(MsgError, "There is no mobile with that vnum. Use medit create <vnum> to make one.\n")
+			return 0
+		}
+		mob.Builder.Mobile = elt
+		mob.Send(MsgEnvironment, showMobile(elt))
+		return 0
+	}
+	if field == "create" {
+		area := editArea(state, mob)
+		if area == nil {
+			return 0
+		}
+		vnum, err := strconv.Atoi(value)
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "Usage: medit create <vnum>\n")
+			return 0
+		}
+		if _, elt := state.MobileByVnum(vnum); elt != nil {
+			mob.Send(MsgError, "That vnum is already in use.\n")
+			return 0
+		}
+		elt := &world.Mobile{
+			ID:               vnum,
+			Vnum:             vnum,
+			Keywords:         []string{"mobile"},
+			ShortDescription: "a new mobile",
+			LongDescription:  "A new mobile is here.",
+			HitRoll:          []int{0, 0},
+			DamageRoll:       []int{0, 0},
+			DodgeRoll:        []int{0, 0},
+			AbsorbRoll:       []int{0, 0},
+			FireRoll:         []int{0, 0},
+			IceRoll:          []int{0, 0},
+			PoisonRoll:       []int{0, 0},
+			LightningRoll:    []int{0, 0},
+			Pronouns:         "it",
+			StartPosition:    "standing",
+			DefaultPosition:  "standing",
+		}
+		elt.ActionFlags.Add(world.ActIsNPC)
+		area.Mobiles = append(area.Mobiles, elt)
+		state.Changed[area] = true
+		mob.Builder.Mobile = elt
+		mob.Send(MsgEnvironment, showMobile(elt))
+		return 0
+	}
+
+	// edit the selected mobile
+	elt := mob.Builder.Mobile
+	if elt == nil {
+		mob.Send(MsgError, "Select a mobile first with medit <vnum>.\n")
+		return 0
+	}
+	area, _ := state.MobileByVnum(elt.Vnum)
+	if !mob.Builder.CanEdit(area) {
+		mob.Send(MsgError, "You are not a builder for that mobile's area.\n")
+		return 0
+	}
+	number := func() (int, bool) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			mob.Send(MsgError, fmt.Sprintf("Usage: medit %s <number>\n", field))
+		}
+		return n, err == nil
+	}
+	switch field {
+	case "", "show":
+		mob.Send(MsgEnvironment, showMobile(elt))
+		return 0
+	case "keywords":
+		if len(strings.Fields(value)) == 0 {
+			mob.Send(MsgError, "Usage: medit keywords <keyword>...\n")
+			return 0
+		}
+		elt.Keywords = strings.Fields(value)
+	case "short":
+		elt.ShortDescription = value
+	case "long":
+		elt.LongDescription = value
+	case "desc":
+		elt.Description = value + "\n"
+	case "desc+":
+		elt.Description += value + "\n"
+	case "level":
+		n, ok := number()
+		if !ok {
+			return 0
+		}
+		if n < 0 || n > 100 {
+			mob.Send(MsgError, "Level must be between 0 and 100.\n")
+			return 0
+		}
+		elt.Level = n
+	case "alignment":
+		n, ok := number()
+		if !ok {
+			return 0
+		}
+		if n < -1000 || n > 1000 {
+			mob.Send(MsgError, "Alignment must be between -1000 and 1000.\n")
+			return 0
+		}
+		elt.Alignment = n
+	case "gold":
+		n, ok := number()
+		if !ok {
+			return 0
+		}
+		elt.Gold = n
+	case "pronouns":
+		i := lookupName(world.Pronouns, value)
+		if i < 0 {
+			mob.Send(MsgError, fmt.Sprintf("Pronouns are: %s\n", strings.Join(world.Pronouns, " ")))
+			return 0
+		}
+		elt.Pronouns = world.Pronouns[i]
+	case "act":
+		if _, err := elt.ActionFlags.ToggleName(value); err != nil {
+			mob.Send(MsgError, err.Error()+"\n")
+			return 0
+		}
+	case "affect":
+		if _, err := elt.AffectedFlags.ToggleName(value); err != nil {
+			mob.Send(MsgError, err.Error()+"\n")
+			return 0
+		}
+	default:
+		mob.Send(MsgError, "Usage: medit [<vnum>|create|show|keywords|short|long|desc|desc+|level|alignment|gold|pronouns|act|affect] ...\n")
+		return 0
+	}
+
+	state.Changed[area] = true
+	mob.Send(MsgEnvironment, showMobile(elt))
+	return 0
+}
+
+func showMobile(elt *world.Mobile) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Mobile %d: %s\n", elt.Vnum, strings.Join(elt.Keywords, " "))
+	fmt.Fprintf(&buf, "Short: %s\nLong: %s\n", elt.ShortDescription, elt.LongDescription)
+	fmt.Fprintf(&buf, "Level: %d  Alignment: %d  Gold: %d  Pronouns: %s\n", elt.Level, elt.Alignment, elt.Gold, elt.Pronouns)
+	fmt.Fprintf(&buf, "Act: %s\nAffect: %s\n", elt.ActionFlags.String(), elt.AffectedFlags.String())
+	buf.WriteString(elt.Description)
+	return buf.String()
+}
+
+//
+// objects
+//
+
+func CmdOedit(state *State, mob *Mob, cmd string) time.Duration {
+	field, value := nextWord(cmd)
+
+	// select or create an object
+	if vnum, err := strconv.Atoi(field); err == nil {
+		_, elt := state.ObjectByVnum(vnum)
+		if elt == nil {
+			mob.Send(MsgError, "There is no object with that vnum. Use oedit create <vnum> to make one.\n")
+			return 0
+		}
+		mob.Builder.Object = elt
+		mob.Send(MsgEnvironment, showObject(elt))
+		return 0
+	}
+	if field == "create" {
+		area := editArea(state, mob)
+		if area == nil {
+			return 0
+		}
+		vnum, err := strconv.Atoi(value)
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "Usage: oedit create <vnum>\n")
+			return 0
+		}
+		if _, elt := state.ObjectByVnum(vnum); elt != nil {
+			mob.Send(MsgError, "That vnum is already in use.\n")
+			return 0
+		}
+		elt := &world.Object{
+			ID:               vnum,
+			Vnum:             vnum,
+			Keywords:         []string{"object"},
+			ShortDescription: "a new object",
+			LongDescription:  "A new object is here.",
+			Extras:           []world.ObjectExtraDescription{},
+			Applies:          []world.ObjectApply{},
+		}
+		elt.WearFlags.Add(world.WearTake)
+		area.Objects = append(area.Objects, elt)
+		state.Changed[area] = true
+		mob.Builder.Object = elt
+		mob.Send(MsgEnvironment, showObject(elt))
+		return 0
+	}
+
+	// edit the selected object
+	elt := mob.Builder.Object
+	if elt == nil {
+		mob.Send(MsgError, "Select an object first with oedit <vnum>.\n")
+		return 0
+	}
+	area, _ := state.ObjectByVnum(elt.Vnum)
+	if !mob.Builder.CanEdit(area) {
+		mob.Send(MsgError, "You are not a builder for that object's area.\n")
+		return 0
+	}
+	number := func(s string) (int, bool) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			mob.Send(MsgError, fmt.Sprintf("Usage: oedit %s <number>\n", field))
+		}
+		return n, err == nil
+	}
+	switch field {
+	case "", "show":
+		mob.Send(MsgEnvironment, showObject(elt))
+		return 0
+	case "keywords":
+		if len(strings.Fields(value)) == 0 {
+			mob.Send(MsgError, "Usage: oedit keywords <keyword>...\n")
+			return 0
+		}
+		elt.Keywords = strings.Fields(value)
+	case "short":
+		elt.ShortDescription = value
+	case "long":
+		elt.LongDescription = value
+	case "type":
+		n, ok := number(value)
+		if !ok {
+			return 0
+		}
+		elt.ItemType = n
+	case "weight":
+		n, ok := number(value)
+		if !ok {
+			return 0
+		}
+		elt.Weight = n
+	case "cost":
+		n, ok := number(value)
+		if !ok {
+			return 0
+		}
+		elt.Cost = n
+	case "value":
+		which, rest := nextWord(value)
+		values := []*int{&elt.Value0, &elt.Value1, &elt.Value2, &elt.Value3, &elt.Value4, &elt.Value5}
+		i, err := strconv.Atoi(which)
+		if err != nil || i < 0 || i >= len(values) {
+			mob.Send(MsgError, "Usage: oedit value <0-5> <number>\n")
+			return 0
+		}
+		n, ok := number(rest)
+		if !ok {
+			return 0
+		}
+		*values[i] = n
+	case "extra":
+		if _, err := elt.ExtraFlags.ToggleName(value); err != nil {
+			mob.Send(MsgError, err.Error()+"\n")
+			return 0
+		}
+	case "wear":
+		if _, err := elt.WearFlags.ToggleName(value); err != nil {
+			mob.Send(MsgError, err.Error()+"\n")
+			return 0
+		}
+	default:
+		mob.Send(MsgError, "Usage: oedit [<vnum>|create|show|keywords|short|long|type|weight|cost|value|extra|wear] ...\n")
+		return 0
+	}
+
+	state.Changed[area] = true
+	mob.Send(MsgEnvironment, showObject(elt))
+	return 0
+}
+
+func showObject(elt *world.Object) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Object %d: %s\n", elt.Vnum, strings.Join(elt.Keywords, " "))
+	fmt.Fprintf(&buf, "Short: %s\nLong: %s\n", elt.ShortDescription, elt.LongDescription)
+	fmt.Fprintf(&buf, "Type: %d  Weight: %d  Cost: %d\n", elt.ItemType, elt.Weight, elt.Cost)
+	fmt.Fprintf(&buf, "Values: %d %d %d %d %d %d\n", elt.Value0, elt.Value1, elt.Value2, elt.Value3, elt.Value4, elt.Value5)
+	fmt.Fprintf(&buf, "Extra: %s\nWear: %s\n", elt.ExtraFlags.String(), elt.WearFlags.String())
+	return buf.String()
+}
+
+//
+// resets
+//
+
+func CmdResetedit(state *State, mob *Mob, cmd string) time.Duration {
+	area := editArea(state, mob)
+	if area == nil {
+		return 0
+	}
+	room := mob.Location
+
+	field, value := nextWord(cmd)
+	first, rest := nextWord(value)
+	vnum, err := strconv.Atoi(first)
+	max := 1
+	if n, err := strconv.Atoi(rest); err == nil {
+		max = n
+	}
+	reset := &world.Reset{MaxInstances: max}
+	switch field {
+	case "", "show":
+		mob.Send(MsgEnvironment, showResets(area))
+		return 0
+	case "mob":
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "Usage: resetedit mob <vnum> [<max>]\n")
+			return 0
+		}
+		if _, elt := state.MobileByVnum(vnum); elt == nil {
+			mob.Send(MsgError, "There is no mobile with that vnum.\n")
+			return 0
+		}
+		reset.Type, reset.RoomID, reset.MobileID = "M", room.ID, vnum
+	case "obj":
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "Usage: resetedit obj <vnum> [<max>]\n")
+			return 0
+		}
+		if _, elt := state.ObjectByVnum(vnum); elt == nil {
+			mob.Send(MsgError, "There is no object with that vnum.\n")
+			return 0
+		}
+		reset.Type, reset.RoomID, reset.ObjectID = "O", room.ID, vnum
+	case "give":
+		if err != nil || vnum < 1 {
+			mob.Send(MsgError, "Usage: resetedit give <vnum> [<max>]\n")
+			return 0
+		}
+		if _, elt := state.ObjectByVnum(vnum); elt == nil {
+			mob.Send(MsgError, "There is no object with that vnum.\n")
+			return 0
+		}
+		if len(area.Resets) == 0 || area.Resets[len(area.Resets)-1].Type != "M" {
+			mob.Send(MsgError, "A give reset must follow a mob reset.\n")
+			return 0
+		}
+		reset.Type, reset.ObjectID = "G", vnum
+	case "delete":
+		if err != nil || vnum < 1 || vnum > len(area.Resets) {
+			mob.Send(MsgError, "Usage: resetedit delete <number>\n")
+			return 0
+		}
+		area.Resets = append(area.Resets[:vnum-1], area.Resets[vnum:]...)
+		reset = nil
+	default:
+		mob.Send(MsgError, "Usage: resetedit [show|mob|obj|give|delete] ...\n")
+		return 0
+	}
+
+	if reset != nil {
+		area.Resets = append(area.Resets, reset)
+	}
+	for i, elt := range area.Resets {
+		elt.Sequence = i + 1
+	}
+	state.Changed[area] = true
+	mob.Send(MsgEnvironment, showResets(area))
+	return 0
+}
+
+func showResets(area *world.Area) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Resets for %s:\n", area.Name)
+	for i, reset := range area.Resets {
+		fmt.Fprintf(&buf, "%3d %-9s", i+1, world.ResetTypes[reset.Type])
+		if reset.RoomID != 0 {
+			fmt.Fprintf(&buf, " room %d", reset.RoomID)
+		}
+		if reset.MobileID != 0 {
+			fmt.Fprintf(&buf, " mobile %d", reset.MobileID)
+		}
+		if reset.ObjectID != 0 {
+			fmt.Fprintf(&buf, " object %d", reset.ObjectID)
+		}
+		if reset.ContainerID != 0 {
+			fmt.Fprintf(&buf, " in %d", reset.ContainerID)
+		}
+		if reset.MaxInstances != 0 {
+			fmt.Fprintf(&buf, " max %d", reset.MaxInstances)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+//
+// saving
+//
+
+// CmdAsave validates the changed areas that the builder can edit and
+// writes them to the database. The database work happens outside the
+// event loop.
+func CmdAsave(state *State, mob *Mob, cmd string) time.Duration {
+	var names []string
+	for area := range state.Changed {
+		if mob.Builder.CanEdit(area) {
+			names = append(names, area.Name)
+		}
+	}
+	if len(names) == 0 {
+		mob.Send(MsgEnvironment, "You have no unsaved changes.\n")
+		return 0
+	}
+
+	var paths []string
+	var files, save []*world.AreaFile
+	for _, file := range liveAreaFiles(state.Areas) {
+		paths = append(paths, "area "+file.Name)
+		files = append(files, file)
+		for _, name := range names {
+			if file.Name == name {
+				save = append(save, file)
+			}
+		}
+	}
+	if problems := world.ValidateAreaFiles(paths, files); len(problems) > 0 {
+		var buf bytes.Buffer
+		buf.WriteString("Unable to save:\n")
+		for _, problem := range problems {
+			fmt.Fprintf(&buf, "  %s\n", problem)
+		}
+		mob.Send(MsgError, buf.String())
+		return 0
+	}
+	var saving []*world.Area
+	for area := range state.Changed {
+		if mob.Builder.CanEdit(area) {
+			saving = append(saving, area)
+			delete(state.Changed, area)
+		}
+	}
+
+	mob.Send(MsgEnvironment, fmt.Sprintf("Saving %s...\n", strings.Join(names, ", ")))
+	db, q := state.DB, state.Events
+	go func() {
+		// report back through the event loop, which delivers what mobs say
+		if err := world.WriteAreasSQL(db, save); err != nil {
+			q.Schedule(func(state *State) {
+				mob.Send(MsgError, fmt.Sprintf("Saving failed: %v\n", err))
+				for _, area := range saving {
+					state.Changed[area] = true
+				}
+			}, 0)
+			return
+		}
+		q.Schedule(func(state *State) {
+			mob.Send(MsgEnvironment, "Saved.\n")
+		}, 0)
+	}()
+	return 0
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/martini-contrib/render"
+	"github.com/russross/meddler"
+)
+
+// Password hashing schemes by the name stored in User.Scheme. The name
+// records the parameters, so changing parameters means adding a new
+// scheme. Users with an older scheme are rehashed with the current one
+// the next time they sign in.
+var hashSchemes = map[string]func(password, salt []byte) ([]byte, error){
+	"PBKDF2-HMAC-SHA256:64k": func(password, salt []byte) ([]byte, error) {
+		return pbkdf2.Key(password, salt, 64*1024, 32, sha256.New), nil
+	},
+	"scrypt:N=32768,r=8,p=1": func(password, salt []byte) ([]byte, error) {
+		return scrypt.Key(password, salt, 32768, 8, 1, 32)
+	},
+	"argon2id:t=1,m=64M,p=4": func(password, salt []byte) ([]byte, error) {
+		return argon2.IDKey(password, salt, 1, 64*1024, 4, 32), nil
+	},
+}
+
+// the scheme used for new passwords
+const currentHashScheme = "argon2id:t=1,m=64M,p=4"
+
+// Hashing is slow and uses a lot of memory on purpose, so only a few
+// hashes run at once. Requests that cannot get a turn soon give up.
+var (
+	hashSlots   = make(chan struct{}, runtime.NumCPU())
+	hashTimeout = 5 * time.Second
+	errHashBusy = errors.New("server is busy: try again shortly")
+)
+
+func hashPassword(scheme, password string, salt []byte) ([]byte, error) {
+	hash, exists := hashSchemes[scheme]
+	if !exists {
+		return nil, fmt.Errorf("unknown hash scheme %q", scheme)
+	}
+	select {
+	case hashSlots <- struct{}{}:
+		defer func() { <-hashSlots }()
+	case <-time.After(hashTimeout):
+		return nil, errHashBusy
+	}
+	start := time.Now()
+	result, err := hash([]byte(password), salt)
+	log.Printf("%s hash took %v", scheme, time.Since(start))
+	return result, err
+}
+
+// setPassword hashes a new password with a new salt using the current
+// scheme
+func setPassword(user *User, password string) error {
+	salt := make([]byte, 16)
+	if _, err := crand.Read(salt); err != nil {
+		return fmt.Errorf("salt error: %v", err)
+	}
+	hash, err := hashPassword(currentHashScheme, password, salt)
+	if err != nil {
+		return err
+	}
+	user.Scheme = currentHashScheme
+	user.Salt = salt
+	user.PasswordHash = hash
+	user.Password = ""
+	return nil
+}
+
+// checkPassword reports whether a password matches, using the user's
+// own scheme
+func checkPassword(user *User, password string) (bool, error) {
+	hash, err := hashPassword(user.Scheme, password, user.Salt)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, user.PasswordHash) == 1, nil
+}
+
+// PasswordChange is a request to change the current user's password
+type PasswordChange struct {
+	CurrentPassword string
+	NewPassword     string
+}
+
+func ChangePassword(w http.ResponseWriter, tx *sql.Tx, change PasswordChange, currentUser *User, render render.Render) {
+	if len(change.NewPassword) < 12 || len(change.NewPassword) > 256 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "password must be between 12 and 256 characters")
+		return
+	}
+
+	ok, err := checkPassword(currentUser, change.CurrentPassword)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "wrong password")
+		return
+	}
+
+	if err = setPassword(currentUser, change.NewPassword); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	currentUser.ModifiedAt = time.Now()
+	if err = meddler.Update(tx, "users", currentUser); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, currentUser)
+}
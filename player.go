@@ -1,27 +1,224 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/russross/meddler"
 )
 
 const (
 	maxPlayerOutgoingQueueLength = 1000
 	fastCommandDelay             = 500 * time.Millisecond
+
+	// the most messages sent in one write
+	maxBatchLength = 100
+
+	// how long a signed-in player whose connection drops stays in the
+	// world waiting for them to reconnect
+	linkDeadTimeout = 10 * time.Minute
 )
 
+// A Player is the person controlling a mob. Messages queue up while the
+// player is link-dead and are delivered if they reconnect.
 type Player struct {
 	outgoingQueue    []Msg
 	outgoingNotEmpty sync.Cond
+
+	// set when messages have been posted but the writer not woken
+	posted bool
+
+	// the session recording, if there is one
+	recording *recorder
+
+	// set when an administrator wants this player recorded. Only used in
+	// the event loop.
+	recordForced bool
+
+	// the current connection, or nil if the player is link-dead
+	conn *playerConn
+
+	// counts connections, so a link-dead timeout can tell if the player
+	// has been back since
+	attached int
+
+	// set by Close: deliver what is queued and then disconnect with this
+	// websocket close code and reason
+	closing   bool
+	closeCode int
+	closeText string
+
+	// set by Detach: deliver what is queued and then stop without closing
+	// the connection, so it can be handed to a new process
+	detaching bool
+
+	// the last vitals and status sent, so only changes are sent. These
+	// are only used in the event loop.
+	sentVitals    *VitalsData
+	sentStatus    *StatusData
+	sentInventory []*Item
+
+	stats PlayerStats
+}
+
+// playerConn is one connection from a player
+type playerConn struct {
+	socket     playerSocket
+	detached   chan struct{}
+	readerDone chan struct{}
+
+	// what the client has asked for, guarded by the player's lock
+	client ClientSettings
+
+	// set when the connection is dropped for falling too far behind
+	slow bool
+
+	// set when another connection from the same player takes over
+	replaced bool
+}
+
+// Compression is used when the client offers permessage-deflate. The
+// write buffer holds a batch with a map in one frame.
+var playerUpgrader = websocket.Upgrader{
+	ReadBufferSize:    1024,
+	WriteBufferSize:   8192,
+	EnableCompression: true,
+}
+
+// playerSocket carries commands from a player and messages to them, over
+// a websocket from the browser client or a telnet connection
+type playerSocket interface {
+	ReadRequest() (*Request, error)
+
+	// WriteMsgs sends messages together. A websocket puts them in one
+	// frame if batch is set, and one frame each otherwise.
+	WriteMsgs(msgs []Msg, batch bool) error
+
+	// the settings a new connection starts with
+	Client() ClientSettings
+
+	// CloseWith says goodbye, using a websocket close code, and closes
+	CloseWith(code int, reason string)
+	Close() error
+
+	// Ping keeps the connection alive and checks that it still works
+	Ping() error
+
+	// the underlying connection, for copyover
+	NetConn() net.Conn
+
+	// StopReading makes the reader fail at the start of its next command,
+	// and Unread gives the input it has not handled once it has stopped.
+	// Copyover uses these to hand the input to the new process.
+	StopReading()
+	Unread() []byte
+}
+
+// wsSocket is a player connected through the browser client
+type wsSocket struct {
+	*websocket.Conn
+
+	// the extensions the client offered, so copyover can negotiate the
+	// same ones again
+	extensions string
+}
+
+// newWSSocket wraps a websocket. The client must answer pings, or reads
+// time out.
+func newWSSocket(conn *websocket.Conn, extensions string) wsSocket {
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	return wsSocket{Conn: conn, extensions: extensions}
+}
+
+func (s wsSocket) ReadRequest() (*Request, error) {
+	req := new(Request)
+	if err := s.ReadJSON(req); err != nil {
+		return nil, err
+	}
+	if req.Type == "" {
+		req.Type = "cmd"
+	}
+	return req, nil
+}
+
+// Client starts at protocol 0, which is text only, until the client says
+// hello
+func (s wsSocket) Client() ClientSettings {
+	return ClientSettings{Map: true}
+}
+
+func (s wsSocket) WriteMsgs(msgs []Msg, batch bool) error {
+	s.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if batch && len(msgs) > 1 {
+		return s.WriteJSON(msgs)
+	}
+	for i := range msgs {
+		if err := s.WriteJSON(&msgs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s wsSocket) Ping() error {
+	return s.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+func (s wsSocket) CloseWith(code int, reason string) {
+	s.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(5*time.Second))
+	s.Close()
+}
+
+func (s wsSocket) NetConn() net.Conn {
+	return s.UnderlyingConn()
 }
 
+// frames finds the frameConn that the websocket reads through, if any
+func (s wsSocket) frames() *frameConn {
+	conn := s.UnderlyingConn()
+	if elt, ok := conn.(*handshakeConn); ok {
+		conn = elt.Conn
+	}
+	frames, _ := conn.(*frameConn)
+	return frames
+}
+
+func (s wsSocket) StopReading() {
+	if frames := s.frames(); frames != nil {
+		frames.stop()
+	}
+}
+
+func (s wsSocket) Unread() []byte {
+	if frames := s.frames(); frames != nil {
+		return frames.unread()
+	}
+	return nil
+}
+
+// Request is a message from the client. See protocol.md.
 type Request struct {
-	Command string `json:"cmd"`
+	Type         string          `json:"type"`
+	Command      string          `json:"cmd"`
+	ID           string          `json:"id"`
+	Protocol     int             `json:"protocol"`
+	Capabilities []string        `json:"capabilities"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	Settings     map[string]bool `json:"settings"`
 }
 
 type MsgType string
@@ -32,141 +229,462 @@ const (
 	MsgEnvironment         = "environment"
 	MsgError               = "error"
 	MsgMap                 = "map"
+
+	// structured data in place of text: see gmcp.go
+	MsgVitals    = "vitals"
+	MsgStatus    = "status"
+	MsgRoom      = "room"
+	MsgChannel   = "channel"
+	MsgInventory = "inventory"
+
+	// protocol messages: see protocol.go
+	MsgHello    = "hello"
+	MsgPong     = "pong"
+	MsgSettings = "settings"
 )
 
 type Msg struct {
-	Type    MsgType `json:"type"`
-	Message string  `json:"msg"`
+	Type    MsgType     `json:"type"`
+	Message string      `json:"msg"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
+// Send queues a message and wakes the writer right away
 func (p *Player) Send(msg Msg) {
+	p.send(msg, true)
+}
+
+// Post queues a message to go out when the current event is over, so
+// everything an event says goes out together. Only the event loop posts.
+func (p *Player) Post(msg Msg) {
+	p.send(msg, false)
+}
+
+// Flush wakes the writer for anything posted
+func (p *Player) Flush() {
 	p.outgoingNotEmpty.L.Lock()
 	defer p.outgoingNotEmpty.L.Unlock()
+	if p.posted {
+		p.posted = false
+		p.outgoingNotEmpty.Broadcast()
+	}
+}
 
-	// nil queue means the connection is closed/closing
-	if p.outgoingQueue == nil {
+func (p *Player) send(msg Msg, wake bool) {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+
+	// nil queue means the player is gone
+	if p.outgoingQueue == nil || p.closing {
 		return
 	}
 
+	// a newer message replaces one of the same kind still waiting
+	if coalescedMsgTypes[msg.Type] {
+		for i, elt := range p.outgoingQueue {
+			if elt.Type == msg.Type {
+				p.outgoingQueue = append(p.outgoingQueue[:i], p.outgoingQueue[i+1:]...)
+				p.stats.Coalesced++
+				break
+			}
+		}
+	}
+
 	// add the response to the queue
 	p.outgoingQueue = append(p.outgoingQueue, msg)
 
-	// if the queue is overflowing, truncate it, keeping the most recent
-	if len(p.outgoingQueue) > maxPlayerOutgoingQueueLength {
-		log.Printf("truncating outgoing queue for player ???")
-		p.outgoingQueue = p.outgoingQueue[len(p.outgoingQueue)-maxPlayerOutgoingQueueLength:]
+	// if the queue is overflowing, truncate it, keeping the most recent. A
+	// connection that cannot keep up is dropped, and the reader marks the
+	// player link-dead.
+	if extra := len(p.outgoingQueue) - maxPlayerOutgoingQueueLength; extra > 0 {
+		if p.conn != nil && !p.conn.slow && !p.detaching {
+			p.conn.slow = true
+			p.stats.SlowDisconnects++
+			p.conn.socket.Close()
+		}
+		p.stats.Dropped += extra
+		p.outgoingQueue = p.outgoingQueue[extra:]
 	}
 
 	// wake up the goroutine that delivers messages
-	p.outgoingNotEmpty.Signal()
+	if wake {
+		p.posted = false
+		p.outgoingNotEmpty.Broadcast()
+	} else {
+		p.posted = true
+	}
+}
+
+// Close disconnects the player once the messages already queued have
+// been delivered
+func (p *Player) Close(code int, reason string) {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	p.closing = true
+	p.closeCode = code
+	p.closeText = reason
+	p.outgoingNotEmpty.Broadcast()
+}
+
+// LinkDead reports whether the player has no connection
+func (p *Player) LinkDead() bool {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	return p.conn == nil
 }
 
-func HandleIncommingConnection(w http.ResponseWriter, r *http.Request, q Queue) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		//EnableCompression: true,
+func HandleIncommingConnection(w http.ResponseWriter, r *http.Request, db *sql.DB, q Queue) {
+	if !AcceptingPlayers() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
 	}
-	socket, err := upgrader.Upgrade(w, r, nil)
+	socket, err := upgradePlayer(w, r)
 
 	if err != nil {
 		log.Printf("websocket error: %v", err)
 		return
 	}
 
-	// TODO: get user, character, and auth token from headers
+	// TODO: get character from headers
 
-	player := &Player{
-		outgoingQueue: []Msg{},
+	// banned users cannot play, and authors and admins who are signed in
+	// to the API can build
+	var user *User
+	var builder *Builder
+	if session, err := GetSession(r); err == nil {
+		signedIn := new(User)
+		if err := meddler.Load(db, "users", signedIn, session.UserID); err != nil {
+			log.Printf("loading user %d: %v", session.UserID, err)
+		} else if signedIn.Banned {
+			socket.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "account is banned"),
+				time.Now().Add(5*time.Second))
+			socket.Close()
+			return
+		} else {
+			user = signedIn
+			if builder, err = LoadBuilder(db, user.ID); err != nil {
+				log.Printf("loading builder for user %d: %v", user.ID, err)
+			}
+		}
+	}
+
+	if err := socket.WriteJSON(helloMsg()); err != nil {
+		socket.Close()
+		return
+	}
+	mob, conn := joinGame(q, newWSSocket(socket, r.Header.Get("Sec-Websocket-Extensions")), user, builder, characterChoice{})
+	mob.Player.serve(conn, mob, builder, q)
+}
+
+// joinGame connects a player to the game. A signed-in player picks up the
+// character they chose, from the world if it is still there or as it was
+// saved if not, and everyone else gets a new one. The user is nil for
+// guests.
+func joinGame(q Queue, socket playerSocket, user *User, builder *Builder, choice characterChoice) (*Mob, *playerConn) {
+	var userID int64
+	if user != nil {
+		userID = user.ID
 	}
-	player.outgoingNotEmpty.L = new(sync.Mutex)
 	var mob *Mob
+	var conn *playerConn
 	ready := make(chan struct{})
 
 	q.Schedule(func(state *State) {
-		now := time.Now()
-		mob = &Mob{
-			Name:             "Gnoric",
-			Location:         state.Rooms[3001],
-			StartLocation:    state.Rooms[3001],
-			Visited:          make([]bool, len(state.Rooms)),
-			State:            StateStanding,
-			SlowBlockedUntil: now,
-			FastBlockedUntil: now,
-			Player:           player,
-		}
-		for i := 0; i < len(mob.Visited); i++ {
-			if state.Rooms[i] != nil {
-				mob.Visited[i] = true
-			}
-		}
-		ready <- struct{}{}
+		if userID != 0 && choice.Name == "" {
+			for _, elt := range state.Mobs {
+				if elt.UserID == userID && elt.Player != nil && (choice.ID == 0 || elt.CharacterID == choice.ID) {
+					mob = elt
+					break
+				}
+			}
+		}
+		if mob != nil {
+			var missed int
+			conn, missed = mob.Player.attach(socket)
+			mob.Builder = builder
+			if missed > 0 {
+				mob.Send(MsgEnvironment, fmt.Sprintf("You have reconnected. %d messages arrived while you were away.\n", missed))
+			} else {
+				mob.Send(MsgEnvironment, "You have reconnected.\n")
+			}
+			log.Printf("user %d reconnected to %s", userID, mob.Name)
+			mob.recordJoin(user)
+			close(ready)
+			return
+		}
+
+		// a signed-in player who left the game picks up their saved
+		// character, the one played last if they did not pick one, and
+		// everyone else starts fresh
+		if userID != 0 && choice.Name == "" {
+			if choice.ID == 0 {
+				if list, err := listCharacters(state.DB, userID); err != nil {
+					log.Printf("listing characters for user %d: %v", userID, err)
+				} else if len(list) > 0 {
+					choice.ID = list[0].ID
+				}
+			}
+			if choice.ID != 0 {
+				if mob = state.LoadCharacter(choice.ID); mob != nil && mob.UserID != userID {
+					mob = nil
+				}
+			}
+		}
+		if mob != nil {
+			mob.Player, mob.Builder = newPlayer(), builder
+			log.Printf("user %d returned to %s", userID, mob.Name)
+		} else {
+			name := "Gnoric"
+			if choice.Name != "" {
+				name = choice.Name
+			}
+			now := time.Now()
+			start := state.RoomByVnum(RecallLocation)
+			mob = &Mob{
+				Name:             name,
+				Location:         start,
+				StartLocation:    start,
+				Visited:          make([]bool, len(state.Rooms)),
+				State:            StateStanding,
+				SlowBlockedUntil: now,
+				FastBlockedUntil: now,
+				Player:           newPlayer(),
+				Builder:          builder,
+				UserID:           userID,
+			}
+			for i := 0; i < len(mob.Visited); i++ {
+				if state.Rooms[i] != nil {
+					mob.Visited[i] = true
+				}
+			}
+		}
+		conn, _ = mob.Player.attach(socket)
+		state.Mobs = append(state.Mobs, mob)
+		if mob.CharacterID == 0 {
+			// save a new character right away so it can be chosen
+			// when the player comes back
+			state.SaveCharacter(mob)
+		}
+		mob.recordJoin(user)
+		close(ready)
 	}, 0)
 	<-ready
 	q.Schedule(func(state *State) {
 		CmdLook(state, mob, "")
 	}, 0)
+	return mob, conn
+}
+
+func newPlayer() *Player {
+	player := &Player{outgoingQueue: []Msg{}}
+	player.outgoingNotEmpty.L = new(sync.Mutex)
+	return player
+}
+
+// attach makes socket the player's connection, taking over from any other
+// connection, and reports how many messages were waiting for a link-dead
+// player
+func (p *Player) attach(socket playerSocket) (*playerConn, int) {
+	conn := &playerConn{
+		socket:     socket,
+		detached:   make(chan struct{}),
+		readerDone: make(chan struct{}),
+		client:     socket.Client(),
+	}
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	missed := 0
+	if p.conn != nil {
+		p.conn.replaced = true
+	} else {
+		missed = len(p.outgoingQueue)
+	}
+	p.conn = conn
+	p.attached++
+	p.closing, p.closeCode, p.closeText = false, 0, ""
+	p.detaching = false
+	p.sentVitals, p.sentStatus, p.sentInventory = nil, nil, nil
+	if p.outgoingQueue == nil {
+		p.outgoingQueue = []Msg{}
+	}
+	p.outgoingNotEmpty.Broadcast()
+	return conn, missed
+}
+
+// serve delivers messages to the player in the background and reads
+// commands until the connection closes, is replaced, or is detached
+func (player *Player) serve(conn *playerConn, mob *Mob, builder *Builder, q Queue) {
+	socket := conn.socket
 
 	// a goroutine that sends messages to the player
+	playerConnections.Add(1)
 	go func() {
+		defer playerConnections.Done()
 		for {
 			player.outgoingNotEmpty.L.Lock()
 
 			// wait for data to transmit
-			for len(player.outgoingQueue) == 0 && player.outgoingQueue != nil {
+			for len(player.outgoingQueue) == 0 && player.conn == conn && !player.closing && !player.detaching {
 				player.outgoingNotEmpty.Wait()
 			}
 
-			if player.outgoingQueue == nil {
-				// our signal to quit
+			if player.conn != conn {
+				// the link dropped, or another connection took over
+				replaced := conn.replaced
 				player.outgoingNotEmpty.L.Unlock()
-				socket.Close()
+				if replaced {
+					socket.CloseWith(websocket.CloseNormalClosure, "connected from somewhere else")
+				} else {
+					socket.Close()
+				}
+				break
+			}
+
+			if player.detaching && len(player.outgoingQueue) == 0 {
+				// leave the connection open for the next process
+				player.outgoingNotEmpty.L.Unlock()
+				close(conn.detached)
+				break
+			}
+
+			if player.closing && len(player.outgoingQueue) == 0 {
+				// closing with nothing left to send
+				code, reason := player.closeCode, player.closeText
+				player.outgoingQueue = nil
+				player.outgoingNotEmpty.L.Unlock()
+				socket.CloseWith(code, reason)
 				break
 			}
 
-			// get the item from the queue
-			elt := player.outgoingQueue[0]
-			player.outgoingQueue = player.outgoingQueue[1:]
+			// take what is waiting, up to a batch
+			count := len(player.outgoingQueue)
+			if count > maxBatchLength {
+				count = maxBatchLength
+			}
+			var batch []Msg
+			for _, elt := range player.outgoingQueue[:count] {
+				if conn.client.wants(&elt) {
+					batch = append(batch, elt)
+				}
+			}
+			player.outgoingQueue = player.outgoingQueue[count:]
+			player.stats.Sent += len(batch)
+			if len(batch) > 0 {
+				player.stats.Writes++
+			}
+			batched := conn.client.has("batch")
+			rec := player.recording
 			player.outgoingNotEmpty.L.Unlock()
+			if len(batch) == 0 {
+				continue
+			}
+			if rec != nil {
+				rec.record(&RecordEntry{Event: RecordOut, Msgs: batch})
+			}
 
-			if err := socket.WriteJSON(&elt); err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") ||
-					strings.Contains(err.Error(), "close 1005") {
-					// websocket closed
-				} else {
-					log.Printf("websocket write error: %v", err)
-					socket.WriteControl(websocket.CloseMessage, nil, time.Now().Add(5*time.Second))
-					socket.Close()
+			if err := socket.WriteMsgs(batch, batched); err != nil {
+				if !closedError(err) {
+					log.Printf("player write error: %v", err)
 				}
 
-				// stop accumulating messages in the queue
+				// the reader will notice and mark the player link-dead
+				socket.Close()
 				player.outgoingNotEmpty.L.Lock()
-				player.outgoingQueue = nil
+				if player.detaching {
+					close(conn.detached)
+				}
 				player.outgoingNotEmpty.L.Unlock()
 				break
 			}
 		}
 	}()
 
-	// the main goroutine reads commands from the player
-	for {
-		req := new(Request)
-		if err := socket.ReadJSON(req); err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") ||
-				strings.Contains(err.Error(), "close 1005") {
-				// websocket closed
-			} else {
-				log.Printf("websocket read error: %v", err)
-				socket.WriteControl(websocket.CloseMessage, nil, time.Now().Add(5*time.Second))
-				socket.Close()
+	// a goroutine that pings the client when it is quiet
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-conn.readerDone:
+				return
+			case <-conn.detached:
+				return
+			case <-ticker.C:
+				if err := socket.Ping(); err != nil {
+					return
+				}
 			}
+		}
+	}()
 
-			// close the queue of outgoing messages
+	// the main goroutine reads commands from the player
+	defer close(conn.readerDone)
+	limiter := newCommandLimiter()
+	for {
+		req, err := socket.ReadRequest()
+		if err != nil {
 			player.outgoingNotEmpty.L.Lock()
-			player.outgoingQueue = nil
-			player.outgoingNotEmpty.Signal()
+			detaching, current, slow := player.detaching, player.conn == conn, conn.slow
+			if current && !detaching {
+				player.conn = nil
+			}
+			player.outgoingNotEmpty.Broadcast()
 			player.outgoingNotEmpty.L.Unlock()
-			break
+
+			if detaching {
+				// the connection belongs to the next process now
+				return
+			}
+			if slow {
+				log.Printf("user %d (%s) fell %d messages behind: dropped the connection", mob.UserID, mob.Name, maxPlayerOutgoingQueueLength)
+			} else if current && !closedError(err) {
+				log.Printf("player read error: %v", err)
+			}
+			if stats := player.Stats(); stats.Dropped > 0 || stats.CommandsDropped > 0 {
+				log.Printf("user %d (%s) disconnected with %d messages and %d commands dropped", mob.UserID, mob.Name, stats.Dropped, stats.CommandsDropped)
+			}
+			socket.Close()
+
+			if current {
+				player.note(&RecordEntry{Event: RecordLinkDead})
+				q.Schedule(func(state *State) {
+					state.LinkDead(mob)
+				}, 0)
+			}
+			return
+		}
+
+		ok, warn, flood := limiter.allow(time.Now())
+		player.outgoingNotEmpty.L.Lock()
+		if ok {
+			player.stats.Commands++
+		} else {
+			player.stats.CommandsDropped++
+		}
+		rec := player.recording
+		player.outgoingNotEmpty.L.Unlock()
+		if rec != nil {
+			rec.record(&RecordEntry{Event: RecordIn, Request: req})
+		}
+		if flood {
+			if limiter.dropped == commandFloodLimit {
+				log.Printf("user %d (%s) is flooding: disconnecting", mob.UserID, mob.Name)
+				player.Send(Msg{Type: MsgError, Message: "You have been disconnected for sending too many commands.\n"})
+				player.Close(websocket.ClosePolicyViolation, "too many commands")
+			}
+			continue
+		}
+		if warn {
+			player.Send(Msg{Type: MsgError, Message: "You are sending commands too quickly. Some were ignored.\n"})
+		}
+		if !ok {
+			continue
+		}
+
+		if req.Type != "cmd" {
+			player.handleRequest(conn, mob, req, q)
+			continue
 		}
 
 		// parse and enqueue the command
@@ -176,11 +694,52 @@ func HandleIncommingConnection(w http.ResponseWriter, r *http.Request, q Queue)
 			continue
 		}
 
+		if !cmd.allowed(builder) {
+			player.Send(Msg{Type: MsgError, Message: "Huh?"})
+			continue
+		}
+
 		q.Schedule(func(state *State) {
 			cmd.Execute(state, mob, rest)
 		}, 0)
 	}
+}
+
+// closedError reports whether err just means the connection was closed
+func closedError(err error) bool {
+	return err == io.EOF ||
+		strings.Contains(err.Error(), "use of closed network connection") ||
+		strings.Contains(err.Error(), "close 1005")
+}
+
+// LinkDead is called when a player's connection drops. Players who are
+// not signed in cannot come back, so they leave right away. Others stay
+// in the world for a while in case they reconnect.
+func (state *State) LinkDead(mob *Mob) {
+	player := mob.Player
+	player.outgoingNotEmpty.L.Lock()
+	reconnected := player.conn != nil
+	gone := player.closing || player.outgoingQueue == nil
+	attached := player.attached
+	player.outgoingNotEmpty.L.Unlock()
+	if reconnected {
+		return
+	}
+	if gone || mob.UserID == 0 {
+		state.SaveCharacter(mob)
+		state.RemoveMob(mob)
+		return
+	}
 
-	// just to be sure
-	socket.Close()
+	log.Printf("user %d (%s) is link-dead", mob.UserID, mob.Name)
+	state.Events.Schedule(func(state *State) {
+		player.outgoingNotEmpty.L.Lock()
+		back := player.conn != nil || player.attached != attached
+		player.outgoingNotEmpty.L.Unlock()
+		if !back {
+			log.Printf("user %d (%s) timed out while link-dead", mob.UserID, mob.Name)
+			state.SaveCharacter(mob)
+			state.RemoveMob(mob)
+		}
+	}, linkDeadTimeout)
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The websocket protocol is described in protocol.md. The server opens
+// with a hello naming its protocol version and capabilities, and the
+// client answers with its own. The lower version wins. Clients that never
+// say hello get only text, as before the protocol was versioned.
+const protocolVersion = 1
+
+var serverCapabilities = []string{"batch", "data", "ping", "resize", "settings"}
+
+const (
+	defaultMapDepth = 3
+	maxMapDepth     = 6
+)
+
+// ClientSettings is what a connection has agreed to
+type ClientSettings struct {
+	Protocol     int      `json:"protocol"`
+	Capabilities []string `json:"capabilities"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	Map          bool     `json:"map"`
+}
+
+type HelloData struct {
+	Protocol     int      `json:"protocol"`
+	Server       string   `json:"server"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type PongData struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+type InventoryData struct {
+	Items []ItemData `json:"items"`
+}
+
+type ItemData struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Short string `json:"short"`
+}
+
+func helloMsg() Msg {
+	return Msg{Type: MsgHello, Data: HelloData{
+		Protocol:     protocolVersion,
+		Server:       "gruffles",
+		Capabilities: serverCapabilities,
+	}}
+}
+
+func (client *ClientSettings) has(capability string) bool {
+	for _, elt := range client.Capabilities {
+		if elt == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// wants reports whether a message should be sent to this client. Replies
+// to requests always go, and data without text only goes to clients that
+// asked for it.
+func (client *ClientSettings) wants(msg *Msg) bool {
+	switch {
+	case msg.Type == MsgHello || msg.Type == MsgPong || msg.Type == MsgSettings:
+		return true
+	case msg.Type == MsgMap:
+		return client.Map
+	case msg.Data != nil && msg.Message == "":
+		return client.Protocol >= 1 && client.has("data")
+	}
+	return true
+}
+
+// mapDepth gives how many rooms the map reaches in each direction. Each
+// room takes four characters, with a border of two around the edge.
+func (client *ClientSettings) mapDepth() int {
+	size := client.Width
+	if client.Height < size {
+		size = client.Height
+	}
+	if size <= 0 {
+		return defaultMapDepth
+	}
+	depth := (size - 5) / 8
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxMapDepth {
+		depth = maxMapDepth
+	}
+	return depth
+}
+
+// mapDepth gives the map depth for the player's current connection
+func (p *Player) mapDepth() int {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	if p.conn == nil {
+		return defaultMapDepth
+	}
+	return p.conn.client.mapDepth()
+}
+
+// handleRequest deals with requests other than commands. It runs in the
+// goroutine reading from the connection.
+func (player *Player) handleRequest(conn *playerConn, mob *Mob, req *Request, q Queue) {
+	settings := func() {
+		player.outgoingNotEmpty.L.Lock()
+		client := conn.client
+		player.outgoingNotEmpty.L.Unlock()
+		player.Send(Msg{Type: MsgSettings, Data: client})
+	}
+
+	switch req.Type {
+	case "hello":
+		if req.Protocol < 1 {
+			player.Send(Msg{Type: MsgError, Message: fmt.Sprintf("Unsupported protocol version %d: this server speaks 1 to %d\n", req.Protocol, protocolVersion)})
+			settings()
+			return
+		}
+		player.outgoingNotEmpty.L.Lock()
+		conn.client.Protocol = req.Protocol
+		if conn.client.Protocol > protocolVersion {
+			conn.client.Protocol = protocolVersion
+		}
+		conn.client.Capabilities = nil
+		for _, elt := range req.Capabilities {
+			for _, offered := range serverCapabilities {
+				if elt == offered {
+					conn.client.Capabilities = append(conn.client.Capabilities, elt)
+				}
+			}
+		}
+		player.outgoingNotEmpty.L.Unlock()
+		settings()
+
+		// data sent before the hello was dropped, so start over
+		q.Schedule(func(state *State) {
+			player.sentVitals, player.sentStatus, player.sentInventory = nil, nil, nil
+			if mob.Location != nil {
+				mob.SendLocation(state)
+			}
+		}, 0)
+
+	case "ping":
+		// answer from the event loop, after any commands sent before it
+		q.Schedule(func(*State) {
+			player.Send(Msg{Type: MsgPong, Data: PongData{ID: req.ID, Time: time.Now()}})
+		}, 0)
+
+	case "resize":
+		player.outgoingNotEmpty.L.Lock()
+		conn.client.Width, conn.client.Height = req.Width, req.Height
+		player.outgoingNotEmpty.L.Unlock()
+		settings()
+
+		// redraw the map at the new size
+		q.Schedule(func(state *State) {
+			if mob.Location != nil {
+				mob.SendLocation(state)
+			}
+		}, 0)
+
+	case "settings":
+		var unknown []string
+		player.outgoingNotEmpty.L.Lock()
+		for key, value := range req.Settings {
+			switch key {
+			case "map":
+				conn.client.Map = value
+			default:
+				unknown = append(unknown, key)
+			}
+		}
+		player.outgoingNotEmpty.L.Unlock()
+		if len(unknown) > 0 {
+			player.Send(Msg{Type: MsgError, Message: fmt.Sprintf("Unknown settings: %v\n", unknown)})
+		}
+		settings()
+
+	default:
+		player.Send(Msg{Type: MsgError, Message: fmt.Sprintf("Unknown request type %q\n", req.Type)})
+	}
+}
+
+// inventoryData lists what a mob is carrying
+func inventoryData(mob *Mob) InventoryData {
+	data := InventoryData{Items: []ItemData{}}
+	for _, item := range mob.Inventory {
+		data.Items = append(data.Items, ItemData{ID: item.ID, Name: item.Name, Short: item.ShortDescription})
+	}
+	return data
+}
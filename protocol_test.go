@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// testPlayer gives a player with a connection that has not said hello,
+// and a queue for the events its requests schedule
+func testPlayer() (*Player, *playerConn, *Mob, chan Event) {
+	player := newPlayer()
+	conn := &playerConn{client: ClientSettings{Map: true}}
+	player.conn = conn
+	mob := &Mob{Player: player}
+	return player, conn, mob, make(chan Event, 10)
+}
+
+// sent takes the messages waiting for a player, after running any events
+// that were scheduled
+func sent(player *Player, q chan Event) []Msg {
+	state := new(State)
+	for len(q) > 0 {
+		e := <-q
+		e.What(state)
+	}
+	player.outgoingNotEmpty.L.Lock()
+	defer player.outgoingNotEmpty.L.Unlock()
+	msgs := player.outgoingQueue
+	player.outgoingQueue = []Msg{}
+	return msgs
+}
+
+func msgTypes(msgs []Msg) []string {
+	var list []string
+	for _, msg := range msgs {
+		list = append(list, string(msg.Type))
+	}
+	return list
+}
+
+func TestHelloNegotiation(t *testing.T) {
+	tests := []struct {
+		protocol     int
+		capabilities []string
+		agreed       []string
+	}{
+		{1, []string{"data", "batch"}, []string{"data", "batch"}},
+		{1, nil, nil},
+		{1, []string{"data", "telepathy", "ping"}, []string{"data", "ping"}},
+		{7, serverCapabilities, serverCapabilities},
+	}
+	for _, test := range tests {
+		player, conn, mob, q := testPlayer()
+		player.sentVitals = &VitalsData{HP: 10}
+		player.handleRequest(conn, mob, &Request{Type: "hello", Protocol: test.protocol, Capabilities: test.capabilities}, q)
+		msgs := sent(player, q)
+		if len(msgs) != 1 || msgs[0].Type != MsgSettings {
+			t.Fatalf("hello %d got %v, expected settings", test.protocol, msgTypes(msgs))
+		}
+		client := msgs[0].Data.(ClientSettings)
+		if client.Protocol != protocolVersion {
+			t.Errorf("hello %d agreed on protocol %d, expected %d", test.protocol, client.Protocol, protocolVersion)
+		}
+		if !reflect.DeepEqual(client.Capabilities, test.agreed) {
+			t.Errorf("hello %d with %v agreed on %v, expected %v", test.protocol, test.capabilities, client.Capabilities, test.agreed)
+		}
+		if !reflect.DeepEqual(conn.client, client) {
+			t.Errorf("hello %d: connection has %+v but the reply said %+v", test.protocol, conn.client, client)
+		}
+		if player.sentVitals != nil {
+			t.Errorf("hello %d did not reset the data already sent", test.protocol)
+		}
+	}
+}
+
+func TestHelloVersionRejected(t *testing.T) {
+	for _, version := range []int{0, -1} {
+		player, conn, mob, q := testPlayer()
+		player.handleRequest(conn, mob, &Request{Type: "hello", Protocol: version, Capabilities: []string{"data"}}, q)
+		msgs := sent(player, q)
+		if got := msgTypes(msgs); !reflect.DeepEqual(got, []string{MsgError, MsgSettings}) {
+			t.Fatalf("hello %d got %v, expected an error and settings", version, got)
+		}
+		if !strings.Contains(msgs[0].Message, "Unsupported protocol version") {
+			t.Errorf("hello %d got error %q", version, msgs[0].Message)
+		}
+		if conn.client.Protocol != 0 || conn.client.Capabilities != nil {
+			t.Errorf("hello %d changed the settings to %+v", version, conn.client)
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	vitals := Msg{Type: MsgVitals, Data: VitalsData{HP: 10}}
+	text := Msg{Type: MsgEnvironment, Message: "A temple.\n"}
+	mapMsg := Msg{Type: MsgMap, Message: "+--+\n"}
+	pong := Msg{Type: MsgPong, Data: PongData{ID: "1"}}
+	settings := Msg{Type: MsgSettings, Data: ClientSettings{}}
+
+	textOnly := ClientSettings{Map: true}
+	noData := ClientSettings{Protocol: 1, Capabilities: []string{"batch"}, Map: true}
+	data := ClientSettings{Protocol: 1, Capabilities: []string{"data"}, Map: true}
+	noMap := ClientSettings{Protocol: 1, Capabilities: []string{"data"}}
+	oldWithData := ClientSettings{Capabilities: []string{"data"}, Map: true}
+
+	tests := []struct {
+		name   string
+		client ClientSettings
+		msg    Msg
+		wants  bool
+	}{
+		{"text only, text", textOnly, text, true},
+		{"text only, data", textOnly, vitals, false},
+		{"text only, map", textOnly, mapMsg, true},
+		{"text only, pong", textOnly, pong, true},
+		{"text only, settings", textOnly, settings, true},
+		{"no data, data", noData, vitals, false},
+		{"data, data", data, vitals, true},
+		{"data, text", data, text, true},
+		{"no map, map", noMap, mapMsg, false},
+		{"no map, data", noMap, vitals, true},
+		{"protocol 0 with data, data", oldWithData, vitals, false},
+	}
+	for _, test := range tests {
+		if got := test.client.wants(&test.msg); got != test.wants {
+			t.Errorf("%s: wants gave %v", test.name, got)
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	player, conn, mob, q := testPlayer()
+
+	player.handleRequest(conn, mob, &Request{Type: "ping", ID: "abc"}, q)
+	msgs := sent(player, q)
+	if len(msgs) != 1 || msgs[0].Type != MsgPong || msgs[0].Data.(PongData).ID != "abc" {
+		t.Errorf("ping got %+v, expected a pong with id abc", msgs)
+	}
+
+	player.handleRequest(conn, mob, &Request{Type: "resize", Width: 80, Height: 24}, q)
+	msgs = sent(player, q)
+	if got := msgTypes(msgs); !reflect.DeepEqual(got, []string{MsgSettings}) {
+		t.Errorf("resize got %v", got)
+	} else if client := msgs[0].Data.(ClientSettings); client.Width != 80 || client.Height != 24 {
+		t.Errorf("resize gave settings %+v", client)
+	}
+	if depth := player.mapDepth(); depth != 2 {
+		t.Errorf("map depth is %d at 80x24, expected 2", depth)
+	}
+
+	player.handleRequest(conn, mob, &Request{Type: "settings", Settings: map[string]bool{"map": false}}, q)
+	msgs = sent(player, q)
+	if got := msgTypes(msgs); !reflect.DeepEqual(got, []string{MsgSettings}) || conn.client.Map {
+		t.Errorf("turning the map off got %v and left map %v", got, conn.client.Map)
+	}
+
+	player.handleRequest(conn, mob, &Request{Type: "settings", Settings: map[string]bool{"colour": true}}, q)
+	msgs = sent(player, q)
+	if got := msgTypes(msgs); !reflect.DeepEqual(got, []string{MsgError, MsgSettings}) {
+		t.Errorf("an unknown setting got %v", got)
+	} else if !strings.Contains(msgs[0].Message, "colour") {
+		t.Errorf("an unknown setting got error %q", msgs[0].Message)
+	}
+
+	player.handleRequest(conn, mob, &Request{Type: "teleport"}, q)
+	msgs = sent(player, q)
+	if len(msgs) != 1 || msgs[0].Type != MsgError || !strings.Contains(msgs[0].Message, "teleport") {
+		t.Errorf("an unknown request got %+v", msgs)
+	}
+}
+
+// wsPair connects a client to a server socket over a real websocket
+func wsPair(t *testing.T) (wsSocket, *websocket.Conn) {
+	t.Helper()
+	upgraded := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := playerUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading: %v", err)
+			close(upgraded)
+			return
+		}
+		upgraded <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn := <-upgraded
+	if conn == nil {
+		t.FailNow()
+	}
+	socket := newWSSocket(conn, "")
+	t.Cleanup(func() { socket.Close() })
+	return socket, client
+}
+
+func TestReadRequest(t *testing.T) {
+	socket, client := wsPair(t)
+	tests := []struct {
+		raw string
+		req Request
+	}{
+		{`{"cmd": "look"}`, Request{Type: "cmd", Command: "look"}},
+		{`{"type": "cmd", "cmd": "north"}`, Request{Type: "cmd", Command: "north"}},
+		{`{"type": "hello", "protocol": 1, "capabilities": ["data", "batch"]}`,
+			Request{Type: "hello", Protocol: 1, Capabilities: []string{"data", "batch"}}},
+		{`{"type": "ping", "id": "7"}`, Request{Type: "ping", ID: "7"}},
+		{`{"type": "resize", "width": 80, "height": 24}`, Request{Type: "resize", Width: 80, Height: 24}},
+		{`{"type": "settings", "settings": {"map": false}}`, Request{Type: "settings", Settings: map[string]bool{"map": false}}},
+	}
+	for _, test := range tests {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(test.raw)); err != nil {
+			t.Fatal(err)
+		}
+		req, err := socket.ReadRequest()
+		if err != nil {
+			t.Fatalf("%s: %v", test.raw, err)
+		}
+		if !reflect.DeepEqual(*req, test.req) {
+			t.Errorf("%s read as %+v, expected %+v", test.raw, *req, test.req)
+		}
+	}
+}
+
+func TestWriteMsgs(t *testing.T) {
+	socket, client := wsPair(t)
+	msgs := []Msg{
+		helloMsg(),
+		{Type: MsgEnvironment, Message: "A temple.\n"},
+		{Type: MsgRoom, Data: RoomData{Num: 3001, Name: "Temple", Area: "Midgaard", Environment: "inside", Exits: map[string]int{"n": 3002, "s": 3000}}},
+		{Type: MsgVitals, Data: VitalsData{HP: 10, MaxHP: 20, Mana: 30, MaxMana: 40, Move: 50, MaxMove: 60}},
+	}
+	expected := []string{
+		`{"type":"hello","msg":"","data":{"protocol":1,"server":"gruffles","capabilities":["batch","data","ping","resize","settings"]}}`,
+		`{"type":"environment","msg":"A temple.\n"}`,
+		`{"type":"room","msg":"","data":{"num":3001,"name":"Temple","area":"Midgaard","environment":"inside","exits":{"n":3002,"s":3000}}}`,
+		`{"type":"vitals","msg":"","data":{"hp":10,"maxhp":20,"mana":30,"maxmana":40,"move":50,"maxmove":60}}`,
+	}
+
+	// one frame each
+	if err := socket.WriteMsgs(msgs, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range expected {
+		_, raw, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(raw)); got != want {
+			t.Errorf("sent %s\nexpected %s", got, want)
+		}
+	}
+
+	// one frame for the batch
+	if err := socket.WriteMsgs(msgs, true); err != nil {
+		t.Fatal(err)
+	}
+	_, raw, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(raw)), "["+strings.Join(expected, ",")+"]"; got != want {
+		t.Errorf("sent batch %s\nexpected %s", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session recordings capture what a player sent and what they were sent,
+// for support and for building regression tests. Players turn recording
+// on and off with the record command, and administrators can force it on
+// for a user. Each stay in the world is one file of JSON lines in the
+// recordings directory, and `gruffles replay` plays them back.
+
+// RecordEntry is one line of a recording
+type RecordEntry struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+
+	// in: a request from the player
+	Request *Request `json:"request,omitempty"`
+
+	// out: messages written to the player together
+	Msgs []Msg `json:"msgs,omitempty"`
+
+	// start
+	Name   string `json:"name,omitempty"`
+	UserID int64  `json:"user,omitempty"`
+
+	// connect
+	Client *ClientSettings `json:"client,omitempty"`
+
+	// start and stop
+	Reason string `json:"reason,omitempty"`
+}
+
+const (
+	RecordStart    = "start"
+	RecordStop     = "stop"
+	RecordIn       = "in"
+	RecordOut      = "out"
+	RecordConnect  = "connect"
+	RecordLinkDead = "link-dead"
+	RecordCopyover = "copyover"
+)
+
+// recorder writes one recording. It is shared by the goroutines that read
+// from and write to the player.
+type recorder struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	path string
+}
+
+// startRecording creates a new recording for a mob
+func startRecording(mob *Mob, reason string) (*recorder, error) {
+	if err := os.MkdirAll(Config.RecordingsDir, 0700); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%d-%s.jsonl", now.Format("20060102-150405"), mob.UserID, strings.ToLower(mob.Name))
+	rec, err := openRecording(filepath.Join(Config.RecordingsDir, name))
+	if err != nil {
+		return nil, err
+	}
+	rec.record(&RecordEntry{Event: RecordStart, Name: mob.Name, UserID: mob.UserID, Reason: reason})
+	return rec, nil
+}
+
+// openRecording opens a recording to add to it, as after a copyover
+func openRecording(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file, enc: json.NewEncoder(file), path: path}, nil
+}
+
+func (r *recorder) record(entry *RecordEntry) {
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := r.enc.Encode(entry); err != nil {
+		log.Printf("recording to %s: %v", r.path, err)
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (r *recorder) close(reason string) {
+	r.record(&RecordEntry{Event: RecordStop, Reason: reason})
+	r.Lock()
+	defer r.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// activeRecorder gives the player's recorder, or nil if they are not
+// being recorded
+func (p *Player) activeRecorder() *recorder {
+	p.outgoingNotEmpty.L.Lock()
+	defer p.outgoingNotEmpty.L.Unlock()
+	return p.recording
+}
+
+// note records an event if the player is being recorded
+func (p *Player) note(entry *RecordEntry) {
+	if rec := p.activeRecorder(); rec != nil {
+		rec.record(entry)
+	}
+}
+
+// handOffRecording closes the player's recording for a copyover and gives
+// its path, so the next process can carry on with it
+func (p *Player) handOffRecording() string {
+	p.outgoingNotEmpty.L.Lock()
+	rec := p.recording
+	p.recording = nil
+	p.outgoingNotEmpty.L.Unlock()
+	if rec == nil {
+		return ""
+	}
+	rec.record(&RecordEntry{Event: RecordCopyover})
+	rec.Lock()
+	defer rec.Unlock()
+	if rec.file != nil {
+		rec.file.Close()
+		rec.file = nil
+	}
+	return rec.path
+}
+
+// resumeRecording carries on with a recording after a copyover
+func (p *Player) resumeRecording(path string, forced bool) {
+	p.recordForced = forced
+	if path == "" {
+		return
+	}
+	rec, err := openRecording(path)
+	if err != nil {
+		log.Printf("copyover: reopening recording: %v", err)
+		return
+	}
+	p.outgoingNotEmpty.L.Lock()
+	p.recording = rec
+	p.outgoingNotEmpty.L.Unlock()
+}
+
+// StartRecording starts recording a mob's player if they are not already
+func (mob *Mob) StartRecording(reason string) error {
+	player := mob.Player
+	if player.activeRecorder() != nil {
+		return nil
+	}
+	rec, err := startRecording(mob, reason)
+	if err != nil {
+		return err
+	}
+	player.outgoingNotEmpty.L.Lock()
+	player.recording = rec
+	var client *ClientSettings
+	if player.conn != nil {
+		settings := player.conn.client
+		client = &settings
+	}
+	player.outgoingNotEmpty.L.Unlock()
+	if client != nil {
+		rec.record(&RecordEntry{Event: RecordConnect, Client: client})
+	}
+	log.Printf("user %d (%s) is being recorded to %s", mob.UserID, mob.Name, rec.path)
+	return nil
+}
+
+// StopRecording stops recording a mob's player
+func (mob *Mob) StopRecording(reason string) {
+	player := mob.Player
+	player.outgoingNotEmpty.L.Lock()
+	rec := player.recording
+	player.recording = nil
+	player.outgoingNotEmpty.L.Unlock()
+	if rec != nil {
+		rec.close(reason)
+	}
+}
+
+// setRecording starts or stops recording a mob to match what the player
+// and administrators asked for
+func (mob *Mob) setRecording(wanted bool, reason string) {
+	if wanted {
+		if err := mob.StartRecording(reason); err != nil {
+			log.Printf("recording user %d (%s): %v", mob.UserID, mob.Name, err)
+			return
+		}
+		mob.Send(MsgEnvironment, "This session is being recorded.\n")
+	} else if mob.Player.activeRecorder() != nil {
+		mob.StopRecording(reason)
+		mob.Send(MsgEnvironment, "This session is no longer being recorded.\n")
+	}
+}
+
+// recordJoin notes a new connection in the recording, and starts one if
+// the user asked for it
+func (mob *Mob) recordJoin(user *User) {
+	player := mob.Player
+	if rec := player.activeRecorder(); rec != nil {
+		player.outgoingNotEmpty.L.Lock()
+		client := player.conn.client
+		player.outgoingNotEmpty.L.Unlock()
+		rec.record(&RecordEntry{Event: RecordConnect, Client: &client})
+	}
+	switch {
+	case user != nil && user.RecordForced:
+		player.recordForced = true
+		mob.setRecording(true, "administrator")
+	case user != nil && user.RecordSessions:
+		mob.setRecording(true, "player")
+	case player.activeRecorder() != nil:
+		mob.Send(MsgEnvironment, "This session is being recorded.\n")
+	}
+}
+
+// recordUser updates the recording of a signed-in user's mob after their
+// settings change
+func recordUser(q Queue, user *User) {
+	q.Schedule(func(state *State) {
+		for _, mob := range state.Mobs {
+			if mob.UserID != user.ID || mob.Player == nil {
+				continue
+			}
+			mob.Player.recordForced = user.RecordForced
+			reason := "player"
+			if user.RecordForced {
+				reason = "administrator"
+			}
+			mob.setRecording(user.RecordSessions || user.RecordForced, reason)
+		}
+	}, 0)
+}
+
+func CmdRecord(state *State, mob *Mob, cmd string) time.Duration {
+	player := mob.Player
+	if player == nil {
+		return 0
+	}
+	recording := player.activeRecorder() != nil
+	switch strings.ToLower(strings.TrimSpace(cmd)) {
+	case "":
+		switch {
+		case player.recordForced:
+			mob.Send(MsgEnvironment, "Your sessions are being recorded at the request of an administrator.\n")
+		case recording:
+			mob.Send(MsgEnvironment, "Your sessions are being recorded. Type 'record off' to stop.\n")
+		default:
+			mob.Send(MsgEnvironment, "Your sessions are not being recorded. Type 'record on' to start.\n")
+		}
+		return 0
+	case "on":
+		if recording {
+			mob.Send(MsgEnvironment, "Your sessions are already being recorded.\n")
+			return 0
+		}
+		mob.setRecording(true, "player")
+		saveRecordSessions(state, mob, true)
+	case "off":
+		if player.recordForced {
+			mob.Send(MsgError, "An administrator has asked for your sessions to be recorded.\n")
+			return 0
+		}
+		if !recording {
+			mob.Send(MsgEnvironment, "Your sessions are not being recorded.\n")
+			return 0
+		}
+		mob.setRecording(false, "player")
+		saveRecordSessions(state, mob, false)
+	default:
+		mob.Send(MsgError, "Usage: record [on|off]\n")
+	}
+	return 0
+}
+
+// saveRecordSessions remembers a signed-in player's choice for next time.
+// Guests are only recorded until they leave.
+func saveRecordSessions(state *State, mob *Mob, record bool) {
+	if mob.UserID == 0 {
+		return
+	}
+	db, q, userID := state.DB, state.Events, mob.UserID
+	go func() {
+		if _, err := db.Exec(`UPDATE users SET record_sessions = ?, modified_at = ? WHERE id = ?`, record, time.Now(), userID); err != nil {
+			log.Printf("saving record setting for user %d: %v", userID, err)
+			q.Schedule(func(state *State) {
+				mob.Send(MsgError, "Your recording setting could not be saved for next time.\n")
+			}, 0)
+		}
+	}()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/martini-contrib/render"
+	"github.com/russross/gruffles/world"
+)
+
+// ReloadRequest names an area to reload into the running game. Source is
+// "file" to read it from the areas directory or "db" to read it from the
+// database.
+type ReloadRequest struct {
+	Area   string
+	Source string
+}
+
+// ReloadReport describes what changed when an area was reloaded. Rooms
+// are listed by vnum.
+type ReloadReport struct {
+	Area         string
+	New          bool
+	RoomsAdded   []int
+	RoomsChanged []int
+	RoomsRemoved []int
+	Mobiles      int
+	Objects      int
+	Resets       int
+	MobsMoved    int
+	MobsRecalled int
+	Problems     []string
+}
+
+// ReloadArea replaces an area in the running world with a new version
+// of it. Everything is validated first, including exits from other areas
+// into this one, and nothing changes if there is a problem. Mobs in the
+// area are moved to the room with the same vnum in the new version, or
+// to the recall room if their room is gone. This must run in the event
+// loop.
+func ReloadArea(state *State, file *world.AreaFile) *ReloadReport {
+	report := &ReloadReport{
+		Area:         file.Name,
+		RoomsAdded:   []int{},
+		RoomsChanged: []int{},
+		RoomsRemoved: []int{},
+		Problems:     []string{},
+	}
+
+	// validate the new version along with all the other areas
+	index := -1
+	var paths []string
+	var files []*world.AreaFile
+	for i, elt := range liveAreaFiles(state.Areas) {
+		if elt.Name == file.Name {
+			index = i
+			continue
+		}
+		paths = append(paths, "area "+elt.Name)
+		files = append(files, elt)
+	}
+	paths = append(paths, "area "+file.Name)
+	files = append(files, file)
+	if problems := world.ValidateAreaFiles(paths, files); len(problems) > 0 {
+		report.Problems = problems
+		return report
+	}
+
+	// build the new world without touching the old one
+	area := file.Area()
+	areas := append([]*world.Area{}, state.Areas...)
+	var old *world.Area
+	if index < 0 {
+		report.New = true
+		areas = append(areas, area)
+		old = new(world.Area)
+	} else {
+		old = areas[index]
+		areas[index] = area
+	}
+	rooms, vnums, err := indexRooms(areas)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+	if vnums[RecallLocation] == nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("recall room %d would be missing", RecallLocation))
+		return report
+	}
+
+	// report the differences
+	oldRooms := make(map[int]*world.Room)
+	for _, room := range old.Rooms {
+		oldRooms[room.Vnum] = room
+	}
+	for _, room := range area.Rooms {
+		if prev, present := oldRooms[room.Vnum]; !present {
+			report.RoomsAdded = append(report.RoomsAdded, room.Vnum)
+		} else if !reflect.DeepEqual(prev, room) {
+			report.RoomsChanged = append(report.RoomsChanged, room.Vnum)
+		}
+	}
+	for _, room := range old.Rooms {
+		if vnums[room.Vnum] == nil {
+			report.RoomsRemoved = append(report.RoomsRemoved, room.Vnum)
+		}
+	}
+	report.Mobiles = len(area.Mobiles)
+	report.Objects = len(area.Objects)
+	report.Resets = len(area.Resets)
+
+	// swap it in. Doors in other areas refer to rooms by ID, so they link
+	// to the new rooms as soon as the room index is replaced.
+	state.Areas, state.Rooms, state.RoomVnums = areas, rooms, vnums
+	delete(state.Changed, old)
+	recall := state.RoomByVnum(RecallLocation)
+	replaced := make(map[*world.Room]bool)
+	for _, room := range old.Rooms {
+		replaced[room] = true
+	}
+	relocate := func(room *world.Room) (*world.Room, bool) {
+		if !replaced[room] {
+			return room, false
+		}
+		if elt := vnums[room.Vnum]; elt != nil {
+			return elt, false
+		}
+		return recall, true
+	}
+	for _, mob := range state.Mobs {
+		for len(mob.Visited) < len(rooms) {
+			mob.Visited = append(mob.Visited, rooms[len(mob.Visited)] != nil)
+		}
+		mob.StartLocation, _ = relocate(mob.StartLocation)
+		location, recalled := relocate(mob.Location)
+		if location == mob.Location {
+			continue
+		}
+		mob.Location = location
+		if recalled {
+			report.MobsRecalled++
+			mob.Send(MsgEnvironment, "The world shifts around you, and you find yourself somewhere else.\n")
+			mob.Send(MsgEnvironment, mob.Location.GetShortDescription())
+		} else {
+			report.MobsMoved++
+		}
+		mob.SendLocation(state)
+	}
+
+	return report
+}
+
+// liveAreaFiles converts the areas in the running world back to the text
+// format. Areas in the world use vnums for IDs.
+func liveAreaFiles(areas []*world.Area) []*world.AreaFile {
+	roomVnums := make(map[int]int)
+	mobileVnums := make(map[int]int)
+	objectVnums := make(map[int]int)
+	for _, area := range areas {
+		for _, elt := range area.Rooms {
+			roomVnums[elt.ID] = elt.Vnum
+		}
+		for _, elt := range area.Mobiles {
+			mobileVnums[elt.ID] = elt.Vnum
+		}
+		for _, elt := range area.Objects {
+			objectVnums[elt.ID] = elt.Vnum
+		}
+	}
+	var files []*world.AreaFile
+	for _, area := range areas {
+		files = append(files, world.NewAreaFile(area, roomVnums, mobileVnums, objectVnums))
+	}
+	return files
+}
+
+//
+// API handler for admins
+//
+
+func ReloadAreaHandler(w http.ResponseWriter, r *http.Request, tx *sql.Tx, req ReloadRequest, q Queue, render render.Render) {
+	if req.Source == "" {
+		req.Source = "file"
+	}
+	var file *world.AreaFile
+	switch req.Source {
+	case "file":
+		// a file that cannot be read only matters if it might be the area
+		// being reloaded
+		var broken []string
+		for _, path := range areaPaths("areas") {
+			elt, err := world.ReadAreaFile(path)
+			if err != nil {
+				broken = append(broken, err.Error())
+				continue
+			}
+			if elt.Name == req.Area {
+				file = elt
+				break
+			}
+		}
+		if file == nil && len(broken) > 0 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "area %q not found, and some area files could not be read:\n%s",
+				req.Area, strings.Join(broken, "\n"))
+			return
+		}
+		for _, msg := range broken {
+			log.Printf("reloading area %q: skipping %s", req.Area, msg)
+		}
+	case "db":
+		files, err := world.ReadAreasSQL(tx)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		for _, elt := range files {
+			if elt.Name == req.Area {
+				file = elt
+				break
+			}
+		}
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown source %q: must be file or db", req.Source)
+		return
+	}
+	if file == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "area %q not found in %s", req.Area, req.Source)
+		return
+	}
+
+	// the swap happens in the event loop, which may have stopped for a
+	// shutdown
+	done := make(chan *ReloadReport, 1)
+	q.Schedule(func(state *State) {
+		done <- ReloadArea(state, file)
+	}, 0)
+	var report *ReloadReport
+	select {
+	case report = <-done:
+	case <-eventsStopped:
+		loggedHTTPErrorf(w, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	case <-r.Context().Done():
+		log.Printf("reloading area %q: request canceled", req.Area)
+		return
+	}
+	if len(report.Problems) > 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unable to reload area %q:\n%s", req.Area, strings.Join(report.Problems, "\n"))
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
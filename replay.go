@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// the longest pause when playing a recording back in time
+const maxReplayPause = 5 * time.Second
+
+// cmdReplay plays back a session recording, either as a transcript or by
+// sending the recorded requests to a server and comparing the results
+func cmdReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := flags.Float64("speed", 0, "play back in time at this speed, such as 1 for real time, or 0 for no pauses")
+	showData := flags.Bool("data", false, "show data messages")
+	showMap := flags.Bool("map", false, "show maps")
+	server := flags.String("server", "", "send the recorded requests to this game websocket, such as ws://localhost:8080/server, and compare the text that comes back")
+	rate := flags.Float64("rate", 9, "requests per second to send with -server, to stay under the server's limit")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [options] <recording>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := readRecording(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(entries) == 0 {
+		log.Fatalf("%s is empty", flags.Arg(0))
+	}
+	if *server != "" {
+		if !replayAgainst(*server, entries, *rate) {
+			os.Exit(1)
+		}
+		return
+	}
+	printRecording(entries, *speed, *showData, *showMap)
+}
+
+func readRecording(path string) ([]*RecordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*RecordEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		entry := new(RecordEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// printRecording writes a transcript, with each line marked by the
+// seconds since the recording started
+func printRecording(entries []*RecordEntry, speed float64, showData, showMap bool) {
+	start := entries[0].Time
+	prev := start
+	for _, entry := range entries {
+		if speed > 0 {
+			pause := time.Duration(float64(entry.Time.Sub(prev)) / speed)
+			if pause > maxReplayPause {
+				pause = maxReplayPause
+			}
+			time.Sleep(pause)
+			prev = entry.Time
+		}
+		at := fmt.Sprintf("%9.3f  ", entry.Time.Sub(start).Seconds())
+
+		switch entry.Event {
+		case RecordStart:
+			fmt.Printf("%s-- recording %s (user %d), started %s at the request of the %s\n",
+				at, entry.Name, entry.UserID, entry.Time.Format("2006-01-02 15:04:05"), entry.Reason)
+		case RecordStop:
+			fmt.Printf("%s-- recording stopped: %s\n", at, entry.Reason)
+		case RecordConnect:
+			if entry.Client != nil {
+				fmt.Printf("%s-- connected: protocol %d %v\n", at, entry.Client.Protocol, entry.Client.Capabilities)
+			} else {
+				fmt.Printf("%s-- connected\n", at)
+			}
+		case RecordLinkDead:
+			fmt.Printf("%s-- connection lost\n", at)
+		case RecordCopyover:
+			fmt.Printf("%s-- copyover\n", at)
+		case RecordIn:
+			fmt.Printf("%s> %s\n", at, describeRequest(entry.Request))
+		case RecordOut:
+			for _, msg := range entry.Msgs {
+				var text string
+				switch {
+				case msg.Type == MsgMap && !showMap:
+				case msg.Message != "":
+					text = fmt.Sprintf("[%s] %s", msg.Type, strings.TrimRight(msg.Message, "\n"))
+				case msg.Data != nil && showData:
+					raw, _ := json.Marshal(msg.Data)
+					text = fmt.Sprintf("[%s] %s", msg.Type, raw)
+				}
+				if text != "" {
+					fmt.Println(at + strings.Replace(text, "\n", "\n"+strings.Repeat(" ", len(at)), -1))
+				}
+			}
+		}
+	}
+}
+
+func describeRequest(req *Request) string {
+	if req == nil {
+		return ""
+	}
+	switch req.Type {
+	case "cmd":
+		return req.Command
+	case "hello":
+		return fmt.Sprintf("[hello protocol %d %v]", req.Protocol, req.Capabilities)
+	case "resize":
+		return fmt.Sprintf("[resize %dx%d]", req.Width, req.Height)
+	case "settings":
+		return fmt.Sprintf("[settings %v]", req.Settings)
+	}
+	return "[" + req.Type + "]"
+}
+
+// replayStep is a request from a recording and the text the player got
+// back before their next request. A player who types ahead can send more
+// requests before anything comes back, and those are grouped together.
+type replayStep struct {
+	requests []*Request
+	expected []Msg
+	answered bool
+
+	// set when something besides the requests, like a reconnection, could
+	// explain the output
+	skip bool
+}
+
+// compared reports whether a message counts when comparing output. Maps
+// depend on the size of the client's window, and data messages carry
+// things like times.
+func compared(msg *Msg) bool {
+	return msg.Message != "" && msg.Type != MsgMap
+}
+
+// replayAgainst sends the requests in a recording to a server as a guest
+// and compares the text that comes back to what was recorded. Each
+// request is followed by a ping, and the pong marks the end of its
+// output.
+func replayAgainst(url string, entries []*RecordEntry, rate float64) bool {
+	SetupCommands()
+	var steps []*replayStep
+	var step *replayStep
+	for _, entry := range entries {
+		switch entry.Event {
+		case RecordIn:
+			req := entry.Request
+			if req == nil || req.Type == "ping" {
+				continue
+			}
+			if cmd, _ := ParseCommand(req.Command); req.Type == "cmd" && cmd != nil && cmd.Command == "record" {
+				// replaying this would start a recording
+				step = nil
+				continue
+			}
+			if step != nil && !step.answered {
+				step.requests = append(step.requests, req)
+				continue
+			}
+			step = &replayStep{requests: []*Request{req}}
+			steps = append(steps, step)
+		case RecordOut:
+			if step == nil {
+				continue
+			}
+			step.answered = true
+			for _, msg := range entry.Msgs {
+				if compared(&msg) {
+					step.expected = append(step.expected, msg)
+				}
+			}
+		case RecordConnect, RecordLinkDead, RecordCopyover:
+			if step != nil {
+				step.skip = true
+			}
+		}
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		log.Fatalf("connecting to %s: %v", url, err)
+	}
+	defer conn.Close()
+	incoming := make(chan Msg, maxPlayerOutgoingQueueLength)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			var batch []Msg
+			if strings.HasPrefix(string(raw), "[") {
+				err = json.Unmarshal(raw, &batch)
+			} else {
+				var msg Msg
+				err = json.Unmarshal(raw, &msg)
+				batch = append(batch, msg)
+			}
+			if err != nil {
+				errs <- fmt.Errorf("bad message from server %q: %v", raw, err)
+				return
+			}
+			for _, msg := range batch {
+				incoming <- msg
+			}
+		}
+	}()
+
+	// output up to the pong with the given id
+	collect := func(id string) []Msg {
+		var got []Msg
+		for {
+			select {
+			case msg := <-incoming:
+				if msg.Type == MsgPong {
+					if data, ok := msg.Data.(map[string]interface{}); ok && data["id"] == id {
+						return got
+					}
+					continue
+				}
+				if compared(&msg) {
+					got = append(got, msg)
+				}
+			case err := <-errs:
+				log.Fatalf("reading from %s: %v", url, err)
+			case <-time.After(10 * time.Second):
+				log.Fatalf("timed out waiting for %s", url)
+			}
+		}
+	}
+	send := func(req interface{}) {
+		if err := conn.WriteJSON(req); err != nil {
+			log.Fatalf("writing to %s: %v", url, err)
+		}
+	}
+
+	// skip what the server says when a player joins
+	send(Request{Type: "ping", ID: "joined"})
+	collect("joined")
+
+	matched, differed, skipped, count := 0, 0, 0, 0
+	for i, step := range steps {
+		started := time.Now()
+		id := fmt.Sprintf("replay %d", i)
+		var described []string
+		for _, req := range step.requests {
+			send(req)
+			described = append(described, describeRequest(req))
+		}
+		send(Request{Type: "ping", ID: id})
+		got := collect(id)
+		count += len(step.requests)
+
+		if step.skip {
+			skipped += len(step.requests)
+		} else if sameText(step.expected, got, len(step.requests) > 1) {
+			matched += len(step.requests)
+		} else {
+			differed += len(step.requests)
+			fmt.Printf("request %d: %s\n", count, strings.Join(described, "; "))
+			for _, msg := range step.expected {
+				fmt.Printf("  - [%s] %s\n", msg.Type, strings.Replace(strings.TrimRight(msg.Message, "\n"), "\n", "\n    ", -1))
+			}
+			for _, msg := range got {
+				fmt.Printf("  + [%s] %s\n", msg.Type, strings.Replace(strings.TrimRight(msg.Message, "\n"), "\n", "\n    ", -1))
+			}
+		}
+
+		// stay under the server's command rate limit
+		time.Sleep(time.Duration(float64(len(step.requests)+1)*float64(time.Second)/rate) - time.Since(started))
+	}
+	fmt.Printf("%d requests: %d matched, %d differed, %d skipped\n", count, matched, differed, skipped)
+	return differed == 0
+}
+
+// sameText compares the text of two lists of messages. When requests
+// were sent together their replies can interleave, so then the order does
+// not matter.
+func sameText(a, b []Msg, anyOrder bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	text := func(msgs []Msg) []string {
+		var out []string
+		for _, msg := range msgs {
+			out = append(out, string(msg.Type)+" "+msg.Message)
+		}
+		if anyOrder {
+			sort.Strings(out)
+		}
+		return out
+	}
+	x, y := text(a), text(b)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
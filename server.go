@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Server modes, set by the mode field in the config file:
+//
+//	autocert:   https with certificates from Let's Encrypt (the default)
+//	tls:        https with the certificate in certFile and keyFile
+//	selfsigned: https with a certificate generated at startup
+//	http:       plain http, for local development only
+//
+// In the https modes, a plain http listener on httpAddress redirects to
+// https and answers Let's Encrypt challenges. Set httpAddress to "" to
+// turn it off.
+const (
+	ModeAutocert   = "autocert"
+	ModeTLS        = "tls"
+	ModeSelfSigned = "selfsigned"
+	ModeHTTP       = "http"
+)
+
+var (
+	// listening sockets by address, so copyover can hand them on
+	activeListeners = make(map[string]*net.TCPListener)
+
+	// listening sockets handed over by the previous process
+	inheritedListeners = make(map[string]*os.File)
+)
+
+func listen(addr string) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if file := inheritedListeners[addr]; file != nil {
+		delete(inheritedListeners, addr)
+		listener, err = net.FileListener(file)
+		file.Close()
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %v", addr, err)
+	}
+	if tcp, ok := listener.(*net.TCPListener); ok {
+		activeListeners[addr] = tcp
+	}
+	return listener, nil
+}
+
+// StartServers starts the servers for the configured mode in the
+// background. They are returned so they can be shut down.
+func StartServers(handler http.Handler) ([]*http.Server, error) {
+	if Config.Mode == ModeHTTP {
+		server := &http.Server{Addr: Config.HTTPAddress, Handler: handler}
+		listener, err := listen(server.Addr)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("accepting http connections on %s", Config.HTTPAddress)
+		go serve(server, func() error { return server.Serve(listener) })
+		return []*http.Server{server}, nil
+	}
+
+	server := &http.Server{
+		Addr:    Config.HTTPSAddress,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			PreferServerCipherSuites: true,
+			MinVersion:               tls.VersionTLS10,
+		},
+	}
+
+	// redirect handler for the plain http listener
+	var redirect http.Handler = http.HandlerFunc(redirectHTTPS)
+
+	switch Config.Mode {
+	case ModeAutocert:
+		lem := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(Config.LetsEncryptCache),
+			HostPolicy: autocert.HostWhitelist(Config.Hostname),
+			Email:      Config.LetsEncryptEmail,
+		}
+		server.TLSConfig.GetCertificate = lem.GetCertificate
+		redirect = lem.HTTPHandler(redirect)
+
+	case ModeTLS:
+		cert, err := tls.LoadX509KeyPair(Config.CertFile, Config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate: %v", err)
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+
+	case ModeSelfSigned:
+		cert, err := selfSignedCertificate(Config.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("generating certificate: %v", err)
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+
+	default:
+		return nil, fmt.Errorf("unknown server mode %q", Config.Mode)
+	}
+
+	listener, err := listen(server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	servers := []*http.Server{server}
+	if Config.HTTPAddress != "" {
+		plain := &http.Server{Addr: Config.HTTPAddress, Handler: redirect}
+		plainListener, err := listen(plain.Addr)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		log.Printf("redirecting http connections on %s", Config.HTTPAddress)
+		go serve(plain, func() error { return plain.Serve(plainListener) })
+		servers = append(servers, plain)
+	}
+
+	log.Printf("accepting https connections on %s", Config.HTTPSAddress)
+	go serve(server, func() error { return server.ServeTLS(listener, "", "") })
+	return servers, nil
+}
+
+func serve(server *http.Server, run func() error) {
+	if err := run(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("server on %s: %v", server.Addr, err)
+	}
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(Config.HTTPSAddress); err == nil && port != "" && port != "443" && port != "https" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// selfSignedCertificate makes a certificate for development. Browsers
+// will warn about it.
+func selfSignedCertificate(hostname string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := crand.Int(crand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname},
+		DNSNames:              []string{hostname},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
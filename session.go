@@ -1,20 +1,16 @@
 package main
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
 	"database/sql"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
-	"golang.org/x/crypto/pbkdf2"
-
 	"github.com/gorilla/securecookie"
 	"github.com/martini-contrib/render"
 	"github.com/russross/meddler"
@@ -28,26 +24,65 @@ type Session struct {
 	path         string
 }
 
+// clientAddress finds the address of the client. X-Forwarded-For and
+// X-Real-IP are only believed when the request comes from a trusted
+// proxy, and then the client is the last address in the chain that is
+// not one of the proxies.
+func clientAddress(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("finding client address: %v", err)
+	}
+	proxies, err := parseAddressList(Config.TrustedProxies)
+	if err != nil {
+		return "", fmt.Errorf("finding client address: %v", err)
+	}
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		for _, elt := range proxies {
+			if ip != nil && elt.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if !trusted(host) {
+		return host, nil
+	}
+
+	var chain []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, elt := range strings.Split(header, ",") {
+			chain = append(chain, strings.TrimSpace(elt))
+		}
+	}
+	if len(chain) == 0 {
+		chain = []string{strings.TrimSpace(r.Header.Get("X-Real-IP"))}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			// the proxy passed on something that is not an address
+			break
+		}
+		if !trusted(chain[i]) || i == 0 {
+			return net.ParseIP(chain[i]).String(), nil
+		}
+	}
+	return host, nil
+}
+
 func NewSession(r *http.Request, userID int64) (*Session, error) {
 	now := time.Now()
-	client := r.Header.Get("X-Forwarded-For")
-	if client == "" {
-		client = r.Header.Get("X-Real-IP")
-	}
-	if client == "" {
-		client = r.RemoteAddr
-		host, _, err := net.SplitHostPort(client)
-		if err != nil {
-			return nil, fmt.Errorf("finding client address: %v", err)
-		}
-		client = host
+	client, err := clientAddress(r)
+	if err != nil {
+		return nil, err
 	}
 	return &Session{
 		UserID:       userID,
 		SignedInFrom: client,
 		SignedInAt:   now,
 		ExpiresAt:    now.Add(time.Duration(Config.SessionSeconds) * time.Second),
-		path:         "/v1/",
+		path:         "/",
 	}, nil
 }
 
@@ -96,7 +131,7 @@ func (session *Session) Save(w http.ResponseWriter) {
 		Path:    session.path,
 		Expires: session.ExpiresAt,
 		MaxAge:  int(time.Until(session.ExpiresAt).Seconds()),
-		Secure:  true,
+		Secure:  Config.Mode != ModeHTTP,
 	}
 	http.SetCookie(w, cookie)
 }
@@ -109,79 +144,131 @@ func (session *Session) Delete(w http.ResponseWriter) {
 		Path:    session.path,
 		Expires: epoch,
 		MaxAge:  -1,
-		Secure:  true,
+		Secure:  Config.Mode != ModeHTTP,
 	}
 	http.SetCookie(w, cookie)
 }
 
-func CreateSession(w http.ResponseWriter, r *http.Request, tx *sql.Tx, user User, render render.Render) {
+func CreateSession(w http.ResponseWriter, r *http.Request, db *sql.DB, tx *sql.Tx, user User, render render.Render) {
+	client, err := clientAddress(r)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	realUser, failure := signIn(db, tx, user.Username, user.Password, client)
+	user.Password = ""
+	if failure != nil {
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(failure.RetryAfter))
+		}
+		loggedHTTPErrorf(w, failure.Status, "%s", failure.Message)
+		return
+	}
+
+	// form a session
+	session, err := NewSession(r, realUser.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "session error: %v", err)
+		return
+	}
+	session.Save(w)
+	render.JSON(http.StatusOK, session)
+}
+
+// signInFailure is a reason a sign-in was refused, with the HTTP status
+// that reports it
+type signInFailure struct {
+	Status     int
+	Message    string
+	RetryAfter int
+}
+
+// signIn checks a username and password from client, subject to the login
+// limits, and records the attempt. On success it notes the time and
+// upgrades the password hash if necessary.
+func signIn(db *sql.DB, tx meddler.DB, username, password, client string) (*User, *signInFailure) {
 	now := time.Now()
+	fail := func(status int, format string, params ...interface{}) (*User, *signInFailure) {
+		return nil, &signInFailure{Status: status, Message: fmt.Sprintf(format, params...)}
+	}
 
 	// username: letters, digits, underscores, hyphens, max 32 characters
-	if !utf8.ValidString(user.Username) {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "username must be valid utf-8")
-		return
+	if !utf8.ValidString(username) {
+		return fail(http.StatusBadRequest, "username must be valid utf-8")
 	}
-	user.Username = strings.TrimSpace(user.Username)
-	user.Username = strings.ToLower(user.Username)
-	if len(user.Username) < 1 || len(user.Username) > 32 {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "username must be between 1 and 32 characters")
-		return
+	username = strings.TrimSpace(username)
+	username = strings.ToLower(username)
+	if len(username) < 1 || len(username) > 32 {
+		return fail(http.StatusBadRequest, "username must be between 1 and 32 characters")
 	}
-	for _, ch := range user.Username {
+	for _, ch := range username {
 		if ch <= ' ' || ch > '~' {
-			loggedHTTPErrorf(w, http.StatusBadRequest, "username can contain only printable ASCII characters")
-			return
+			return fail(http.StatusBadRequest, "username can contain only printable ASCII characters")
 		}
 	}
 
 	// password must be between 12 and 256 characters
-	if len(user.Password) < 12 || len(user.Password) > 256 {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "password must be between 12 and 256 characters")
-		return
+	if len(password) < 12 || len(password) > 256 {
+		return fail(http.StatusBadRequest, "password must be between 12 and 256 characters")
+	}
+
+	// refuse usernames and addresses with too many recent failures
+	attempt := &LoginAttempt{Username: username, Client: client}
+	defer recordLoginAttempt(db, attempt)
+	if wait := loginLimits.Wait(username, client); wait > 0 {
+		attempt.Reason = "locked out"
+		seconds := int(wait/time.Second) + 1
+		return nil, &signInFailure{
+			Status:     http.StatusTooManyRequests,
+			Message:    fmt.Sprintf("too many failed attempts: try again in %d seconds", seconds),
+			RetryAfter: seconds,
+		}
 	}
 
-	realUser := new(User)
-	if err := meddler.QueryRow(tx, realUser, `SELECT * FROM users WHERE username = ?`, user.Username); err != nil {
+	user := new(User)
+	if err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE username = ?`, username); err != nil {
 		if err == sql.ErrNoRows {
+			attempt.Reason = "no such user"
+			loginLimits.Failure(username, client)
 			time.Sleep(time.Duration(500+rand.Intn(1000)) * time.Millisecond)
-			loggedHTTPErrorf(w, http.StatusUnauthorized, "no such user")
-			return
+			return fail(http.StatusUnauthorized, "no such user")
 		}
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
+		attempt.Reason = "db error"
+		return fail(http.StatusInternalServerError, "db error: %v", err)
 	}
-	if realUser.Scheme != "PBKDF2-HMAC-SHA256:64k" {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "unknown hash scheme")
-		return
+	attempt.UserID = user.ID
+	ok, err := checkPassword(user, password)
+	if err == errHashBusy {
+		attempt.Reason = "server busy"
+		return nil, &signInFailure{Status: http.StatusServiceUnavailable, Message: err.Error(), RetryAfter: 5}
+	} else if err != nil {
+		attempt.Reason = "hash error"
+		return fail(http.StatusInternalServerError, "%v", err)
 	}
-	start := time.Now()
-	hash := pbkdf2.Key(
-		[]byte(user.Password),
-		realUser.Salt,
-		64*1024,
-		32,
-		sha256.New)
-	log.Printf("hash took %v", time.Since(start))
-	user.Password = ""
-	if subtle.ConstantTimeCompare(hash, realUser.PasswordHash) != 1 {
+	if !ok {
+		attempt.Reason = "wrong password"
+		loginLimits.Failure(username, client)
 		time.Sleep(time.Duration(500+rand.Intn(1000)) * time.Millisecond)
-		loggedHTTPErrorf(w, http.StatusUnauthorized, "wrong password")
-		return
+		return fail(http.StatusUnauthorized, "wrong password")
+	}
+	loginLimits.Success(username)
+	if user.Banned {
+		attempt.Reason = "banned"
+		return fail(http.StatusForbidden, "account is banned")
 	}
-	realUser.LastSignedInAt = now
+	attempt.Succeeded = true
+	user.LastSignedInAt = now
 
-	if err := meddler.Update(tx, "users", realUser); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
+	// upgrade to the current hash scheme while we have the password
+	if user.Scheme != currentHashScheme {
+		if err := setPassword(user, password); err != nil {
+			return fail(http.StatusInternalServerError, "%v", err)
+		}
+		user.ModifiedAt = now
 	}
 
-	// form a session
-	session, err := NewSession(r, realUser.ID)
-	if err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "session error: %v", err)
-		return
+	if err := meddler.Update(tx, "users", user); err != nil {
+		return fail(http.StatusInternalServerError, "db error: %v", err)
 	}
-	session.Save(w)
-	render.JSON(http.StatusOK, session)
+	return user, nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	saved := Config
+	defer func() { Config = saved }()
+	Config.TrustedProxies = "10.0.0.1, 192.168.0.0/16"
+
+	tests := []struct {
+		remote, forwarded, realIP string
+		client                    string
+	}{
+		// headers from clients that are not proxies are ignored
+		{"203.0.113.5:4000", "", "", "203.0.113.5"},
+		{"203.0.113.5:4000", "198.51.100.7", "", "203.0.113.5"},
+		{"203.0.113.5:4000", "", "198.51.100.7", "203.0.113.5"},
+
+		// a trusted proxy names the client
+		{"10.0.0.1:4000", "198.51.100.7", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "", "198.51.100.7", "198.51.100.7"},
+		{"192.168.3.4:4000", "198.51.100.7", "", "198.51.100.7"},
+
+		// only the last address that is not a proxy counts, so a client
+		// cannot choose its own address by sending the header itself
+		{"10.0.0.1:4000", "1.2.3.4, 198.51.100.7", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "1.2.3.4, 198.51.100.7, 192.168.1.1", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "192.168.1.2, 192.168.1.1", "", "192.168.1.2"},
+
+		// junk from a proxy falls back to the proxy itself
+		{"10.0.0.1:4000", "", "", "10.0.0.1"},
+		{"10.0.0.1:4000", "unknown", "", "10.0.0.1"},
+		{"10.0.0.1:4000", "198.51.100.7, unknown", "", "10.0.0.1"},
+
+		{"[2001:db8::1]:4000", "198.51.100.7", "", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "/v1/sessions", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		client, err := clientAddress(r)
+		if err != nil {
+			t.Errorf("%s with %q and %q: %v", test.remote, test.forwarded, test.realIP, err)
+		} else if client != test.client {
+			t.Errorf("%s with %q and %q gave %s, expected %s", test.remote, test.forwarded, test.realIP, client, test.client)
+		}
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	nets, err := parseAddressList(" 10.0.0.1 ,::1, 172.16.0.0/12,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 || nets[0].String() != "10.0.0.1/32" || nets[1].String() != "::1/128" || nets[2].String() != "172.16.0.0/12" {
+		t.Errorf("parsed as %v", nets)
+	}
+	for _, bad := range []string{"10.0.0", "localhost", "10.0.0.0/33"} {
+		if _, err := parseAddressList(bad); err == nil {
+			t.Errorf("%q gave no error", bad)
+		}
+	}
+}
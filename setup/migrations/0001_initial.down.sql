@@ -0,0 +1,10 @@
+DROP TABLE resets;
+DROP TABLE doors;
+DROP TABLE rooms;
+DROP TABLE objects;
+DROP TABLE mobiles;
+DROP TABLE helps;
+DROP TABLE areas;
+DROP TABLE sessions;
+DROP TABLE accounts;
+DROP TABLE users;
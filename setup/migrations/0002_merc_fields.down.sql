@@ -0,0 +1,89 @@
+CREATE TABLE old_mobiles (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    keywords                    TEXT NOT NULL,
+    short_description           TEXT NOT NULL,
+    long_description            TEXT NOT NULL,
+    description                 TEXT NOT NULL,
+    action_flags                INTEGER NOT NULL,
+    affected_flags              INTEGER NOT NULL,
+    alignment                   INTEGER NOT NULL,
+    level                       INTEGER NOT NULL,
+    hit_roll                    TEXT NOT NULL,
+    damage_roll                 TEXT NOT NULL,
+    dodge_roll                  TEXT NOT NULL,
+    absorb_roll                 TEXT NOT NULL,
+    fire_roll                   TEXT NOT NULL,
+    ice_roll                    TEXT NOT NULL,
+    poison_roll                 TEXT NOT NULL,
+    lightning_roll              TEXT NOT NULL,
+    gold                        INTEGER NOT NULL,
+    experience                  INTEGER NOT NULL,
+    pronouns                    TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CHECK (alignment >= -1000 AND alignment <= 1000),
+    CHECK (level >= 0 AND level <= 100),
+    CHECK (pronouns IN ('he', 'she', 'it', 'they'))
+);
+
+INSERT INTO old_mobiles (id, area_id, keywords, short_description, long_description, description,
+        action_flags, affected_flags, alignment, level,
+        hit_roll, damage_roll, dodge_roll, absorb_roll, fire_roll, ice_roll, poison_roll, lightning_roll,
+        gold, experience, pronouns)
+    SELECT id, area_id, keywords, short_description, long_description, description,
+        action_flags, affected_flags, alignment, level,
+        hit_roll, damage_roll, dodge_roll, absorb_roll, fire_roll, ice_roll, poison_roll, lightning_roll,
+        gold, experience, pronouns
+    FROM mobiles;
+DROP TABLE mobiles;
+ALTER TABLE old_mobiles RENAME TO mobiles;
+
+CREATE TABLE old_objects (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    keywords                    TEXT NOT NULL,
+    short_description           TEXT NOT NULL,
+    long_description            TEXT NOT NULL,
+    item_type                   INTEGER NOT NULL,
+    extra_flags                 INTEGER NOT NULL,
+    wear_flags                  INTEGER NOT NULL,
+    value_0                     INTEGER NOT NULL,
+    value_1                     INTEGER NOT NULL,
+    value_2                     INTEGER NOT NULL,
+    value_3                     INTEGER NOT NULL,
+    weight                      INTEGER NOT NULL,
+    cost                        INTEGER NOT NULL,
+    extras                      TEXT NOT NULL,
+    applies                     TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO old_objects (id, area_id, keywords, short_description, long_description,
+        item_type, extra_flags, wear_flags, value_0, value_1, value_2, value_3,
+        weight, cost, extras, applies)
+    SELECT id, area_id, keywords, short_description, long_description,
+        item_type, extra_flags, wear_flags, value_0, value_1, value_2, value_3,
+        weight, cost, extras, applies
+    FROM objects;
+DROP TABLE objects;
+ALTER TABLE old_objects RENAME TO objects;
+
+CREATE TABLE old_rooms (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    name                        TEXT NOT NULL,
+    description                 TEXT NOT NULL,
+    flags                       INTEGER NOT NULL,
+    terrain                     INTEGER NOT NULL,
+    extras                      TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO old_rooms (id, area_id, name, description, flags, terrain, extras)
+    SELECT id, area_id, name, description, flags, terrain, extras
+    FROM rooms;
+DROP TABLE rooms;
+ALTER TABLE old_rooms RENAME TO rooms;
@@ -0,0 +1,102 @@
+-- SQLite cannot add UNIQUE or NOT NULL columns without a default, so these
+-- tables are rebuilt with the new columns
+CREATE TABLE new_mobiles (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    vnum                        INTEGER UNIQUE,
+    keywords                    TEXT NOT NULL,
+    short_description           TEXT NOT NULL,
+    long_description            TEXT NOT NULL,
+    description                 TEXT NOT NULL,
+    action_flags                INTEGER NOT NULL,
+    affected_flags              INTEGER NOT NULL,
+    alignment                   INTEGER NOT NULL,
+    level                       INTEGER NOT NULL,
+    hit_bonus                   INTEGER NOT NULL,
+    armor                       INTEGER NOT NULL,
+    hit_roll                    TEXT NOT NULL,
+    damage_roll                 TEXT NOT NULL,
+    dodge_roll                  TEXT NOT NULL,
+    absorb_roll                 TEXT NOT NULL,
+    fire_roll                   TEXT NOT NULL,
+    ice_roll                    TEXT NOT NULL,
+    poison_roll                 TEXT NOT NULL,
+    lightning_roll              TEXT NOT NULL,
+    gold                        INTEGER NOT NULL,
+    experience                  INTEGER NOT NULL,
+    pronouns                    TEXT NOT NULL,
+    start_position              TEXT NOT NULL,
+    default_position            TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CHECK (alignment >= -1000 AND alignment <= 1000),
+    CHECK (level >= 0 AND level <= 100),
+    CHECK (pronouns IN ('he', 'she', 'it', 'they')),
+    CHECK (start_position IN ('dead', 'mortal', 'incapacitated', 'stunned', 'sleeping', 'resting', 'sitting', 'fighting', 'standing')),
+    CHECK (default_position IN ('dead', 'mortal', 'incapacitated', 'stunned', 'sleeping', 'resting', 'sitting', 'fighting', 'standing'))
+);
+
+INSERT INTO new_mobiles (id, area_id, keywords, short_description, long_description, description,
+        action_flags, affected_flags, alignment, level, hit_bonus, armor,
+        hit_roll, damage_roll, dodge_roll, absorb_roll, fire_roll, ice_roll, poison_roll, lightning_roll,
+        gold, experience, pronouns, start_position, default_position)
+    SELECT id, area_id, keywords, short_description, long_description, description,
+        action_flags, affected_flags, alignment, level, 0, 0,
+        hit_roll, damage_roll, dodge_roll, absorb_roll, fire_roll, ice_roll, poison_roll, lightning_roll,
+        gold, experience, pronouns, 'standing', 'standing'
+    FROM mobiles;
+DROP TABLE mobiles;
+ALTER TABLE new_mobiles RENAME TO mobiles;
+
+CREATE TABLE new_objects (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    vnum                        INTEGER UNIQUE,
+    keywords                    TEXT NOT NULL,
+    short_description           TEXT NOT NULL,
+    long_description            TEXT NOT NULL,
+    action_description          TEXT NOT NULL,
+    item_type                   INTEGER NOT NULL,
+    extra_flags                 INTEGER NOT NULL,
+    wear_flags                  INTEGER NOT NULL,
+    value_0                     INTEGER NOT NULL,
+    value_1                     INTEGER NOT NULL,
+    value_2                     INTEGER NOT NULL,
+    value_3                     INTEGER NOT NULL,
+    weight                      INTEGER NOT NULL,
+    cost                        INTEGER NOT NULL,
+    cost_per_day                INTEGER NOT NULL,
+    extras                      TEXT NOT NULL,
+    applies                     TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO new_objects (id, area_id, keywords, short_description, long_description, action_description,
+        item_type, extra_flags, wear_flags, value_0, value_1, value_2, value_3,
+        weight, cost, cost_per_day, extras, applies)
+    SELECT id, area_id, keywords, short_description, long_description, '',
+        item_type, extra_flags, wear_flags, value_0, value_1, value_2, value_3,
+        weight, cost, 0, extras, applies
+    FROM objects;
+DROP TABLE objects;
+ALTER TABLE new_objects RENAME TO objects;
+
+CREATE TABLE new_rooms (
+    id                          INTEGER PRIMARY KEY,
+    area_id                     INTEGER NOT NULL,
+    vnum                        INTEGER UNIQUE,
+    name                        TEXT NOT NULL,
+    description                 TEXT NOT NULL,
+    flags                       INTEGER NOT NULL,
+    terrain                     INTEGER NOT NULL,
+    extras                      TEXT NOT NULL,
+
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO new_rooms (id, area_id, name, description, flags, terrain, extras)
+    SELECT id, area_id, name, description, flags, terrain, extras
+    FROM rooms;
+DROP TABLE rooms;
+ALTER TABLE new_rooms RENAME TO rooms;
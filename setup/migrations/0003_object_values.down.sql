@@ -0,0 +1,2 @@
+ALTER TABLE objects DROP COLUMN value_5;
+ALTER TABLE objects DROP COLUMN value_4;
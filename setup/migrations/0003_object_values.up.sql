@@ -0,0 +1,2 @@
+ALTER TABLE objects ADD COLUMN value_4 INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE objects ADD COLUMN value_5 INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+DROP TABLE area_authors;
@@ -0,0 +1,8 @@
+CREATE TABLE area_authors (
+    area_id                     INTEGER NOT NULL,
+    user_id                     INTEGER NOT NULL,
+
+    PRIMARY KEY (area_id, user_id),
+    FOREIGN KEY (area_id) REFERENCES areas (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN banned BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE password_resets (
+    user_id                     INTEGER PRIMARY KEY,
+    token_hash                  BLOB NOT NULL,
+    expires_at                  DATETIME NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP INDEX login_attempts_attempted_at;
+DROP TABLE login_attempts;
@@ -0,0 +1,13 @@
+CREATE TABLE login_attempts (
+    id                          INTEGER PRIMARY KEY,
+    username                    TEXT NOT NULL,
+    user_id                     INTEGER,
+    client                      TEXT NOT NULL,
+    succeeded                   BOOLEAN NOT NULL,
+    reason                      TEXT NOT NULL,
+    attempted_at                DATETIME NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX login_attempts_attempted_at ON login_attempts (attempted_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN record_forced;
+ALTER TABLE users DROP COLUMN record_sessions;
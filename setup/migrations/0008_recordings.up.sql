@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN record_sessions BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN record_forced BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+CREATE TABLE characters (
+    user_id                     INTEGER PRIMARY KEY,
+    mob                         TEXT NOT NULL,
+    saved_at                    DATETIME NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,15 @@
+-- only the most recently saved character of each user is kept
+CREATE TABLE old_characters (
+    user_id                     INTEGER PRIMARY KEY,
+    mob                         TEXT NOT NULL,
+    saved_at                    DATETIME NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO old_characters (user_id, mob, saved_at)
+    SELECT user_id, mob, MAX(saved_at)
+    FROM characters
+    GROUP BY user_id;
+DROP TABLE characters;
+ALTER TABLE old_characters RENAME TO characters;
@@ -0,0 +1,17 @@
+-- a user can have several characters, told apart by name
+CREATE TABLE new_characters (
+    id                          INTEGER PRIMARY KEY,
+    user_id                     INTEGER NOT NULL,
+    name                        TEXT NOT NULL COLLATE NOCASE,
+    mob                         TEXT NOT NULL,
+    saved_at                    DATETIME NOT NULL,
+
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO new_characters (user_id, name, mob, saved_at)
+    SELECT user_id, json_extract(mob, '$.Name'), mob, saved_at
+    FROM characters;
+DROP TABLE characters;
+ALTER TABLE new_characters RENAME TO characters;
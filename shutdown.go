@@ -0,0 +1,308 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/russross/gruffles/world"
+)
+
+const (
+	// countdown for the shutdown and reboot commands with no time given
+	defaultShutdownDelay = 30 * time.Second
+
+	// countdown after SIGINT or SIGTERM. A second signal skips it.
+	signalShutdownDelay = 10 * time.Second
+
+	// how long to run leftover events, and to wait for players and API
+	// requests to finish, once the countdown is over
+	shutdownDrainTime = time.Second
+	shutdownWaitTime  = 5 * time.Second
+)
+
+// players are warned when the countdown reaches each of these
+var shutdownWarnings = []time.Duration{
+	5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second,
+	5 * time.Second, 3 * time.Second, 2 * time.Second, time.Second,
+}
+
+// Shutdown is a planned shutdown or reboot. A copyover is a reboot that
+// keeps players connected.
+type Shutdown struct {
+	Reboot   bool
+	Copyover bool
+	Reason   string
+	At       time.Time
+
+	// set when a copyover is underway
+	handoff *copyoverState
+}
+
+var (
+	// cleared when the server stops taking new players
+	acceptingPlayers int32 = 1
+
+	// one for each player connection still delivering messages
+	playerConnections sync.WaitGroup
+)
+
+func AcceptingPlayers() bool {
+	return atomic.LoadInt32(&acceptingPlayers) != 0
+}
+
+func (plan *Shutdown) announce(left time.Duration) string {
+	what := "shut down"
+	if plan.Reboot {
+		what = "reboot"
+	}
+	msg := fmt.Sprintf("The server will %s in %s", what, countdown(left))
+	if left <= 0 {
+		msg = fmt.Sprintf("The server will %s now", what)
+	}
+	if plan.Reason != "" {
+		msg += ": " + plan.Reason
+	}
+	if plan.Copyover {
+		msg += ". You will stay connected"
+	}
+	return msg + ".\n"
+}
+
+func countdown(d time.Duration) string {
+	n, unit := int((d+time.Second/2)/time.Second), "second"
+	if n >= 60 && n%60 == 0 {
+		n, unit = n/60, "minute"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Broadcast sends a message to every player
+func (state *State) Broadcast(msgType MsgType, msg string) {
+	for _, mob := range state.Mobs {
+		mob.Send(msgType, msg)
+	}
+}
+
+// ScheduleShutdown starts a countdown, replacing any countdown already
+// running. This must run in the event loop.
+func (state *State) ScheduleShutdown(plan *Shutdown, delay time.Duration) {
+	if state.Stopped != nil {
+		return
+	}
+	plan.At = time.Now().Add(delay)
+	state.Shutdown = plan
+	if delay <= 0 {
+		state.finishShutdown()
+		return
+	}
+	log.Printf("%s", strings.TrimSpace(plan.announce(delay)))
+	state.Broadcast(MsgError, plan.announce(delay))
+	for _, warning := range shutdownWarnings {
+		if warning >= delay {
+			continue
+		}
+		warning := warning
+		state.Events.Schedule(func(state *State) {
+			if state.Shutdown == plan && state.Stopped == nil {
+				state.Broadcast(MsgError, plan.announce(warning))
+			}
+		}, delay-warning)
+	}
+	state.Events.Schedule(func(state *State) {
+		if state.Shutdown == plan && state.Stopped == nil {
+			state.finishShutdown()
+		}
+	}, delay)
+}
+
+// CancelShutdown stops a countdown and reports if there was one
+func (state *State) CancelShutdown() bool {
+	if state.Shutdown == nil || state.Stopped != nil {
+		return false
+	}
+	state.Shutdown = nil
+	log.Printf("shutdown canceled")
+	state.Broadcast(MsgError, "The server will keep running after all.\n")
+	return true
+}
+
+// finishShutdown turns away new players, saves the world, and disconnects
+// everyone. The event loop stops after this.
+func (state *State) finishShutdown() {
+	plan := state.Shutdown
+	atomic.StoreInt32(&acceptingPlayers, 0)
+	log.Printf("%s", strings.TrimSpace(plan.announce(0)))
+	state.Broadcast(MsgError, plan.announce(0))
+
+	state.SaveWorld()
+
+	if plan.Copyover {
+		plan.handoff = state.prepareCopyover()
+		state.Stopped = plan
+		return
+	}
+
+	code, reason := websocket.CloseGoingAway, "server shutting down"
+	if plan.Reboot {
+		code, reason = websocket.CloseServiceRestart, "server rebooting"
+	}
+	for _, mob := range state.Mobs {
+		if mob.Player != nil {
+			mob.Player.Close(code, reason)
+		}
+	}
+	state.Stopped = plan
+}
+
+// SaveWorld saves every character in the game and the areas with unsaved
+// changes from builders
+func (state *State) SaveWorld() {
+	for _, mob := range state.Mobs {
+		state.SaveCharacter(mob)
+	}
+	if len(state.Changed) == 0 {
+		return
+	}
+	var save []*world.AreaFile
+	for _, file := range liveAreaFiles(state.Areas) {
+		for area := range state.Changed {
+			if area.Name == file.Name {
+				save = append(save, file)
+			}
+		}
+	}
+	if err := world.WriteAreasSQL(state.DB, save); err != nil {
+		log.Printf("saving %d changed areas: %v", len(save), err)
+		return
+	}
+	log.Printf("saved %d changed areas", len(save))
+	state.Changed = make(map[*world.Area]bool)
+}
+
+// drainEvents runs events that are due or arrive shortly after the loop
+// has been told to stop, so messages and cleanup already underway finish
+func drainEvents(state *State, q *EventSlice, incoming <-chan Event) {
+	deadline := time.After(shutdownDrainTime)
+	for {
+		for len(*q) > 0 && !(*q)[0].When.After(time.Now()) {
+			e := heap.Pop(q).(Event)
+			e.What(state)
+			state.sendUpdates()
+		}
+		select {
+		case e := <-incoming:
+			heap.Push(q, e)
+		case <-time.After(100 * time.Millisecond):
+			return
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// handleSignals starts a countdown on SIGINT or SIGTERM. A second signal
+// shuts down right away, and a third gives up on a clean shutdown. SIGUSR2
+// starts a copyover right away, in http mode.
+func handleSignals(q Queue) {
+	copyover := make(chan os.Signal, 1)
+	signal.Notify(copyover, syscall.SIGUSR2)
+	go func() {
+		for sig := range copyover {
+			log.Printf("received %v", sig)
+			if err := checkCopyover(); err != nil {
+				log.Printf("ignoring %v: %v", sig, err)
+				continue
+			}
+			q.Schedule(func(state *State) {
+				state.ScheduleShutdown(&Shutdown{Reboot: true, Copyover: true, Reason: "new server version"}, 0)
+			}, 0)
+		}
+	}()
+
+	signals := make(chan os.Signal, 3)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v", sig)
+		q.Schedule(func(state *State) {
+			state.ScheduleShutdown(&Shutdown{Reason: "server maintenance"}, signalShutdownDelay)
+		}, 0)
+
+		sig = <-signals
+		log.Printf("received %v again", sig)
+		q.Schedule(func(state *State) {
+			reason := "server maintenance"
+			if state.Shutdown != nil {
+				reason = state.Shutdown.Reason
+			}
+			state.ScheduleShutdown(&Shutdown{Reason: reason}, 0)
+		}, 0)
+
+		sig = <-signals
+		log.Fatalf("received %v a third time: exiting now", sig)
+	}()
+}
+
+// reexec replaces this process with a fresh copy of the server binary
+func reexec() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, os.Args, os.Environ())
+}
+
+func CmdShutdown(state *State, mob *Mob, cmd string) time.Duration {
+	return shutdownCommand(state, mob, cmd, &Shutdown{})
+}
+
+func CmdReboot(state *State, mob *Mob, cmd string) time.Duration {
+	return shutdownCommand(state, mob, cmd, &Shutdown{Reboot: true})
+}
+
+func CmdCopyover(state *State, mob *Mob, cmd string) time.Duration {
+	return shutdownCommand(state, mob, cmd, &Shutdown{Reboot: true, Copyover: true})
+}
+
+func shutdownCommand(state *State, mob *Mob, cmd string, plan *Shutdown) time.Duration {
+	word, rest := nextWord(cmd)
+	delay := defaultShutdownDelay
+	switch {
+	case word == "cancel":
+		if !state.CancelShutdown() {
+			mob.Send(MsgError, "There is no shutdown to cancel.\n")
+		}
+		return 0
+	case word == "now":
+		delay = 0
+	case word != "":
+		if seconds, err := strconv.Atoi(word); err == nil && seconds >= 0 {
+			delay = time.Duration(seconds) * time.Second
+		} else {
+			rest = cmd
+		}
+	}
+	if plan.Copyover {
+		if err := checkCopyover(); err != nil {
+			mob.Send(MsgError, fmt.Sprintf("Cannot copyover: %v.\n", err))
+			return 0
+		}
+	}
+	plan.Reason = rest
+	log.Printf("shutdown (reboot=%v, copyover=%v) requested by %s", plan.Reboot, plan.Copyover, mob.Builder.User.Username)
+	state.ScheduleShutdown(plan, delay)
+	return 0
+}
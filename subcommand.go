@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/russross/gruffles/world"
+	"github.com/russross/meddler"
+)
+
+// subcommands that run instead of the server, named by the first argument
+var subcommands = map[string]func([]string){
+	"init-config":  cmdInitConfig,
+	"migrate":      cmdMigrate,
+	"validate":     cmdValidate,
+	"import-areas": cmdImportAreas,
+	"export-areas": cmdExportAreas,
+	"replay":       cmdReplay,
+}
+
+// areaPaths finds all area files in a directory
+func areaPaths(dir string) []string {
+	var paths []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			log.Fatalf("glob error: %v", err)
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// expandAreaPaths replaces any directories in a list of paths with the
+// area files they contain
+func expandAreaPaths(args []string) []string {
+	var paths []string
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			paths = append(paths, areaPaths(arg)...)
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	return paths
+}
+
+func openAreaDB(path string) *sql.DB {
+	meddler.Default = meddler.SQLite
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=10000&_loc=auto&_foreign_keys=1")
+	if err != nil {
+		log.Fatalf("opening database: %v", err)
+	}
+	if err := MigrateUp(db, 0); err != nil {
+		log.Fatalf("migrating database: %v", err)
+	}
+	return db
+}
+
+func cmdValidate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s validate [<area file or directory>...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  with no arguments, checks the areas directory\n")
+	}
+	flags.Parse(args)
+	paths := expandAreaPaths(flags.Args())
+	if flags.NArg() == 0 {
+		paths = areaPaths("areas")
+	}
+	if len(paths) == 0 {
+		log.Fatalf("no area files found")
+	}
+
+	// all files are checked together so references across areas work
+	var files []*world.AreaFile
+	var okPaths []string
+	failed := 0
+	for _, path := range paths {
+		file, err := world.ReadAreaFile(path)
+		if err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		files = append(files, file)
+		okPaths = append(okPaths, path)
+	}
+	problems := world.ValidateAreaFiles(okPaths, files)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if failed > 0 || len(problems) > 0 {
+		fmt.Printf("%d files could not be read, %d problems found\n", failed, len(problems))
+		os.Exit(1)
+	}
+	fmt.Printf("%d area files ok\n", len(paths))
+}
+
+func cmdImportAreas(args []string) {
+	flags := flag.NewFlagSet("import-areas", flag.ExitOnError)
+	dbPath := flags.String("db", configuredDatabase(), "database file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-areas [-db <file>] <area file or directory>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	paths := expandAreaPaths(flags.Args())
+
+	var files []*world.AreaFile
+	for _, path := range paths {
+		file, err := world.ReadAreaFile(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		files = append(files, file)
+	}
+	if problems := world.ValidateAreaFiles(paths, files); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Printf("%s", problem)
+		}
+		log.Fatalf("refusing to import with %d problems", len(problems))
+	}
+
+	db := openAreaDB(*dbPath)
+	defer db.Close()
+	if err := world.WriteAreasSQL(db, files); err != nil {
+		log.Fatalf("importing areas: %v", err)
+	}
+	log.Printf("imported %d areas into %s", len(files), *dbPath)
+}
+
+func cmdExportAreas(args []string) {
+	flags := flag.NewFlagSet("export-areas", flag.ExitOnError)
+	dbPath := flags.String("db", configuredDatabase(), "database file")
+	format := flags.String("format", "yaml", "file format to write: json or yaml")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export-areas [-db <file>] [-format json|yaml] <directory>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *format != "json" && *format != "yaml" {
+		log.Fatalf("unknown format %q", *format)
+	}
+	dir := flags.Arg(0)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("creating %s: %v", dir, err)
+	}
+
+	db := openAreaDB(*dbPath)
+	defer db.Close()
+	files, err := world.ReadAreasSQL(db)
+	if err != nil {
+		log.Fatalf("exporting areas: %v", err)
+	}
+	used := make(map[string]bool)
+	for _, file := range files {
+		base := areaFilename(file.Name)
+		for n := 2; used[base]; n++ {
+			base = fmt.Sprintf("%s-%d", areaFilename(file.Name), n)
+		}
+		used[base] = true
+		path := filepath.Join(dir, base+"."+*format)
+		if err := world.WriteAreaFile(path, file); err != nil {
+			log.Fatalf("writing %s: %v", path, err)
+		}
+		log.Printf("wrote %s", path)
+	}
+}
+
+// areaFilename turns an area name like "{ 5 35} Merc    Midgaard" into
+// a file name like "merc-midgaard"
+func areaFilename(name string) string {
+	if end := strings.LastIndex(name, "}"); end >= 0 {
+		name = name[end+1:]
+	}
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(name)) {
+		word = strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return "area"
+	}
+	return strings.Join(words, "-")
+}
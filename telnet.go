@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Classic MUD clients connect with telnet. Each line the player types is a
+// command, and messages are sent as text with ANSI colors. The client is
+// asked for its window size (NAWS) so text can be wrapped to fit, and for
+// its terminal type (TTYPE). Echo is turned off while the player types
+// their password. Clients that support GMCP also get structured data.
+
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetNOP  = 241
+	telnetSE   = 240
+
+	telnetOptEcho  = 1
+	telnetOptTType = 24
+	telnetOptNAWS  = 31
+	telnetOptGMCP  = 201
+
+	telnetTTypeIs   = 0
+	telnetTTypeSend = 1
+)
+
+const (
+	maxTelnetLine    = 1024
+	telnetLoginTries = 3
+	telnetLoginTime  = 2 * time.Minute
+
+	// narrower windows are not wrapped
+	minTelnetWidth = 20
+)
+
+const telnetBanner = "\nWelcome to gruffles!\n\n"
+
+var telnetColors = map[MsgType]string{
+	MsgSocial: "\x1b[36m", // cyan
+	MsgCombat: "\x1b[31m", // red
+	MsgError:  "\x1b[33m", // yellow
+}
+
+const ansiReset = "\x1b[0m"
+
+var errTelnetLogin = errors.New("too many failed sign-in attempts")
+
+// TelnetSettings are what the client told us about itself
+type TelnetSettings struct {
+	Width        int
+	Height       int
+	TerminalType string
+	Color        bool
+	GMCP         bool
+
+	// GMCP packages the client asked for, or nil for all of them
+	GMCPSupports map[string]bool
+}
+
+// telnetSocket is a player connected with a telnet client
+type telnetSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// the raw input of the line being read, so a copyover can hand it on,
+	// and a flag to stop reading, set outside the reader
+	line    []byte
+	stopped int32
+
+	// guards writes and the settings, which the reader updates as the
+	// client answers
+	sync.Mutex
+	settings TelnetSettings
+}
+
+func newTelnetSocket(conn net.Conn, settings TelnetSettings) *telnetSocket {
+	return &telnetSocket{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		settings: settings,
+	}
+}
+
+// StartTelnet listens for telnet clients in the background if
+// telnetAddress is set. The listener is returned so it can be closed.
+func StartTelnet(db *sql.DB, q Queue) (net.Listener, error) {
+	if Config.TelnetAddress == "" {
+		return nil, nil
+	}
+	listener, err := listen(Config.TelnetAddress)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("accepting telnet connections on %s", Config.TelnetAddress)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if closedError(err) {
+					return
+				}
+				log.Printf("telnet accept error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			go handleTelnet(conn, db, q)
+		}
+	}()
+	return listener, nil
+}
+
+func handleTelnet(conn net.Conn, db *sql.DB, q Queue) {
+	socket := newTelnetSocket(conn, TelnetSettings{Color: true})
+	if !AcceptingPlayers() {
+		socket.CloseWith(websocket.CloseServiceRestart, "server is shutting down")
+		return
+	}
+	socket.write([]byte{
+		telnetIAC, telnetDO, telnetOptNAWS,
+		telnetIAC, telnetDO, telnetOptTType,
+		telnetIAC, telnetWILL, telnetOptGMCP,
+	})
+	socket.Print(telnetBanner)
+
+	// a signed-in player then picks a character, and guests go straight in
+	conn.SetReadDeadline(time.Now().Add(telnetLoginTime))
+	user, err := socket.login(db)
+	var choice characterChoice
+	if err == nil && user != nil {
+		choice, err = socket.chooseCharacter(db, user.ID)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = errors.New("took too long to sign in")
+	}
+	if err != nil {
+		if !closedError(err) {
+			log.Printf("telnet sign-in from %s: %v", conn.RemoteAddr(), err)
+		}
+		socket.CloseWith(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var builder *Builder
+	if user != nil {
+		if builder, err = LoadBuilder(db, user.ID); err != nil {
+			log.Printf("loading builder for user %d: %v", user.ID, err)
+		}
+	}
+
+	mob, pc := joinGame(q, socket, user, builder, choice)
+	mob.Player.serve(pc, mob, builder, q)
+}
+
+// login asks for a username and password and signs the player in. It
+// returns nil for a guest.
+func (t *telnetSocket) login(db *sql.DB) (*User, error) {
+	client, _, err := net.SplitHostPort(t.conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	for try := 0; try < telnetLoginTries; try++ {
+		t.Print("Username (or press enter to play as a guest): ")
+		username, err := t.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(username) == "" {
+			return nil, nil
+		}
+
+		// the client stops echoing if we claim we will do it
+		t.write([]byte{telnetIAC, telnetWILL, telnetOptEcho})
+		t.Print("Password: ")
+		password, err := t.readLine()
+		t.write([]byte{telnetIAC, telnetWONT, telnetOptEcho})
+		t.Print("\n")
+		if err != nil {
+			return nil, err
+		}
+
+		user, failure := signIn(db, db, username, password, client)
+		if failure == nil {
+			return user, nil
+		}
+		if failure.Status == http.StatusForbidden || failure.Status == http.StatusTooManyRequests {
+			return nil, errors.New(failure.Message)
+		}
+		t.Print("Sign-in failed: " + failure.Message + "\n\n")
+	}
+	return nil, errTelnetLogin
+}
+
+// Settings gives what the client has reported so far
+func (t *telnetSocket) Settings() *TelnetSettings {
+	t.Lock()
+	defer t.Unlock()
+	settings := t.settings
+	return &settings
+}
+
+func (t *telnetSocket) ReadRequest() (*Request, error) {
+	line, err := t.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Type: "cmd", Command: line}, nil
+}
+
+// Client takes all data, which goes out as GMCP if the client wants it,
+// and no maps, which are drawn in a pane only the browser client has
+func (t *telnetSocket) Client() ClientSettings {
+	return ClientSettings{Protocol: protocolVersion, Capabilities: []string{"data"}}
+}
+
+// chooseCharacter lets a signed-in player pick one of their characters or
+// name a new one
+func (t *telnetSocket) chooseCharacter(db *sql.DB, userID int64) (characterChoice, error) {
+	characters, err := listCharacters(db, userID)
+	if err != nil {
+		return characterChoice{}, err
+	}
+	if len(characters) > 0 {
+		t.Print("\nYour characters:\n")
+		for i, elt := range characters {
+			t.Print(fmt.Sprintf("  %d. %s, last played %s\n", i+1, elt.Name, elt.SavedAt.Format("2 January 2006")))
+		}
+	}
+	for try := 0; try < telnetLoginTries; try++ {
+		if len(characters) > 0 {
+			t.Print("Enter a number, or a name for a new character: ")
+		} else {
+			t.Print("Name your character: ")
+		}
+		answer, err := t.readLine()
+		if err != nil {
+			return characterChoice{}, err
+		}
+		answer = strings.TrimSpace(answer)
+		if n, err := strconv.Atoi(answer); err == nil {
+			if n >= 1 && n <= len(characters) {
+				return characterChoice{ID: characters[n-1].ID}, nil
+			}
+			t.Print("There is no character with that number.\n")
+			continue
+		}
+		name, err := parseCharacterName(answer)
+		if err != nil {
+			t.Print("That name will not do: " + err.Error() + ".\n")
+			continue
+		}
+		for _, elt := range characters {
+			if strings.EqualFold(elt.Name, name) {
+				return characterChoice{ID: elt.ID}, nil
+			}
+		}
+		return characterChoice{Name: name}, nil
+	}
+	return characterChoice{}, errors.New("no character chosen")
+}
+
+// readLine reads a line of input, handling telnet commands along the way
+func (t *telnetSocket) readLine() (string, error) {
+	t.line = t.line[:0]
+	var line []byte
+	for {
+		b, err := t.readByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case telnetIAC:
+			if err := t.command(); err != nil {
+				return "", err
+			}
+		case '\n':
+			return strings.ToValidUTF8(string(line), ""), nil
+		case '\r', 0:
+		case '\b', 127:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+			}
+		default:
+			if len(line) < maxTelnetLine {
+				line = append(line, b)
+			}
+		}
+	}
+}
+
+// readByte reads the next byte of input and keeps a copy of it
+func (t *telnetSocket) readByte() (byte, error) {
+	if atomic.LoadInt32(&t.stopped) != 0 {
+		return 0, errDetaching
+	}
+	b, err := t.reader.ReadByte()
+	if err == nil {
+		t.line = append(t.line, b)
+	}
+	return b, err
+}
+
+// command handles a telnet command after IAC
+func (t *telnetSocket) command() error {
+	cmd, err := t.readByte()
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		option, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		return t.option(cmd, option)
+	case telnetSB:
+		return t.subnegotiation()
+	}
+
+	// IAC IAC is a 255 byte, which never appears in UTF-8 text, and the
+	// other commands need no answer
+	return nil
+}
+
+// option answers the client's side of option negotiation. Options we
+// asked for are accepted and everything else is refused. Refusals are not
+// answered, so there is no loop.
+func (t *telnetSocket) option(cmd, option byte) error {
+	switch {
+	case cmd == telnetWILL && option == telnetOptTType:
+		return t.write([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE})
+	case cmd == telnetWILL && option == telnetOptNAWS:
+		// the window size follows
+		return nil
+	case cmd == telnetWILL:
+		return t.write([]byte{telnetIAC, telnetDONT, option})
+	case option == telnetOptGMCP && (cmd == telnetDO || cmd == telnetDONT):
+		t.Lock()
+		t.settings.GMCP = cmd == telnetDO
+		t.Unlock()
+	case cmd == telnetDO && option != telnetOptEcho:
+		return t.write([]byte{telnetIAC, telnetWONT, option})
+	}
+	return nil
+}
+
+// subnegotiation reads the window size, terminal type, or a GMCP message
+func (t *telnetSocket) subnegotiation() error {
+	var data []byte
+	for {
+		b, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		if b == telnetIAC {
+			if b, err = t.readByte(); err != nil {
+				return err
+			}
+			if b == telnetSE {
+				break
+			}
+		}
+		if len(data) < maxTelnetLine {
+			data = append(data, b)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	switch data[0] {
+	case telnetOptNAWS:
+		if len(data) == 5 {
+			t.settings.Width = int(data[1])<<8 | int(data[2])
+			t.settings.Height = int(data[3])<<8 | int(data[4])
+		}
+	case telnetOptTType:
+		if len(data) > 1 && data[1] == telnetTTypeIs {
+			t.settings.TerminalType = string(data[2:])
+			t.settings.Color = !strings.EqualFold(t.settings.TerminalType, "dumb")
+		}
+	case telnetOptGMCP:
+		// the only messages we act on are the ones saying what to send
+		fields := strings.SplitN(string(data[1:]), " ", 2)
+		command := strings.ToLower(fields[0])
+		if len(fields) == 2 && strings.HasPrefix(command, "core.supports.") {
+			t.settings.gmcpSupports(command, []byte(fields[1]))
+		}
+	}
+	return nil
+}
+
+func (t *telnetSocket) write(p []byte) error {
+	t.Lock()
+	defer t.Unlock()
+	t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := t.conn.Write(p)
+	return err
+}
+
+// Ping sends a no-op, so a connection that has died is noticed
+func (t *telnetSocket) Ping() error {
+	return t.write([]byte{telnetIAC, telnetNOP})
+}
+
+// Print sends text, with line endings for a terminal
+func (t *telnetSocket) Print(text string) error {
+	text = strings.Replace(text, "\r", "", -1)
+	return t.write([]byte(strings.Replace(text, "\n", "\r\n", -1)))
+}
+
+// WriteMsgs sends messages as text and GMCP in one write. Telnet has no
+// frames, so batch makes no difference.
+func (t *telnetSocket) WriteMsgs(msgs []Msg, batch bool) error {
+	settings := t.Settings()
+	var out []byte
+	for i := range msgs {
+		raw, err := settings.format(&msgs[i])
+		if err != nil {
+			return err
+		}
+		out = append(out, raw...)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return t.write(out)
+}
+
+// format gives the bytes for a message, which are empty for data the
+// client did not ask for
+func (settings *TelnetSettings) format(msg *Msg) ([]byte, error) {
+	if pkg, present := gmcpPackages[msg.Type]; present {
+		if !settings.GMCP || !settings.gmcpWants(pkg) {
+			return nil, nil
+		}
+		return gmcpMessage(pkg, msg.Data)
+	}
+	text := strings.TrimRight(strings.Replace(msg.Message, "\r", "", -1), "\n")
+	if settings.Width >= minTelnetWidth {
+		text = wrapText(text, settings.Width)
+	}
+	if color := telnetColors[msg.Type]; settings.Color && color != "" {
+		text = color + text + ansiReset
+	}
+	return []byte(strings.Replace(text+"\n", "\n", "\r\n", -1)), nil
+}
+
+// CloseWith tells the player why they are being disconnected. Telnet has
+// no close codes.
+func (t *telnetSocket) CloseWith(code int, reason string) {
+	if reason != "" {
+		t.Print("\nDisconnected: " + reason + ".\n")
+	}
+	t.conn.Close()
+}
+
+func (t *telnetSocket) Close() error {
+	return t.conn.Close()
+}
+
+func (t *telnetSocket) StopReading() {
+	atomic.StoreInt32(&t.stopped, 1)
+}
+
+// Unread gives the part of a line read so far and the input after it
+func (t *telnetSocket) Unread() []byte {
+	rest, _ := t.reader.Peek(t.reader.Buffered())
+	return append(append([]byte{}, t.line...), rest...)
+}
+
+func (t *telnetSocket) NetConn() net.Conn {
+	return t.conn
+}
+
+// wrapText breaks lines longer than width at spaces
+func wrapText(text string, width int) string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		for utf8.RuneCountInString(line) > width {
+			cut, count := -1, 0
+			for i, ch := range line {
+				if count > width {
+					break
+				}
+				if ch == ' ' {
+					cut = i
+				}
+				count++
+			}
+			if cut <= 0 {
+				// a word longer than a line gets a line of its own
+				if cut = strings.Index(line, " "); cut <= 0 {
+					break
+				}
+			}
+			out = append(out, line[:cut])
+			line = strings.TrimLeft(line[cut+1:], " ")
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
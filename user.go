@@ -3,16 +3,16 @@ package main
 import (
 	crand "crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
-	"log"
+	"encoding/base64"
 	"net/http"
 	"strings"
 	"time"
 	"unicode/utf8"
 
-	"golang.org/x/crypto/pbkdf2"
-
 	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
 	"github.com/martini-contrib/render"
 	"github.com/russross/meddler"
 )
@@ -22,6 +22,9 @@ type User struct {
 	Username       string    `meddler:"username"`
 	Admin          bool      `meddler:"admin"`
 	Author         bool      `meddler:"author"`
+	Banned         bool      `meddler:"banned"`
+	RecordSessions bool      `meddler:"record_sessions"`
+	RecordForced   bool      `meddler:"record_forced"`
 	Password       string    `meddler:"-" json:"password,omitempty"`
 	Salt           []byte    `meddler:"salt" json:"-"`
 	Scheme         string    `meddler:"scheme" json:"-"`
@@ -61,21 +64,12 @@ func CreateUser(w http.ResponseWriter, r *http.Request, tx *sql.Tx, user User, r
 
 	user.Admin = false
 	user.Author = false
-	user.Scheme = "PBKDF2-HMAC-SHA256:64k"
-	user.Salt = make([]byte, 16)
-	if _, err := crand.Read(user.Salt); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "salt error: %v", err)
-		return
-	}
-	start := time.Now()
-	user.PasswordHash = pbkdf2.Key(
-		[]byte(user.Password),
-		user.Salt,
-		64*1024,
-		32,
-		sha256.New)
-	log.Printf("hash took %v", time.Since(start))
-	user.Password = ""
+	user.Banned = false
+	user.RecordForced = false
+	if err := setPassword(&user, user.Password); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
 	user.CreatedAt = now
 	user.ModifiedAt = now
 	user.LastSignedInAt = now
@@ -127,3 +121,213 @@ func GetUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUs
 func GetUserMe(w http.ResponseWriter, currentUser *User, render render.Render) {
 	render.JSON(http.StatusOK, currentUser)
 }
+
+// UserPatch has the fields an admin can change. Missing fields are left
+// alone.
+type UserPatch struct {
+	Admin        *bool
+	Author       *bool
+	Banned       *bool
+	RecordForced *bool
+}
+
+func PatchUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params, patch UserPatch, currentUser *User, q Queue, render render.Render) {
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+
+	user := new(User)
+	if err = meddler.Load(tx, "users", user, userID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	// admins cannot lock themselves out
+	if user.ID == currentUser.ID && ((patch.Admin != nil && !*patch.Admin) || (patch.Banned != nil && *patch.Banned)) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "you cannot remove your own admin status or ban yourself")
+		return
+	}
+
+	if patch.Admin != nil {
+		user.Admin = *patch.Admin
+	}
+	if patch.Author != nil {
+		user.Author = *patch.Author
+	}
+	if patch.Banned != nil {
+		user.Banned = *patch.Banned
+	}
+	if patch.RecordForced != nil {
+		user.RecordForced = *patch.RecordForced
+	}
+	user.ModifiedAt = time.Now()
+	if err = meddler.Update(tx, "users", user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if user.Banned {
+		disconnectUser(q, user.ID, "Your account has been banned.")
+	} else if patch.RecordForced != nil {
+		recordUser(q, user)
+	}
+
+	render.JSON(http.StatusOK, user)
+}
+
+// DeleteUser removes a user. Their sessions, accounts, area assignments,
+// and password resets go with them.
+func DeleteUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, q Queue) {
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	if userID == currentUser.ID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "you cannot delete yourself")
+		return
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		loggedHTTPDBNotFoundError(w, sql.ErrNoRows)
+		return
+	}
+	disconnectUser(q, userID, "Your account has been deleted.")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// disconnectUser closes any game connections that belong to a user
+func disconnectUser(q Queue, userID int64, msg string) {
+	q.Schedule(func(state *State) {
+		var linkDead []*Mob
+		for _, mob := range state.Mobs {
+			if mob.UserID != userID || mob.Player == nil {
+				continue
+			}
+			if mob.Player.LinkDead() {
+				linkDead = append(linkDead, mob)
+				continue
+			}
+			mob.Send(MsgError, msg+"\n")
+			mob.Player.Close(websocket.ClosePolicyViolation, msg)
+		}
+		for _, mob := range linkDead {
+			state.RemoveMob(mob)
+		}
+	}, 0)
+}
+
+// how long a password reset token is good for
+const passwordResetLifetime = 24 * time.Hour
+
+type PasswordReset struct {
+	UserID    int64     `meddler:"user_id,pk"`
+	Token     string    `meddler:"-"`
+	TokenHash []byte    `meddler:"token_hash" json:"-"`
+	ExpiresAt time.Time `meddler:"expires_at"`
+}
+
+// CreatePasswordReset issues a one-time token that an admin can pass on
+// to a user so they can set a new password. Only the latest token for a
+// user is valid.
+func CreatePasswordReset(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+
+	var count int
+	if err = tx.QueryRow(`SELECT COUNT(1) FROM users WHERE id = ?`, userID).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count == 0 {
+		loggedHTTPDBNotFoundError(w, sql.ErrNoRows)
+		return
+	}
+
+	raw := make([]byte, 24)
+	if _, err = crand.Read(raw); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "token error: %v", err)
+		return
+	}
+	reset := &PasswordReset{
+		UserID:    userID,
+		Token:     base64.RawURLEncoding.EncodeToString(raw),
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	}
+	hash := sha256.Sum256([]byte(reset.Token))
+	reset.TokenHash = hash[:]
+	if _, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		reset.UserID, reset.TokenHash, reset.ExpiresAt); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, reset)
+}
+
+// PasswordResetRequest uses a reset token to set a new password
+type PasswordResetRequest struct {
+	Username string
+	Token    string
+	Password string
+}
+
+func UsePasswordReset(w http.ResponseWriter, tx *sql.Tx, req PasswordResetRequest, render render.Render) {
+	if len(req.Password) < 12 || len(req.Password) > 256 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "password must be between 12 and 256 characters")
+		return
+	}
+
+	user := new(User)
+	if err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE username = ?`,
+		strings.ToLower(strings.TrimSpace(req.Username))); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusUnauthorized, "invalid or expired reset token")
+			return
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	reset := new(PasswordReset)
+	if err := meddler.Load(tx, "password_resets", reset, user.ID); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusUnauthorized, "invalid or expired reset token")
+			return
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	hash := sha256.Sum256([]byte(req.Token))
+	if subtle.ConstantTimeCompare(hash[:], reset.TokenHash) != 1 || reset.ExpiresAt.Before(time.Now()) {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "invalid or expired reset token")
+		return
+	}
+
+	// the token can only be used once
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := setPassword(user, req.Password); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	user.ModifiedAt = time.Now()
+	if err := meddler.Update(tx, "users", user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,183 @@
+package world
+
+import (
+	"bytes"
+	"time"
+)
+
+type Area struct {
+	ID         int       `meddler:"id,pk"`
+	Name       string    `meddler:"name"`
+	Helps      []*Help   `meddler:"-"`
+	Mobiles    []*Mobile `meddler:"-"`
+	Objects    []*Object `meddler:"-"`
+	Rooms      []*Room   `meddler:"-"`
+	Resets     []*Reset  `meddler:"-"`
+	CreatedAt  time.Time `meddler:"created_at"`
+	ModifiedAt time.Time `meddler:"modified_at"`
+}
+
+type Help struct {
+	ID       int      `meddler:"id,pk"`
+	AreaID   int      `meddler:"area_id"`
+	Level    int      `meddler:"level"`
+	Keywords []string `meddler:"keywords,json"`
+	Text     string   `meddler:"help_text"`
+}
+
+type Mobile struct {
+	ID               int         `meddler:"id,pk"`
+	AreaID           int         `meddler:"area_id"`
+	Vnum             int         `meddler:"vnum,zeroisnull"`
+	Keywords         []string    `meddler:"keywords,json"`
+	ShortDescription string      `meddler:"short_description"`
+	LongDescription  string      `meddler:"long_description"`
+	Description      string      `meddler:"description"`
+	ActionFlags      ActFlags    `meddler:"action_flags,flags"`
+	AffectedFlags    AffectFlags `meddler:"affected_flags,flags"`
+	Alignment        int         `meddler:"alignment"`
+	Level            int         `meddler:"level"`
+	HitBonus         int         `meddler:"hit_bonus"`
+	Armor            int         `meddler:"armor"`
+	HitRoll          []int       `meddler:"hit_roll,json"`
+	DamageRoll       []int       `meddler:"damage_roll,json"`
+	DodgeRoll        []int       `meddler:"dodge_roll,json"`
+	AbsorbRoll       []int       `meddler:"absorb_roll,json"`
+	FireRoll         []int       `meddler:"fire_roll,json"`
+	IceRoll          []int       `meddler:"ice_roll,json"`
+	PoisonRoll       []int       `meddler:"poison_roll,json"`
+	LightningRoll    []int       `meddler:"lightning_roll,json"`
+	Gold             int         `meddler:"gold"`
+	Experience       int         `meddler:"experience"`
+	Pronouns         string      `meddler:"pronouns"`
+	StartPosition    string      `meddler:"start_position"`
+	DefaultPosition  string      `meddler:"default_position"`
+}
+
+type Object struct {
+	ID                int                      `meddler:"id,pk"`
+	AreaID            int                      `meddler:"area_id"`
+	Vnum              int                      `meddler:"vnum,zeroisnull"`
+	Keywords          []string                 `meddler:"keywords,json"`
+	ShortDescription  string                   `meddler:"short_description"`
+	LongDescription   string                   `meddler:"long_description"`
+	ActionDescription string                   `meddler:"action_description"`
+	ItemType          int                      `meddler:"item_type"`
+	ExtraFlags        ExtraFlags               `meddler:"extra_flags,flags"`
+	WearFlags         WearFlags                `meddler:"wear_flags,flags"`
+	Value0            int                      `meddler:"value_0"`
+	Value1            int                      `meddler:"value_1"`
+	Value2            int                      `meddler:"value_2"`
+	Value3            int                      `meddler:"value_3"`
+	Value4            int                      `meddler:"value_4"`
+	Value5            int                      `meddler:"value_5"`
+	Weight            int                      `meddler:"weight"`
+	Cost              int                      `meddler:"cost"`
+	CostPerDay        int                      `meddler:"cost_per_day"`
+	Extras            []ObjectExtraDescription `meddler:"extras,json"`
+	Applies           []ObjectApply            `meddler:"applies,json"`
+}
+
+type ObjectExtraDescription struct {
+	Keywords    []string `json:"keywords"`
+	Description string   `json:"description"`
+}
+
+type ObjectApply struct {
+	Type  int `json:"type"`
+	Value int `json:"value"`
+}
+
+type Room struct {
+	ID          int                    `meddler:"id,pk"`
+	AreaID      int                    `meddler:"area_id"`
+	Vnum        int                    `meddler:"vnum,zeroisnull"`
+	Name        string                 `meddler:"name"`
+	Description string                 `meddler:"description"`
+	Flags       RoomFlags              `meddler:"flags,flags"`
+	Terrain     int                    `meddler:"terrain"`
+	Extras      []RoomExtraDescription `meddler:"extras,json"`
+	Doors       []Door                 `meddler:"-"`
+}
+
+type Door struct {
+	ID          int      `meddler:"id,pk"`
+	RoomID      int      `meddler:"room_id"`
+	Direction   int      `meddler:"direction"`
+	Description string   `meddler:"description"`
+	Keywords    []string `meddler:"keywords,json"`
+	Lock        int      `meddler:"lock,zeroisnull"`
+	Key         int      `meddler:"key,zeroisnull"`
+	ToRoom      int      `meddler:"to_room,zeroisnull"`
+}
+
+type RoomExtraDescription struct {
+	Keywords    []string `json:"keywords"`
+	Description string   `json:"description"`
+}
+
+type Reset struct {
+	ID            int    `meddler:"id,pk"`
+	Type          string `meddler:"reset_type"`
+	AreaID        int    `meddler:"area_id"`
+	Sequence      int    `meddler:"sequence"`
+	RoomID        int    `meddler:"room_id,zeroisnull"`
+	MobileID      int    `meddler:"mobile_id,zeroisnull"`
+	ObjectID      int    `meddler:"object_id,zeroisnull"`
+	ContainerID   int    `meddler:"container_id,zeroisnull"`
+	WearLocation  int    `meddler:"wear_location,zeroisnull"`
+	MaxInstances  int    `meddler:"max_instances,zeroisnull"`
+	DoorDirection int    `meddler:"door_direction"`
+	DoorState     int    `meddler:"door_state"`
+	LastDoor      int    `meddler:"last_door"`
+	Comment       string `meddler:"comment"`
+}
+
+func (r *Room) Zone() int {
+	return r.ID / 100
+}
+
+// Exit finds the room that an exit leads to, where dir is the first
+// letter of the direction. rooms is indexed by room ID.
+func (r *Room) Exit(rooms []*Room, dir rune) *Room {
+	for _, door := range r.Doors {
+		exit := rune(Directions[door.Direction][0])
+		if exit == dir && door.ToRoom >= 0 && door.ToRoom < len(rooms) {
+			return rooms[door.ToRoom]
+		}
+	}
+	return nil
+}
+
+// writeExits lists the exits that lead somewhere, by first letter
+func (r *Room) writeExits(buf *bytes.Buffer) {
+	n := 0
+	for _, door := range r.Doors {
+		if door.ToRoom == 0 {
+			continue
+		}
+		if n > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(Directions[door.Direction][0:1])
+		n++
+	}
+}
+
+func (r *Room) GetShortDescription() string {
+	var buf bytes.Buffer
+	buf.WriteString(r.Name)
+	buf.WriteString("\nExits [")
+	r.writeExits(&buf)
+	buf.WriteString("]\n")
+	return buf.String()
+}
+
+func (r *Room) GetDescription() string {
+	var buf bytes.Buffer
+	buf.WriteString(r.Description)
+	buf.WriteString("\nExits [")
+	r.writeExits(&buf)
+	buf.WriteString("]\n")
+	return buf.String()
+}
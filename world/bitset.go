@@ -0,0 +1,153 @@
+package world
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const bitWordSize = 32
+
+// A BitSet is a set of small non-negative integers of any size. The
+// zero value is an empty set.
+type BitSet struct {
+	bits []uint32
+}
+
+// BitSetFromInt makes a set from the bits of a non-negative int, which is
+// how flags are written in Merc area files
+func BitSetFromInt(n int) BitSet {
+	var set BitSet
+	for i := uint(0); n > 0; i, n = i+1, n>>1 {
+		if n&1 != 0 {
+			set.Set(i)
+		}
+	}
+	return set
+}
+
+// ParseBitSet parses the hexadecimal form produced by String, with or
+// without a 0x prefix
+func ParseBitSet(s string) (BitSet, error) {
+	var set BitSet
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	if s == "" {
+		return set, fmt.Errorf("empty bit set")
+	}
+	for end := len(s); end > 0; end -= bitWordSize / 4 {
+		start := end - bitWordSize/4
+		if start < 0 {
+			start = 0
+		}
+		word, err := strconv.ParseUint(s[start:end], 16, bitWordSize)
+		if err != nil {
+			return BitSet{}, fmt.Errorf("parsing bit set %q: %v", s, err)
+		}
+		set.bits = append(set.bits, uint32(word))
+	}
+	return set, nil
+}
+
+func (set *BitSet) IsSet(i uint) bool {
+	index, offset := i/bitWordSize, i%bitWordSize
+	if index >= uint(len(set.bits)) {
+		return false
+	}
+	return set.bits[index]&(1<<offset) != 0
+}
+
+func (set *BitSet) Clear(i uint) {
+	index, offset := i/bitWordSize, i%bitWordSize
+	if index >= uint(len(set.bits)) {
+		return
+	}
+	set.bits[index] &^= 1 << offset
+}
+
+func (set *BitSet) Set(i uint) {
+	index, offset := i/bitWordSize, i%bitWordSize
+	for n := uint(len(set.bits)); n <= index; n++ {
+		set.bits = append(set.bits, 0)
+	}
+	set.bits[index] |= 1 << offset
+}
+
+// Union adds every member of other to the set
+func (set *BitSet) Union(other BitSet) {
+	for n := len(set.bits); n < len(other.bits); n++ {
+		set.bits = append(set.bits, 0)
+	}
+	for i, word := range other.bits {
+		set.bits[i] |= word
+	}
+}
+
+// Difference removes every member of other from the set
+func (set *BitSet) Difference(other BitSet) {
+	for i := 0; i < len(set.bits) && i < len(other.bits); i++ {
+		set.bits[i] &^= other.bits[i]
+	}
+}
+
+// Members lists the members of the set in increasing order
+func (set BitSet) Members() []uint {
+	var list []uint
+	for i, word := range set.bits {
+		for offset := uint(0); offset < bitWordSize; offset++ {
+			if word&(1<<offset) != 0 {
+				list = append(list, uint(i)*bitWordSize+offset)
+			}
+		}
+	}
+	return list
+}
+
+func (set BitSet) IsZero() bool {
+	for _, word := range set.bits {
+		if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (set BitSet) Equal(other BitSet) bool {
+	for i := 0; i < len(set.bits) || i < len(other.bits); i++ {
+		var a, b uint32
+		if i < len(set.bits) {
+			a = set.bits[i]
+		}
+		if i < len(other.bits) {
+			b = other.bits[i]
+		}
+		if a != b {
+			return false
+		}
+	}
+	return true
+}
+
+// Int returns the set as an int, for the places that still treat flags
+// as numbers. Members that do not fit are dropped.
+func (set BitSet) Int() int {
+	n := 0
+	for _, i := range set.Members() {
+		if i < 63 {
+			n |= 1 << i
+		}
+	}
+	return n
+}
+
+func (set *BitSet) String() string {
+	buf := new(bytes.Buffer)
+	for i := len(set.bits) - 1; i >= 0; i-- {
+		fmt.Fprintf(buf, "%0*x", bitWordSize/4, set.bits[i])
+	}
+	raw := bytes.TrimLeft(buf.Bytes(), "0")
+	if len(raw) == 0 {
+		return "0"
+	}
+	return string(raw)
+}
@@ -0,0 +1,159 @@
+package world
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBitSetSetClear(t *testing.T) {
+	tests := []struct {
+		set, clear []uint
+		members    []uint
+		str        string
+	}{
+		{nil, nil, nil, "0"},
+		{[]uint{0}, nil, []uint{0}, "1"},
+		{[]uint{0, 4, 31}, nil, []uint{0, 4, 31}, "80000011"},
+		{[]uint{32}, nil, []uint{32}, "100000000"},
+		{[]uint{1, 64, 95}, nil, []uint{1, 64, 95}, "800000010000000000000002"},
+		{[]uint{3, 3}, nil, []uint{3}, "8"},
+		{[]uint{3, 40}, []uint{40}, []uint{3}, "8"},
+		{[]uint{3}, []uint{100}, []uint{3}, "8"},
+		{[]uint{200}, []uint{200}, nil, "0"},
+	}
+	for _, test := range tests {
+		var set BitSet
+		for _, i := range test.set {
+			set.Set(i)
+		}
+		for _, i := range test.clear {
+			set.Clear(i)
+		}
+		if got := set.Members(); !reflect.DeepEqual(got, test.members) {
+			t.Errorf("set %v clear %v: members %v, expected %v", test.set, test.clear, got, test.members)
+		}
+		if got := set.String(); got != test.str {
+			t.Errorf("set %v clear %v: string %q, expected %q", test.set, test.clear, got, test.str)
+		}
+		for i := uint(0); i < 256; i++ {
+			expected := false
+			for _, elt := range test.members {
+				expected = expected || elt == i
+			}
+			if set.IsSet(i) != expected {
+				t.Errorf("set %v clear %v: IsSet(%d) is %v", test.set, test.clear, i, !expected)
+			}
+		}
+		if set.IsZero() != (len(test.members) == 0) {
+			t.Errorf("set %v clear %v: IsZero is %v", test.set, test.clear, set.IsZero())
+		}
+	}
+}
+
+func TestBitSetUnionDifference(t *testing.T) {
+	tests := []struct {
+		a, b              []uint
+		union, difference []uint
+	}{
+		{nil, nil, nil, nil},
+		{[]uint{1}, nil, []uint{1}, []uint{1}},
+		{nil, []uint{1}, []uint{1}, nil},
+		{[]uint{1, 2}, []uint{2, 3}, []uint{1, 2, 3}, []uint{1}},
+		{[]uint{1}, []uint{70}, []uint{1, 70}, []uint{1}},
+		{[]uint{1, 70}, []uint{70}, []uint{1, 70}, []uint{1}},
+		{[]uint{33, 65}, []uint{1, 33, 65}, []uint{1, 33, 65}, nil},
+	}
+	for _, test := range tests {
+		a, b := bits(test.a...), bits(test.b...)
+		union := bits(test.a...)
+		union.Union(b)
+		if got := union.Members(); !reflect.DeepEqual(got, test.union) {
+			t.Errorf("%v union %v gave %v, expected %v", test.a, test.b, got, test.union)
+		}
+		difference := bits(test.a...)
+		difference.Difference(b)
+		if got := difference.Members(); !reflect.DeepEqual(got, test.difference) {
+			t.Errorf("%v minus %v gave %v, expected %v", test.a, test.b, got, test.difference)
+		}
+		if !a.Equal(bits(test.a...)) || !b.Equal(bits(test.b...)) {
+			t.Errorf("%v and %v were changed", test.a, test.b)
+		}
+	}
+}
+
+func TestBitSetEqual(t *testing.T) {
+	a := bits(1, 40)
+	b := bits(1, 40, 90)
+	b.Clear(90)
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Errorf("sets with different lengths but the same members are not equal")
+	}
+	if a.Equal(bits(1)) || bits(1).Equal(a) {
+		t.Errorf("different sets are equal")
+	}
+	if !new(BitSet).Equal(bits()) {
+		t.Errorf("empty sets are not equal")
+	}
+}
+
+func TestBitSetFromInt(t *testing.T) {
+	tests := []struct {
+		n       int
+		members []uint
+	}{
+		{0, nil},
+		{1, []uint{0}},
+		{6, []uint{1, 2}},
+		{1 << 40, []uint{40}},
+	}
+	for _, test := range tests {
+		set := BitSetFromInt(test.n)
+		if got := set.Members(); !reflect.DeepEqual(got, test.members) {
+			t.Errorf("BitSetFromInt(%d) gave %v, expected %v", test.n, got, test.members)
+		}
+		if set.Int() != test.n {
+			t.Errorf("BitSetFromInt(%d).Int() gave %d", test.n, set.Int())
+		}
+	}
+}
+
+func TestParseBitSet(t *testing.T) {
+	tests := []struct {
+		s       string
+		members []uint
+		bad     bool
+	}{
+		{"0", nil, false},
+		{"0x0", nil, false},
+		{"1", []uint{0}, false},
+		{"0x80000011", []uint{0, 4, 31}, false},
+		{"100000000", []uint{32}, false},
+		{"0x800000010000000000000002", []uint{1, 64, 95}, false},
+		{" 0xff ", []uint{0, 1, 2, 3, 4, 5, 6, 7}, false},
+		{"000000000000000001", []uint{0}, false},
+		{"", nil, true},
+		{"0x", nil, true},
+		{"0xg", nil, true},
+		{"-1", nil, true},
+	}
+	for _, test := range tests {
+		set, err := ParseBitSet(test.s)
+		if test.bad {
+			if err == nil {
+				t.Errorf("ParseBitSet(%q) gave %v, expected an error", test.s, set.Members())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBitSet(%q): %v", test.s, err)
+			continue
+		}
+		if got := set.Members(); !reflect.DeepEqual(got, test.members) {
+			t.Errorf("ParseBitSet(%q) gave %v, expected %v", test.s, got, test.members)
+		}
+		again, err := ParseBitSet(set.String())
+		if err != nil || !again.Equal(set) {
+			t.Errorf("ParseBitSet(%q) did not survive String: %q", test.s, set.String())
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package world
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/russross/meddler"
+)
+
+// LoadAreasSQL loads every area from the database along with its helps,
+// mobiles, objects, rooms, doors, and resets. Everything keeps its
+// database ID, and doors and resets refer to IDs.
+func LoadAreasSQL(db meddler.DB) ([]*Area, error) {
+	var areas []*Area
+	if err := meddler.QueryAll(db, &areas, `SELECT * FROM areas ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("loading areas: %v", err)
+	}
+	byID := make(map[int]*Area)
+	for _, area := range areas {
+		byID[area.ID] = area
+	}
+
+	var helps []*Help
+	if err := meddler.QueryAll(db, &helps, `SELECT * FROM helps ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("loading helps: %v", err)
+	}
+	for _, elt := range helps {
+		if area := byID[elt.AreaID]; area != nil {
+			area.Helps = append(area.Helps, elt)
+		}
+	}
+
+	var mobiles []*Mobile
+	if err := meddler.QueryAll(db, &mobiles, `SELECT * FROM mobiles ORDER BY vnum, id`); err != nil {
+		return nil, fmt.Errorf("loading mobiles: %v", err)
+	}
+	for _, elt := range mobiles {
+		if area := byID[elt.AreaID]; area != nil {
+			area.Mobiles = append(area.Mobiles, elt)
+		}
+	}
+
+	var objects []*Object
+	if err := meddler.QueryAll(db, &objects, `SELECT * FROM objects ORDER BY vnum, id`); err != nil {
+		return nil, fmt.Errorf("loading objects: %v", err)
+	}
+	for _, elt := range objects {
+		if area := byID[elt.AreaID]; area != nil {
+			area.Objects = append(area.Objects, elt)
+		}
+	}
+
+	roomsByID := make(map[int]*Room)
+	var rooms []*Room
+	if err := meddler.QueryAll(db, &rooms, `SELECT * FROM rooms ORDER BY vnum, id`); err != nil {
+		return nil, fmt.Errorf("loading rooms: %v", err)
+	}
+	for _, elt := range rooms {
+		elt.Doors = []Door{}
+		roomsByID[elt.ID] = elt
+		if area := byID[elt.AreaID]; area != nil {
+			area.Rooms = append(area.Rooms, elt)
+		}
+	}
+
+	var doors []*Door
+	if err := meddler.QueryAll(db, &doors, `SELECT * FROM doors ORDER BY room_id, direction`); err != nil {
+		return nil, fmt.Errorf("loading doors: %v", err)
+	}
+	for _, elt := range doors {
+		if room := roomsByID[elt.RoomID]; room != nil {
+			room.Doors = append(room.Doors, *elt)
+		}
+	}
+
+	var resets []*Reset
+	if err := meddler.QueryAll(db, &resets, `SELECT * FROM resets ORDER BY area_id, sequence`); err != nil {
+		return nil, fmt.Errorf("loading resets: %v", err)
+	}
+	for _, elt := range resets {
+		if area := byID[elt.AreaID]; area != nil {
+			area.Resets = append(area.Resets, elt)
+		}
+	}
+
+	return areas, nil
+}
+
+// LoadHelpsSQL loads every help entry from the database
+func LoadHelpsSQL(db meddler.DB) ([]*Help, error) {
+	var helps []*Help
+	if err := meddler.QueryAll(db, &helps, `SELECT * FROM helps ORDER BY id`); err != nil {
+		return nil, fmt.Errorf("loading helps: %v", err)
+	}
+	return helps, nil
+}
+
+// ReadAreasSQL loads every area from the database and converts it to the
+// text area format
+func ReadAreasSQL(db meddler.DB) ([]*AreaFile, error) {
+	areas, err := LoadAreasSQL(db)
+	if err != nil {
+		return nil, err
+	}
+	roomVnums := make(map[int]int)
+	mobileVnums := make(map[int]int)
+	objectVnums := make(map[int]int)
+	for _, area := range areas {
+		for _, elt := range area.Rooms {
+			roomVnums[elt.ID] = elt.Vnum
+		}
+		for _, elt := range area.Mobiles {
+			mobileVnums[elt.ID] = elt.Vnum
+		}
+		for _, elt := range area.Objects {
+			objectVnums[elt.ID] = elt.Vnum
+		}
+	}
+
+	var files []*AreaFile
+	for _, area := range areas {
+		files = append(files, NewAreaFile(area, roomVnums, mobileVnums, objectVnums))
+	}
+	return files, nil
+}
+
+// VnumIDs maps vnums to database IDs for rooms, mobiles, and objects
+type VnumIDs struct {
+	Rooms   map[int]int
+	Mobiles map[int]int
+	Objects map[int]int
+}
+
+func NewVnumIDs() *VnumIDs {
+	return &VnumIDs{
+		Rooms:   make(map[int]int),
+		Mobiles: make(map[int]int),
+		Objects: make(map[int]int),
+	}
+}
+
+// LoadVnumIDs finds the IDs of everything with a vnum that is already in
+// the database
+func LoadVnumIDs(tx *sql.Tx) (*VnumIDs, error) {
+	ids := NewVnumIDs()
+	tables := map[string]map[int]int{
+		"rooms":   ids.Rooms,
+		"mobiles": ids.Mobiles,
+		"objects": ids.Objects,
+	}
+	for table, m := range tables {
+		rows, err := tx.Query(`SELECT id, vnum FROM ` + table + ` WHERE vnum IS NOT NULL`)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s vnums: %v", table, err)
+		}
+		for rows.Next() {
+			var id, vnum int
+			if err := rows.Scan(&id, &vnum); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("loading %s vnums: %v", table, err)
+			}
+			m[vnum] = id
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// InsertArea stores an area with its helps, rooms, mobiles, and objects,
+// assigning new IDs and recording them by vnum. Doors and resets are
+// left for InsertDoorsAndResets, since they can refer to other areas
+// that have not been stored yet. If the area already has an ID, the area
+// record is updated instead, and it should already be empty.
+func InsertArea(tx *sql.Tx, area *Area, ids *VnumIDs) error {
+	if err := saveArea(tx, area, NewVnumIDs()); err != nil {
+		return err
+	}
+	for _, room := range area.Rooms {
+		ids.Rooms[room.Vnum] = room.ID
+	}
+	for _, mob := range area.Mobiles {
+		ids.Mobiles[mob.Vnum] = mob.ID
+	}
+	for _, object := range area.Objects {
+		ids.Objects[object.Vnum] = object.ID
+	}
+	return nil
+}
+
+// saveArea stores an area with its helps, rooms, mobiles, and objects.
+// Those with a vnum in stored take over that record, and the rest are
+// inserted with new IDs.
+func saveArea(tx *sql.Tx, area *Area, stored *VnumIDs) error {
+	if err := upsert(tx, "areas", area, area.ID); err != nil {
+		return fmt.Errorf("storing area %q: %v", area.Name, err)
+	}
+	for _, help := range area.Helps {
+		help.ID = 0
+		help.AreaID = area.ID
+		if err := meddler.Insert(tx, "helps", help); err != nil {
+			return fmt.Errorf("inserting help in area %q: %v", area.Name, err)
+		}
+	}
+	for _, room := range area.Rooms {
+		room.ID, room.AreaID = stored.Rooms[room.Vnum], area.ID
+		if err := upsert(tx, "rooms", room, room.ID); err != nil {
+			return fmt.Errorf("storing room %d: %v", room.Vnum, err)
+		}
+	}
+	for _, mob := range area.Mobiles {
+		mob.ID, mob.AreaID = stored.Mobiles[mob.Vnum], area.ID
+		if err := upsert(tx, "mobiles", mob, mob.ID); err != nil {
+			return fmt.Errorf("storing mobile %d: %v", mob.Vnum, err)
+		}
+	}
+	for _, object := range area.Objects {
+		object.ID, object.AreaID = stored.Objects[object.Vnum], area.ID
+		if err := upsert(tx, "objects", object, object.ID); err != nil {
+			return fmt.Errorf("storing object %d: %v", object.Vnum, err)
+		}
+	}
+	return nil
+}
+
+// upsert updates a record that already has an ID and inserts one that
+// does not
+func upsert(tx *sql.Tx, table string, elt interface{}, id int) error {
+	if id != 0 {
+		return meddler.Update(tx, table, elt)
+	}
+	return meddler.Insert(tx, table, elt)
+}
+
+// InsertDoorsAndResets stores the doors and resets of an area that has
+// already been stored by InsertArea. References must already be mapped
+// from vnums to IDs.
+func InsertDoorsAndResets(tx *sql.Tx, area *Area) error {
+	for _, room := range area.Rooms {
+		for _, door := range room.Doors {
+			door.ID = 0
+			door.RoomID = room.ID
+			if err := meddler.Insert(tx, "doors", &door); err != nil {
+				return fmt.Errorf("inserting exit from room %d: %v", room.Vnum, err)
+			}
+		}
+	}
+	for _, reset := range area.Resets {
+		reset.ID = 0
+		reset.AreaID = area.ID
+		if err := meddler.Insert(tx, "resets", reset); err != nil {
+			return fmt.Errorf("inserting reset %d in area %q: %v", reset.Sequence, area.Name, err)
+		}
+	}
+	return nil
+}
+
+// WriteAreasSQL stores a set of area files in the database in a single
+// transaction. An existing area with the same name is updated in place,
+// so it keeps its ID and its authors. Its rooms, mobiles, and objects
+// keep their IDs too when their vnums are still there. Exits and resets
+// in other areas that lead to them are therefore left alone. Anything
+// that is gone is deleted, along with the exits and resets that refer to
+// it. Exits and resets may refer to vnums in this set or to vnums
+// already in the database.
+func WriteAreasSQL(db *sql.DB, files []*AreaFile) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// find the areas being replaced
+	existing := make(map[string]*Area)
+	replaced := make(map[int]bool)
+	for _, file := range files {
+		area := new(Area)
+		err := meddler.QueryRow(tx, area, `SELECT * FROM areas WHERE name = ?`, file.Name)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("loading old area %q: %v", file.Name, err)
+		}
+		existing[file.Name] = area
+		replaced[area.ID] = true
+	}
+
+	// convert the files, which use vnums for IDs
+	now := time.Now()
+	var areas []*Area
+	keep := map[string]map[int]bool{"rooms": {}, "mobiles": {}, "objects": {}}
+	for _, file := range files {
+		area := file.Area()
+		area.CreatedAt = now
+		if prev := existing[file.Name]; prev != nil {
+			area.ID = prev.ID
+			area.CreatedAt = prev.CreatedAt
+		}
+		area.ModifiedAt = now
+		for _, elt := range area.Rooms {
+			keep["rooms"][elt.Vnum] = true
+		}
+		for _, elt := range area.Mobiles {
+			keep["mobiles"][elt.Vnum] = true
+		}
+		for _, elt := range area.Objects {
+			keep["objects"][elt.Vnum] = true
+		}
+		areas = append(areas, area)
+	}
+
+	// match what is already stored by vnum, and delete what is gone from
+	// the replaced areas. Deleting a room also deletes the exits that lead
+	// to it and the resets that use it.
+	stored := NewVnumIDs()
+	tables := []struct {
+		table, kind string
+		ids         map[int]int
+	}{
+		{"rooms", "room", stored.Rooms},
+		{"mobiles", "mobile", stored.Mobiles},
+		{"objects", "object", stored.Objects},
+	}
+	for _, elt := range tables {
+		rows, err := tx.Query(`SELECT id, COALESCE(vnum, 0), area_id FROM ` + elt.table)
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", elt.table, err)
+		}
+		var gone []int
+		for rows.Next() {
+			var id, vnum, areaID int
+			if err := rows.Scan(&id, &vnum, &areaID); err != nil {
+				rows.Close()
+				return fmt.Errorf("loading %s: %v", elt.table, err)
+			}
+			switch {
+			case keep[elt.table][vnum] && !replaced[areaID]:
+				rows.Close()
+				return fmt.Errorf("%s vnum %d is already used by another area", elt.kind, vnum)
+			case keep[elt.table][vnum]:
+				elt.ids[vnum] = id
+			case replaced[areaID]:
+				gone = append(gone, id)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		for _, id := range gone {
+			if _, err := tx.Exec(`DELETE FROM `+elt.table+` WHERE id = ?`, id); err != nil {
+				return fmt.Errorf("deleting old %s: %v", elt.kind, err)
+			}
+		}
+	}
+
+	// helps, resets, and exits leading out of the replaced areas are
+	// stored again from the new versions
+	for id := range replaced {
+		for _, query := range []string{
+			`DELETE FROM helps WHERE area_id = ?`,
+			`DELETE FROM resets WHERE area_id = ?`,
+			`DELETE FROM doors WHERE room_id IN (SELECT id FROM rooms WHERE area_id = ?)`,
+		} {
+			if _, err := tx.Exec(query, id); err != nil {
+				return fmt.Errorf("emptying old area: %v", err)
+			}
+		}
+	}
+
+	// first pass: areas and everything that is not a reference
+	for _, area := range areas {
+		if err := saveArea(tx, area, stored); err != nil {
+			return err
+		}
+	}
+	ids, err := LoadVnumIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	// second pass: doors and resets, with vnums mapped to IDs
+	lookup := func(kind string, m map[int]int, vnum int) (int, error) {
+		if vnum == 0 {
+			return 0, nil
+		}
+		id, exists := m[vnum]
+		if !exists {
+			return 0, fmt.Errorf("unknown %s vnum %d", kind, vnum)
+		}
+		return id, nil
+	}
+	for _, area := range areas {
+		for _, room := range area.Rooms {
+			for i := range room.Doors {
+				door := &room.Doors[i]
+				if door.ToRoom, err = lookup("room", ids.Rooms, door.ToRoom); err != nil {
+					return fmt.Errorf("exit from room %d: %v", room.Vnum, err)
+				}
+				if door.Key, err = lookup("object", ids.Objects, door.Key); err != nil {
+					return fmt.Errorf("exit from room %d: %v", room.Vnum, err)
+				}
+			}
+		}
+		for _, reset := range area.Resets {
+			if reset.RoomID, err = lookup("room", ids.Rooms, reset.RoomID); err == nil {
+				if reset.MobileID, err = lookup("mobile", ids.Mobiles, reset.MobileID); err == nil {
+					if reset.ObjectID, err = lookup("object", ids.Objects, reset.ObjectID); err == nil {
+						reset.ContainerID, err = lookup("object", ids.Objects, reset.ContainerID)
+					}
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("reset %d in area %q: %v", reset.Sequence, area.Name, err)
+			}
+		}
+		if err := InsertDoorsAndResets(tx, area); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,417 @@
+package world
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/russross/meddler"
+)
+
+// openTestDB creates a database in a temporary directory and applies
+// every up migration to it
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", path+"?_loc=auto&_foreign_keys=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	scripts, err := filepath.Glob("../setup/migrations/*.up.sql")
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("finding migrations: %v", err)
+	}
+	sort.Strings(scripts)
+	for _, script := range scripts {
+		raw, err := ioutil.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(raw)); err != nil {
+			t.Fatalf("%s: %v", script, err)
+		}
+	}
+	return db
+}
+
+// checkColumns makes sure the columns of a table and the meddler tags of
+// a struct name the same things
+func checkColumns(t *testing.T, db *sql.DB, table string, elt interface{}) {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, name)
+	}
+
+	var fields []string
+	kind := reflect.TypeOf(elt).Elem()
+	for i := 0; i < kind.NumField(); i++ {
+		tag := kind.Field(i).Tag.Get("meddler")
+		if tag != "-" {
+			fields = append(fields, strings.Split(tag, ",")[0])
+		}
+	}
+	sort.Strings(columns)
+	sort.Strings(fields)
+	if !reflect.DeepEqual(columns, fields) {
+		t.Errorf("%s has columns %v but %T has fields for %v", table, columns, elt, fields)
+	}
+}
+
+// roundTrip inserts a record, loads it back, and compares every field
+func roundTrip(t *testing.T, db *sql.DB, table string, src, dst interface{}, id *int) {
+	t.Helper()
+	checkColumns(t, db, table, src)
+	if err := meddler.Insert(db, table, src); err != nil {
+		t.Fatalf("inserting into %s: %v", table, err)
+	}
+	if *id == 0 {
+		t.Fatalf("inserting into %s did not set the ID", table)
+	}
+	if err := meddler.Load(db, table, dst, int64(*id)); err != nil {
+		t.Fatalf("loading from %s: %v", table, err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Errorf("%s did not round trip:\nwrote %+v\nread  %+v", table, src, dst)
+	}
+}
+
+func rawColumn(t *testing.T, db *sql.DB, table, column string, id int) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := db.QueryRow(`SELECT `+column+` FROM `+table+` WHERE id = ?`, id).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if raw, ok := value.([]byte); ok {
+		return string(raw)
+	}
+	return value
+}
+
+func bits(list ...uint) BitSet {
+	var set BitSet
+	for _, i := range list {
+		set.Set(i)
+	}
+	return set
+}
+
+func TestColumnMappings(t *testing.T) {
+	db := openTestDB(t)
+
+	when := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	area := &Area{Name: "Test", CreatedAt: when, ModifiedAt: when.Add(time.Hour)}
+	checkColumns(t, db, "areas", area)
+	if err := meddler.Insert(db, "areas", area); err != nil {
+		t.Fatal(err)
+	}
+	loadedArea := new(Area)
+	if err := meddler.Load(db, "areas", loadedArea, int64(area.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if loadedArea.Name != area.Name || !loadedArea.CreatedAt.Equal(area.CreatedAt) || !loadedArea.ModifiedAt.Equal(area.ModifiedAt) {
+		t.Errorf("area did not round trip:\nwrote %+v\nread  %+v", area, loadedArea)
+	}
+
+	help := &Help{AreaID: area.ID, Level: 5, Keywords: []string{"test", "help"}, Text: "Some help.\n"}
+	roundTrip(t, db, "helps", help, new(Help), &help.ID)
+
+	mobile := &Mobile{
+		AreaID:           area.ID,
+		Vnum:             3000,
+		Keywords:         []string{"wizard", "old"},
+		ShortDescription: "the wizard",
+		LongDescription:  "A wizard stands here.\n",
+		Description:      "He looks old.\n",
+		ActionFlags:      ActFlags{bits(0, 6, 40)},
+		AffectedFlags:    AffectFlags{bits(3, 31, 32)},
+		Alignment:        -350,
+		Level:            33,
+		HitBonus:         20,
+		Armor:            -10,
+		HitRoll:          []int{1, 2},
+		DamageRoll:       []int{3, 4},
+		DodgeRoll:        []int{5, 6},
+		AbsorbRoll:       []int{7, 8},
+		FireRoll:         []int{9, 10},
+		IceRoll:          []int{11, 12},
+		PoisonRoll:       []int{13, 14},
+		LightningRoll:    []int{15, 16},
+		Gold:             1000,
+		Experience:       5000,
+		Pronouns:         "she",
+		StartPosition:    "sleeping",
+		DefaultPosition:  "standing",
+	}
+	roundTrip(t, db, "mobiles", mobile, new(Mobile), &mobile.ID)
+	if raw := rawColumn(t, db, "mobiles", "action_flags", mobile.ID); raw != "0x10000000041" {
+		t.Errorf("action flags stored as %#v", raw)
+	}
+	if raw := rawColumn(t, db, "mobiles", "affected_flags", mobile.ID); raw != "0x180000008" {
+		t.Errorf("affected flags stored as %#v", raw)
+	}
+	if raw := rawColumn(t, db, "mobiles", "keywords", mobile.ID); raw != `["wizard","old"]` {
+		t.Errorf("keywords stored as %#v", raw)
+	}
+
+	object := &Object{
+		AreaID:            area.ID,
+		Vnum:              3001,
+		Keywords:          []string{"sword"},
+		ShortDescription:  "a sword",
+		LongDescription:   "A sword lies here.\n",
+		ActionDescription: "It gleams.\n",
+		ItemType:          5,
+		ExtraFlags:        ExtraFlags{bits(1, 2)},
+		WearFlags:         WearFlags{bits(0, 13)},
+		Value0:            1,
+		Value1:            2,
+		Value2:            3,
+		Value3:            4,
+		Value4:            5,
+		Value5:            6,
+		Weight:            7,
+		Cost:              8,
+		CostPerDay:        9,
+		Extras:            []ObjectExtraDescription{{Keywords: []string{"blade"}, Description: "Sharp.\n"}},
+		Applies:           []ObjectApply{{Type: 18, Value: 2}},
+	}
+	roundTrip(t, db, "objects", object, new(Object), &object.ID)
+	if raw := rawColumn(t, db, "objects", "wear_flags", object.ID); raw != "0x2001" {
+		t.Errorf("wear flags stored as %#v", raw)
+	}
+
+	var rooms []*Room
+	for i, vnum := range []int{3002, 3003} {
+		room := &Room{
+			AreaID:      area.ID,
+			Vnum:        vnum,
+			Name:        "A room",
+			Description: "It is a room.\n",
+			Flags:       RoomFlags{bits(uint(RoomDark), uint(RoomNoRecall))},
+			Terrain:     TerrainCity + i,
+			Extras:      []RoomExtraDescription{{Keywords: []string{"sign"}, Description: "It says hello.\n"}},
+		}
+		roundTrip(t, db, "rooms", room, new(Room), &room.ID)
+		rooms = append(rooms, room)
+	}
+	if raw := rawColumn(t, db, "rooms", "flags", rooms[0].ID); raw != "0x2001" {
+		t.Errorf("room flags stored as %#v", raw)
+	}
+
+	door := &Door{
+		RoomID:      rooms[0].ID,
+		Direction:   DirNorth,
+		Description: "A door.\n",
+		Keywords:    []string{"door"},
+		Lock:        1,
+		Key:         object.ID,
+		ToRoom:      rooms[1].ID,
+	}
+	roundTrip(t, db, "doors", door, new(Door), &door.ID)
+	plain := &Door{RoomID: rooms[1].ID, Direction: DirSouth, Keywords: []string{}, ToRoom: rooms[0].ID}
+	roundTrip(t, db, "doors", plain, new(Door), &plain.ID)
+	if raw := rawColumn(t, db, "doors", "key", plain.ID); raw != nil {
+		t.Errorf("a door with no key stored key %#v", raw)
+	}
+
+	resets := []*Reset{
+		{Type: "M", AreaID: area.ID, Sequence: 1, RoomID: rooms[0].ID, MobileID: mobile.ID, MaxInstances: 2, Comment: "the wizard"},
+		{Type: "E", AreaID: area.ID, Sequence: 2, ObjectID: object.ID, WearLocation: 16, MaxInstances: 1},
+		{Type: "P", AreaID: area.ID, Sequence: 3, ObjectID: object.ID, ContainerID: object.ID},
+		{Type: "D", AreaID: area.ID, Sequence: 4, RoomID: rooms[0].ID, DoorDirection: DirNorth, DoorState: 2, LastDoor: 1},
+	}
+	for _, reset := range resets {
+		roundTrip(t, db, "resets", reset, new(Reset), &reset.ID)
+	}
+	if raw := rawColumn(t, db, "resets", "mobile_id", resets[1].ID); raw != nil {
+		t.Errorf("a reset with no mobile stored mobile_id %#v", raw)
+	}
+}
+
+// linkedAreas gives two areas where the second has an exit into the
+// first and resets that use its mobile and object
+func linkedAreas() (*AreaFile, *AreaFile) {
+	a := &AreaFile{
+		Version: 1,
+		Name:    "A",
+		Helps:   []*HelpDef{{Keywords: []string{"a"}, Text: "Area A.\n"}},
+		Mobiles: []*MobileDef{{Vnum: 100, Keywords: []string{"guard"}, ShortDescription: "a guard",
+			Pronouns: "they", StartPosition: "standing", DefaultPosition: "standing"}},
+		Objects: []*ObjectDef{{Vnum: 100, Keywords: []string{"key"}, ShortDescription: "a key"}},
+		Rooms: []*RoomDef{
+			{Vnum: 100, Name: "Gate", Terrain: "city", Exits: []*ExitDef{{Direction: "north", To: 101}, {Direction: "south", To: 200}}},
+			{Vnum: 101, Name: "Yard", Terrain: "city", Exits: []*ExitDef{{Direction: "south", To: 100}}},
+		},
+		Resets: []*ResetDef{{Type: "M", Room: 101, Mobile: 100, MaxInstances: 1}},
+	}
+	b := &AreaFile{
+		Version: 1,
+		Name:    "B",
+		Rooms: []*RoomDef{
+			{Vnum: 200, Name: "Road", Terrain: "field", Exits: []*ExitDef{{Direction: "north", To: 100}, {Direction: "east", To: 101, Key: 100}}},
+		},
+		Resets: []*ResetDef{
+			{Type: "M", Room: 200, Mobile: 100, MaxInstances: 2},
+			{Type: "G", Object: 100},
+		},
+	}
+	return a, b
+}
+
+// exits lists every exit in the database as "from direction to", by
+// vnum and in sorted order
+func exits(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT src.vnum, doors.direction, dst.vnum FROM doors ` +
+		`JOIN rooms AS src ON doors.room_id = src.id JOIN rooms AS dst ON doors.to_room = dst.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var from, direction, to int
+		if err := rows.Scan(&from, &direction, &to); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, fmt.Sprintf("%d %s %d", from, Directions[direction], to))
+	}
+	sort.Strings(list)
+	return list
+}
+
+func countRows(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWriteAreasSQLKeepsLinks(t *testing.T) {
+	db := openTestDB(t)
+	a, b := linkedAreas()
+	if err := WriteAreasSQL(db, []*AreaFile{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := loadVnumIDs(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"100 north 101", "100 south 200", "101 south 100", "200 north 100", "200 east 101"}
+	sort.Strings(all)
+	if got := exits(t, db); !reflect.DeepEqual(got, all) {
+		t.Fatalf("exits after the first save: %q", got)
+	}
+
+	// saving A again on its own must leave B alone
+	if err := WriteAreasSQL(db, []*AreaFile{a}); err != nil {
+		t.Fatal(err)
+	}
+	if got := exits(t, db); !reflect.DeepEqual(got, all) {
+		t.Errorf("exits after saving A again: %q", got)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM resets WHERE area_id = (SELECT id FROM areas WHERE name = 'B')`); n != 2 {
+		t.Errorf("B has %d resets after saving A again, expected 2", n)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM helps`); n != 1 {
+		t.Errorf("found %d helps after saving A again, expected 1", n)
+	}
+	again, err := loadVnumIDs(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, ids) {
+		t.Errorf("IDs changed from %+v to %+v", ids, again)
+	}
+
+	// rename the gate, drop the yard, and add a tower
+	a.Rooms[0].Name = "Old gate"
+	a.Rooms[0].Exits = []*ExitDef{{Direction: "south", To: 200}, {Direction: "up", To: 102}}
+	a.Rooms[1] = &RoomDef{Vnum: 102, Name: "Tower", Terrain: "inside", Exits: []*ExitDef{{Direction: "down", To: 100}}}
+	a.Resets = nil
+	if err := WriteAreasSQL(db, []*AreaFile{a}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"100 south 200", "100 up 102", "102 down 100", "200 north 100"}
+	sort.Strings(expected)
+	if got := exits(t, db); !reflect.DeepEqual(got, expected) {
+		t.Errorf("exits after changing A: %q, expected %q", got, expected)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT name FROM rooms WHERE id = ?`, ids.Rooms[100]).Scan(&name); err != nil || name != "Old gate" {
+		t.Errorf("the gate was not updated in place: %q, %v", name, err)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM rooms WHERE vnum = 101`); n != 0 {
+		t.Errorf("the yard was not deleted")
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM resets WHERE area_id = (SELECT id FROM areas WHERE name = 'B')`); n != 2 {
+		t.Errorf("B has %d resets after changing A, expected 2", n)
+	}
+
+	// saving B must leave A alone
+	b.Rooms[0].Exits = b.Rooms[0].Exits[:1]
+	if err := WriteAreasSQL(db, []*AreaFile{b}); err != nil {
+		t.Fatal(err)
+	}
+	if got := exits(t, db); !reflect.DeepEqual(got, expected) {
+		t.Errorf("exits after saving B: %q, expected %q", got, expected)
+	}
+
+	// an exit with no destination is stored as NULL and comes back
+	// without one
+	b.Rooms[0].Exits = append(b.Rooms[0].Exits, &ExitDef{Direction: "west", Description: "Fog.\n"})
+	if err := WriteAreasSQL(db, []*AreaFile{b}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM doors WHERE to_room IS NULL`); n != 1 {
+		t.Errorf("found %d exits with no destination, expected 1", n)
+	}
+	files, err := ReadAreasSQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name == "B" && (len(file.Rooms[0].Exits) != 2 || file.Rooms[0].Exits[1].To != 0) {
+			t.Errorf("B read back with exits %+v", file.Rooms[0].Exits)
+		}
+	}
+
+	// a vnum that belongs to another area is refused
+	b.Rooms = append(b.Rooms, &RoomDef{Vnum: 102, Name: "Stolen", Terrain: "inside"})
+	if err := WriteAreasSQL(db, []*AreaFile{b}); err == nil {
+		t.Errorf("saving a room with a vnum from another area gave no error")
+	}
+	if got := exits(t, db); !reflect.DeepEqual(got, expected) {
+		t.Errorf("exits after a failed save: %q, expected %q", got, expected)
+	}
+}
+
+func loadVnumIDs(db *sql.DB) (*VnumIDs, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return LoadVnumIDs(tx)
+}
@@ -0,0 +1,616 @@
+package world
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AreaFileVersion is the current version of the text area format.
+// See areas.md for a description of the format.
+const AreaFileVersion = 1
+
+// An AreaFile is an area in the text format we keep in git. Everything
+// refers to rooms, mobiles, and objects by vnum, never by database ID.
+type AreaFile struct {
+	Version int          `json:"version" yaml:"version"`
+	Name    string       `json:"name" yaml:"name"`
+	Helps   []*HelpDef   `json:"helps,omitempty" yaml:"helps,omitempty"`
+	Mobiles []*MobileDef `json:"mobiles,omitempty" yaml:"mobiles,omitempty"`
+	Objects []*ObjectDef `json:"objects,omitempty" yaml:"objects,omitempty"`
+	Rooms   []*RoomDef   `json:"rooms,omitempty" yaml:"rooms,omitempty"`
+	Resets  []*ResetDef  `json:"resets,omitempty" yaml:"resets,omitempty"`
+}
+
+type HelpDef struct {
+	Level    int      `json:"level" yaml:"level"`
+	Keywords []string `json:"keywords" yaml:"keywords"`
+	Text     string   `json:"text" yaml:"text"`
+}
+
+type MobileDef struct {
+	Vnum             int         `json:"vnum" yaml:"vnum"`
+	Keywords         []string    `json:"keywords" yaml:"keywords"`
+	ShortDescription string      `json:"short" yaml:"short"`
+	LongDescription  string      `json:"long" yaml:"long"`
+	Description      string      `json:"description" yaml:"description"`
+	ActionFlags      ActFlags    `json:"actionFlags" yaml:"actionFlags,omitempty,flow"`
+	AffectedFlags    AffectFlags `json:"affectedFlags" yaml:"affectedFlags,omitempty,flow"`
+	Alignment        int         `json:"alignment" yaml:"alignment"`
+	Level            int         `json:"level" yaml:"level"`
+	HitBonus         int         `json:"hitBonus,omitempty" yaml:"hitBonus,omitempty"`
+	Armor            int         `json:"armor,omitempty" yaml:"armor,omitempty"`
+	HitRoll          []int       `json:"hitRoll" yaml:"hitRoll,flow"`
+	DamageRoll       []int       `json:"damageRoll" yaml:"damageRoll,flow"`
+	DodgeRoll        []int       `json:"dodgeRoll" yaml:"dodgeRoll,flow"`
+	AbsorbRoll       []int       `json:"absorbRoll" yaml:"absorbRoll,flow"`
+	FireRoll         []int       `json:"fireRoll" yaml:"fireRoll,flow"`
+	IceRoll          []int       `json:"iceRoll" yaml:"iceRoll,flow"`
+	PoisonRoll       []int       `json:"poisonRoll" yaml:"poisonRoll,flow"`
+	LightningRoll    []int       `json:"lightningRoll" yaml:"lightningRoll,flow"`
+	Gold             int         `json:"gold,omitempty" yaml:"gold,omitempty"`
+	Experience       int         `json:"experience,omitempty" yaml:"experience,omitempty"`
+	Pronouns         string      `json:"pronouns" yaml:"pronouns"`
+	StartPosition    string      `json:"startPosition" yaml:"startPosition"`
+	DefaultPosition  string      `json:"defaultPosition" yaml:"defaultPosition"`
+}
+
+type ObjectDef struct {
+	Vnum              int                      `json:"vnum" yaml:"vnum"`
+	Keywords          []string                 `json:"keywords" yaml:"keywords"`
+	ShortDescription  string                   `json:"short" yaml:"short"`
+	LongDescription   string                   `json:"long" yaml:"long"`
+	ActionDescription string                   `json:"action,omitempty" yaml:"action,omitempty"`
+	ItemType          int                      `json:"itemType" yaml:"itemType"`
+	ExtraFlags        ExtraFlags               `json:"extraFlags" yaml:"extraFlags,omitempty,flow"`
+	WearFlags         WearFlags                `json:"wearFlags" yaml:"wearFlags,omitempty,flow"`
+	Values            []int                    `json:"values" yaml:"values,flow"`
+	Weight            int                      `json:"weight" yaml:"weight"`
+	Cost              int                      `json:"cost" yaml:"cost"`
+	CostPerDay        int                      `json:"costPerDay,omitempty" yaml:"costPerDay,omitempty"`
+	Extras            []ObjectExtraDescription `json:"extras,omitempty" yaml:"extras,omitempty"`
+	Applies           []ObjectApply            `json:"applies,omitempty" yaml:"applies,omitempty"`
+}
+
+type RoomDef struct {
+	Vnum        int                    `json:"vnum" yaml:"vnum"`
+	Name        string                 `json:"name" yaml:"name"`
+	Description string                 `json:"description" yaml:"description"`
+	Flags       RoomFlags              `json:"flags" yaml:"flags,omitempty,flow"`
+	Terrain     string                 `json:"terrain" yaml:"terrain"`
+	Extras      []RoomExtraDescription `json:"extras,omitempty" yaml:"extras,omitempty"`
+	Exits       []*ExitDef             `json:"exits,omitempty" yaml:"exits,omitempty"`
+}
+
+type ExitDef struct {
+	Direction   string   `json:"direction" yaml:"direction"`
+	To          int      `json:"to,omitempty" yaml:"to,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty" yaml:"keywords,omitempty"`
+	Lock        int      `json:"lock,omitempty" yaml:"lock,omitempty"`
+	Key         int      `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+type ResetDef struct {
+	Type         string `json:"type" yaml:"type"`
+	Room         int    `json:"room,omitempty" yaml:"room,omitempty"`
+	Mobile       int    `json:"mobile,omitempty" yaml:"mobile,omitempty"`
+	Object       int    `json:"object,omitempty" yaml:"object,omitempty"`
+	Container    int    `json:"container,omitempty" yaml:"container,omitempty"`
+	WearLocation int    `json:"wearLocation,omitempty" yaml:"wearLocation,omitempty"`
+	MaxInstances int    `json:"max,omitempty" yaml:"max,omitempty"`
+	Door         string `json:"door,omitempty" yaml:"door,omitempty"`
+	DoorState    int    `json:"doorState,omitempty" yaml:"doorState,omitempty"`
+	LastDoor     int    `json:"lastDoor,omitempty" yaml:"lastDoor,omitempty"`
+	Comment      string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+func ReadAreaFile(path string) (*AreaFile, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := new(AreaFile)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// reject unknown fields, as yaml.UnmarshalStrict does
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(file); err == nil && decoder.More() {
+			err = fmt.Errorf("unexpected data after the area")
+		}
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(raw, file)
+	default:
+		return nil, fmt.Errorf("%s: unknown area file extension", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if file.Version != AreaFileVersion {
+		return nil, fmt.Errorf("%s: unsupported area file version %d (expected %d)", path, file.Version, AreaFileVersion)
+	}
+	return file, nil
+}
+
+func WriteAreaFile(path string, file *AreaFile) error {
+	var raw []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		raw, err = json.MarshalIndent(file, "", "    ")
+		raw = append(raw, '\n')
+	case ".yaml", ".yml":
+		raw, err = yaml.Marshal(file)
+	default:
+		return fmt.Errorf("%s: unknown area file extension", path)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// Area converts an area file into an Area. Since there is no database to
+// assign IDs, every room, mobile, and object uses its vnum as its ID, and
+// doors and resets refer to vnums as well.
+func (file *AreaFile) Area() *Area {
+	area := &Area{Name: file.Name}
+	for _, elt := range file.Helps {
+		area.Helps = append(area.Helps, &Help{
+			Level:    elt.Level,
+			Keywords: elt.Keywords,
+			Text:     elt.Text,
+		})
+	}
+	for _, elt := range file.Mobiles {
+		area.Mobiles = append(area.Mobiles, &Mobile{
+			ID:               elt.Vnum,
+			Vnum:             elt.Vnum,
+			Keywords:         elt.Keywords,
+			ShortDescription: elt.ShortDescription,
+			LongDescription:  elt.LongDescription,
+			Description:      elt.Description,
+			ActionFlags:      elt.ActionFlags,
+			AffectedFlags:    elt.AffectedFlags,
+			Alignment:        elt.Alignment,
+			Level:            elt.Level,
+			HitBonus:         elt.HitBonus,
+			Armor:            elt.Armor,
+			HitRoll:          elt.HitRoll,
+			DamageRoll:       elt.DamageRoll,
+			DodgeRoll:        elt.DodgeRoll,
+			AbsorbRoll:       elt.AbsorbRoll,
+			FireRoll:         elt.FireRoll,
+			IceRoll:          elt.IceRoll,
+			PoisonRoll:       elt.PoisonRoll,
+			LightningRoll:    elt.LightningRoll,
+			Gold:             elt.Gold,
+			Experience:       elt.Experience,
+			Pronouns:         elt.Pronouns,
+			StartPosition:    elt.StartPosition,
+			DefaultPosition:  elt.DefaultPosition,
+		})
+	}
+	for _, elt := range file.Objects {
+		values := make([]int, 6)
+		copy(values, elt.Values)
+		area.Objects = append(area.Objects, &Object{
+			ID:                elt.Vnum,
+			Vnum:              elt.Vnum,
+			Keywords:          elt.Keywords,
+			ShortDescription:  elt.ShortDescription,
+			LongDescription:   elt.LongDescription,
+			ActionDescription: elt.ActionDescription,
+			ItemType:          elt.ItemType,
+			ExtraFlags:        elt.ExtraFlags,
+			WearFlags:         elt.WearFlags,
+			Value0:            values[0],
+			Value1:            values[1],
+			Value2:            values[2],
+			Value3:            values[3],
+			Value4:            values[4],
+			Value5:            values[5],
+			Weight:            elt.Weight,
+			Cost:              elt.Cost,
+			CostPerDay:        elt.CostPerDay,
+			Extras:            nonNilObjectExtras(elt.Extras),
+			Applies:           nonNilApplies(elt.Applies),
+		})
+	}
+	for _, elt := range file.Rooms {
+		room := &Room{
+			ID:          elt.Vnum,
+			Vnum:        elt.Vnum,
+			Name:        elt.Name,
+			Description: elt.Description,
+			Flags:       elt.Flags,
+			Terrain:     indexOf(Terrains, elt.Terrain),
+			Extras:      nonNilRoomExtras(elt.Extras),
+			Doors:       []Door{},
+		}
+		for _, exit := range elt.Exits {
+			room.Doors = append(room.Doors, Door{
+				RoomID:      room.ID,
+				Direction:   indexOf(Directions, exit.Direction),
+				Description: exit.Description,
+				Keywords:    nonNilStrings(exit.Keywords),
+				Lock:        exit.Lock,
+				Key:         exit.Key,
+				ToRoom:      exit.To,
+			})
+		}
+		area.Rooms = append(area.Rooms, room)
+	}
+	for i, elt := range file.Resets {
+		reset := &Reset{
+			Type:          resetLetter(elt.Type),
+			Sequence:      i + 1,
+			RoomID:        elt.Room,
+			MobileID:      elt.Mobile,
+			ObjectID:      elt.Object,
+			ContainerID:   elt.Container,
+			WearLocation:  elt.WearLocation,
+			MaxInstances:  elt.MaxInstances,
+			DoorDirection: 0,
+			DoorState:     elt.DoorState,
+			LastDoor:      elt.LastDoor,
+			Comment:       elt.Comment,
+		}
+		if elt.Door != "" {
+			reset.DoorDirection = indexOf(Directions, elt.Door)
+		}
+		area.Resets = append(area.Resets, reset)
+	}
+	return area
+}
+
+// NewAreaFile converts an Area into the text format. The maps give the
+// vnum for each room, mobile, and object ID, since doors and resets in
+// an Area loaded from the database refer to IDs.
+func NewAreaFile(area *Area, roomVnums, mobileVnums, objectVnums map[int]int) *AreaFile {
+	file := &AreaFile{Version: AreaFileVersion, Name: area.Name}
+	for _, elt := range area.Helps {
+		file.Helps = append(file.Helps, &HelpDef{
+			Level:    elt.Level,
+			Keywords: elt.Keywords,
+			Text:     elt.Text,
+		})
+	}
+	for _, elt := range area.Mobiles {
+		file.Mobiles = append(file.Mobiles, &MobileDef{
+			Vnum:             elt.Vnum,
+			Keywords:         elt.Keywords,
+			ShortDescription: elt.ShortDescription,
+			LongDescription:  elt.LongDescription,
+			Description:      elt.Description,
+			ActionFlags:      elt.ActionFlags,
+			AffectedFlags:    elt.AffectedFlags,
+			Alignment:        elt.Alignment,
+			Level:            elt.Level,
+			HitBonus:         elt.HitBonus,
+			Armor:            elt.Armor,
+			HitRoll:          elt.HitRoll,
+			DamageRoll:       elt.DamageRoll,
+			DodgeRoll:        elt.DodgeRoll,
+			AbsorbRoll:       elt.AbsorbRoll,
+			FireRoll:         elt.FireRoll,
+			IceRoll:          elt.IceRoll,
+			PoisonRoll:       elt.PoisonRoll,
+			LightningRoll:    elt.LightningRoll,
+			Gold:             elt.Gold,
+			Experience:       elt.Experience,
+			Pronouns:         elt.Pronouns,
+			StartPosition:    elt.StartPosition,
+			DefaultPosition:  elt.DefaultPosition,
+		})
+	}
+	for _, elt := range area.Objects {
+		file.Objects = append(file.Objects, &ObjectDef{
+			Vnum:              elt.Vnum,
+			Keywords:          elt.Keywords,
+			ShortDescription:  elt.ShortDescription,
+			LongDescription:   elt.LongDescription,
+			ActionDescription: elt.ActionDescription,
+			ItemType:          elt.ItemType,
+			ExtraFlags:        elt.ExtraFlags,
+			WearFlags:         elt.WearFlags,
+			Values:            []int{elt.Value0, elt.Value1, elt.Value2, elt.Value3, elt.Value4, elt.Value5},
+			Weight:            elt.Weight,
+			Cost:              elt.Cost,
+			CostPerDay:        elt.CostPerDay,
+			Extras:            elt.Extras,
+			Applies:           elt.Applies,
+		})
+	}
+	for _, elt := range area.Rooms {
+		room := &RoomDef{
+			Vnum:        elt.Vnum,
+			Name:        elt.Name,
+			Description: elt.Description,
+			Flags:       elt.Flags,
+			Terrain:     nameOf(Terrains, elt.Terrain),
+			Extras:      elt.Extras,
+		}
+		for _, door := range elt.Doors {
+			room.Exits = append(room.Exits, &ExitDef{
+				Direction:   nameOf(Directions, door.Direction),
+				To:          roomVnums[door.ToRoom],
+				Description: door.Description,
+				Keywords:    door.Keywords,
+				Lock:        door.Lock,
+				Key:         objectVnums[door.Key],
+			})
+		}
+		file.Rooms = append(file.Rooms, room)
+	}
+	resets := append([]*Reset{}, area.Resets...)
+	sort.SliceStable(resets, func(a, b int) bool { return resets[a].Sequence < resets[b].Sequence })
+	for _, elt := range resets {
+		reset := &ResetDef{
+			Type:         ResetTypes[elt.Type],
+			Room:         roomVnums[elt.RoomID],
+			Mobile:       mobileVnums[elt.MobileID],
+			Object:       objectVnums[elt.ObjectID],
+			Container:    objectVnums[elt.ContainerID],
+			WearLocation: elt.WearLocation,
+			MaxInstances: elt.MaxInstances,
+			DoorState:    elt.DoorState,
+			LastDoor:     elt.LastDoor,
+			Comment:      elt.Comment,
+		}
+		if reset.Type == "" {
+			reset.Type = elt.Type
+		}
+		if elt.Type == "D" {
+			reset.Door = nameOf(Directions, elt.DoorDirection)
+		}
+		file.Resets = append(file.Resets, reset)
+	}
+	return file
+}
+
+// ValidateAreaFiles checks a set of area files together, so exits and
+// resets can refer to rooms, mobiles, and objects in any of them. It
+// returns a list of problems, each prefixed by the file it was found in.
+func ValidateAreaFiles(paths []string, files []*AreaFile) []string {
+	var problems []string
+	rooms := make(map[int]*RoomDef)
+	mobiles := make(map[int]bool)
+	objects := make(map[int]bool)
+
+	// first pass: everything that can be checked one file at a time
+	for i, file := range files {
+		path := paths[i]
+		report := func(format string, params ...interface{}) {
+			problems = append(problems, path+": "+fmt.Sprintf(format, params...))
+		}
+
+		if strings.TrimSpace(file.Name) == "" {
+			report("area has no name")
+		}
+		for j, help := range file.Helps {
+			if help.Level < 0 || help.Level > 100 {
+				report("help %d: level %d must be between 0 and 100", j+1, help.Level)
+			}
+			if len(help.Keywords) == 0 {
+				report("help %d: no keywords", j+1)
+			}
+		}
+		for _, mob := range file.Mobiles {
+			where := fmt.Sprintf("mobile %d", mob.Vnum)
+			if mob.Vnum < 1 {
+				report("%s: vnum must be positive", where)
+			} else if mobiles[mob.Vnum] {
+				report("%s: duplicate vnum", where)
+			}
+			mobiles[mob.Vnum] = true
+			if len(mob.Keywords) == 0 {
+				report("%s: no keywords", where)
+			}
+			if mob.Alignment < -1000 || mob.Alignment > 1000 {
+				report("%s: alignment %d must be between -1000 and 1000", where, mob.Alignment)
+			}
+			if mob.Level < 0 || mob.Level > 100 {
+				report("%s: level %d must be between 0 and 100", where, mob.Level)
+			}
+			rolls := map[string][]int{
+				"hitRoll": mob.HitRoll, "damageRoll": mob.DamageRoll,
+				"dodgeRoll": mob.DodgeRoll, "absorbRoll": mob.AbsorbRoll,
+				"fireRoll": mob.FireRoll, "iceRoll": mob.IceRoll,
+				"poisonRoll": mob.PoisonRoll, "lightningRoll": mob.LightningRoll,
+			}
+			for name, roll := range rolls {
+				if len(roll) != 2 || roll[0] < 0 || roll[1] < 0 {
+					report("%s: %s must be a non-negative mean and standard deviation", where, name)
+				}
+			}
+			if indexOf(Pronouns, mob.Pronouns) < 0 {
+				report("%s: unknown pronouns %q", where, mob.Pronouns)
+			}
+			if indexOf(Positions, mob.StartPosition) < 0 {
+				report("%s: unknown start position %q", where, mob.StartPosition)
+			}
+			if indexOf(Positions, mob.DefaultPosition) < 0 {
+				report("%s: unknown default position %q", where, mob.DefaultPosition)
+			}
+		}
+		for _, obj := range file.Objects {
+			where := fmt.Sprintf("object %d", obj.Vnum)
+			if obj.Vnum < 1 {
+				report("%s: vnum must be positive", where)
+			} else if objects[obj.Vnum] {
+				report("%s: duplicate vnum", where)
+			}
+			objects[obj.Vnum] = true
+			if len(obj.Keywords) == 0 {
+				report("%s: no keywords", where)
+			}
+			if len(obj.Values) > 6 {
+				report("%s: at most 6 values are allowed", where)
+			}
+		}
+		for _, room := range file.Rooms {
+			where := fmt.Sprintf("room %d", room.Vnum)
+			if room.Vnum < 1 {
+				report("%s: vnum must be positive", where)
+			} else if rooms[room.Vnum] != nil {
+				report("%s: duplicate vnum", where)
+			}
+			rooms[room.Vnum] = room
+			if indexOf(Terrains, room.Terrain) < 0 {
+				report("%s: unknown terrain %q", where, room.Terrain)
+			}
+			seen := make(map[string]bool)
+			for _, exit := range room.Exits {
+				if indexOf(Directions, exit.Direction) < 0 {
+					report("%s: unknown exit direction %q", where, exit.Direction)
+				} else if seen[exit.Direction] {
+					report("%s: more than one exit %s", where, exit.Direction)
+				}
+				seen[exit.Direction] = true
+			}
+		}
+	}
+
+	// second pass: references between rooms, mobiles, and objects
+	for i, file := range files {
+		path := paths[i]
+		report := func(format string, params ...interface{}) {
+			problems = append(problems, path+": "+fmt.Sprintf(format, params...))
+		}
+
+		for _, room := range file.Rooms {
+			for _, exit := range room.Exits {
+				if exit.To != 0 && rooms[exit.To] == nil {
+					report("room %d: exit %s leads to unknown room %d", room.Vnum, exit.Direction, exit.To)
+				}
+				if exit.Key > 0 && !objects[exit.Key] {
+					report("room %d: exit %s needs unknown key object %d", room.Vnum, exit.Direction, exit.Key)
+				}
+			}
+		}
+
+		lastMobile := 0
+		for j, reset := range file.Resets {
+			where := fmt.Sprintf("reset %d (%s)", j+1, reset.Type)
+			needRoom := func() {
+				if rooms[reset.Room] == nil {
+					report("%s: unknown room %d", where, reset.Room)
+				}
+			}
+			needObject := func(vnum int) {
+				if !objects[vnum] {
+					report("%s: unknown object %d", where, vnum)
+				}
+			}
+			switch reset.Type {
+			case "comment", "trap", "bit":
+			case "mobile":
+				needRoom()
+				if !mobiles[reset.Mobile] {
+					report("%s: unknown mobile %d", where, reset.Mobile)
+				}
+				lastMobile = reset.Mobile
+			case "object":
+				needRoom()
+				needObject(reset.Object)
+			case "put":
+				needObject(reset.Object)
+				needObject(reset.Container)
+			case "give", "equip":
+				needObject(reset.Object)
+				if lastMobile == 0 {
+					report("%s: must follow a mobile reset", where)
+				}
+				if reset.Type == "equip" && (reset.WearLocation < -1 || reset.WearLocation > 18) {
+					report("%s: unknown wear location %d", where, reset.WearLocation)
+				}
+			case "hide":
+				needObject(reset.Object)
+			case "door":
+				needRoom()
+				if room := rooms[reset.Room]; room != nil {
+					found := false
+					for _, exit := range room.Exits {
+						found = found || exit.Direction == reset.Door
+					}
+					if !found {
+						report("%s: room %d has no exit %q", where, reset.Room, reset.Door)
+					}
+				}
+				if reset.DoorState < DoorOpen || reset.DoorState > DoorLocked {
+					report("%s: door state %d must be 0 (open), 1 (closed), or 2 (locked)", where, reset.DoorState)
+				}
+			case "randomize":
+				needRoom()
+				if reset.LastDoor < 0 || reset.LastDoor > len(Directions) {
+					report("%s: last door %d must be between 0 and %d", where, reset.LastDoor, len(Directions))
+				}
+			default:
+				report("%s: unknown reset type", where)
+			}
+		}
+	}
+
+	return problems
+}
+
+func resetLetter(name string) string {
+	for letter, elt := range ResetTypes {
+		if elt == name {
+			return letter
+		}
+	}
+	return name
+}
+
+func indexOf(list []string, name string) int {
+	for i, elt := range list {
+		if elt == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func nameOf(list []string, i int) string {
+	if i < 0 || i >= len(list) {
+		return fmt.Sprintf("%d", i)
+	}
+	return list[i]
+}
+
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func nonNilObjectExtras(list []ObjectExtraDescription) []ObjectExtraDescription {
+	if list == nil {
+		return []ObjectExtraDescription{}
+	}
+	return list
+}
+
+func nonNilApplies(list []ObjectApply) []ObjectApply {
+	if list == nil {
+		return []ObjectApply{}
+	}
+	return list
+}
+
+func nonNilRoomExtras(list []RoomExtraDescription) []RoomExtraDescription {
+	if list == nil {
+		return []RoomExtraDescription{}
+	}
+	return list
+}
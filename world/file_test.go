@@ -0,0 +1,62 @@
+package world
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAreaFileUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.json":  `{"version": 1, "name": "Test", "rooms": [{"vnum": 1, "name": "A room"}]}`,
+		"good.yaml":  "version: 1\nname: Test\nrooms:\n- vnum: 1\n  name: A room\n",
+		"typo.json":  `{"version": 1, "name": "Test", "rooms": [{"vnum": 1, "nmae": "A room"}]}`,
+		"typo.yaml":  "version: 1\nname: Test\nrooms:\n- vnum: 1\n  nmae: A room\n",
+		"extra.json": `{"version": 1, "name": "Test"} {"version": 1}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := ReadAreaFile(path)
+		if strings.HasPrefix(name, "good") {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if len(file.Rooms) != 1 || file.Rooms[0].Name != "A room" {
+				t.Errorf("%s: rooms read as %+v", name, file.Rooms)
+			}
+		} else if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestExitWithNoDestination(t *testing.T) {
+	file := &AreaFile{
+		Version: 1,
+		Name:    "Test",
+		Rooms: []*RoomDef{
+			{Vnum: 1, Name: "Hall", Terrain: "inside", Exits: []*ExitDef{
+				{Direction: "north", To: 2},
+				{Direction: "east", Description: "A painted door.\n", Keywords: []string{"door"}},
+			}},
+			{Vnum: 2, Name: "Study", Terrain: "inside", Exits: []*ExitDef{{Direction: "west", To: 3}}},
+		},
+		Resets: []*ResetDef{{Type: "door", Room: 1, Door: "east", DoorState: DoorClosed}},
+	}
+	problems := ValidateAreaFiles([]string{"test"}, []*AreaFile{file})
+	if len(problems) != 1 || !strings.Contains(problems[0], "unknown room 3") {
+		t.Errorf("expected only the exit to room 3 to be a problem, found %q", problems)
+	}
+
+	room := file.Area().Rooms[0]
+	if got := room.GetShortDescription(); got != "Hall\nExits [n]\n" {
+		t.Errorf("short description %q lists an exit that leads nowhere", got)
+	}
+	if room.Exit(make([]*Room, 3), 'e') != nil {
+		t.Errorf("an exit that leads nowhere found a room")
+	}
+}
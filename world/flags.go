@@ -0,0 +1,396 @@
+package world
+
+// Constants for the numbered and flag fields in areas. The values match
+// Merc area files, which is where most of our areas come from.
+
+const (
+	DirNorth int = iota
+	DirEast
+	DirSouth
+	DirWest
+	DirUp
+	DirDown
+)
+
+var Directions = []string{"north", "east", "south", "west", "up", "down"}
+
+// ReverseDirections gives the opposite of each direction
+var ReverseDirections = []int{DirSouth, DirWest, DirNorth, DirEast, DirDown, DirUp}
+
+const (
+	TerrainInside int = iota
+	TerrainCity
+	TerrainField
+	TerrainForest
+	TerrainHills
+	TerrainMountain
+	TerrainSwim
+	TerrainNoSwim
+	TerrainUnderwater
+	TerrainAir
+	TerrainDesert
+	TerrainDunno
+	TerrainOceanFloor
+	TerrainUnderground
+	TerrainLava
+	TerrainSwamp
+)
+
+var Terrains = []string{
+	"inside", "city", "field", "forest", "hills", "mountain", "swim", "noswim",
+	"underwater", "air", "desert", "dunno", "oceanfloor", "underground", "lava", "swamp",
+}
+
+// Positions are stored by name. This is also the Diku numbering used in
+// area files.
+var Positions = []string{
+	"dead", "mortal", "incapacitated", "stunned", "sleeping", "resting", "sitting", "fighting", "standing",
+}
+
+var Pronouns = []string{"he", "she", "it", "they"}
+
+// names for the reset types, which use single letters in the database
+var ResetTypes = map[string]string{
+	"*": "comment",
+	"M": "mobile",
+	"O": "object",
+	"P": "put",
+	"G": "give",
+	"E": "equip",
+	"D": "door",
+	"R": "randomize",
+	"T": "trap",
+	"H": "hide",
+	"B": "bit",
+}
+
+// room flags, numbered by bit
+type RoomFlag uint
+
+const (
+	RoomDark     RoomFlag = 0
+	RoomNoMob    RoomFlag = 2
+	RoomIndoors  RoomFlag = 3
+	RoomPrivate  RoomFlag = 9
+	RoomSafe     RoomFlag = 10
+	RoomSolitary RoomFlag = 11
+	RoomPetShop  RoomFlag = 12
+	RoomNoRecall RoomFlag = 13
+)
+
+var roomFlagNames = []string{
+	RoomDark:     "dark",
+	RoomNoMob:    "nomob",
+	RoomIndoors:  "indoors",
+	RoomPrivate:  "private",
+	RoomSafe:     "safe",
+	RoomSolitary: "solitary",
+	RoomPetShop:  "petshop",
+	RoomNoRecall: "norecall",
+}
+
+type RoomFlags struct{ BitSet }
+
+func (f *RoomFlags) Has(flag RoomFlag) bool { return f.IsSet(uint(flag)) }
+func (f *RoomFlags) Add(flag RoomFlag)      { f.Set(uint(flag)) }
+func (f *RoomFlags) Remove(flag RoomFlag)   { f.Clear(uint(flag)) }
+func (f RoomFlags) String() string          { return flagString(f.BitSet, roomFlagNames) }
+
+func (f RoomFlags) MarshalJSON() ([]byte, error) {
+	return marshalFlagsJSON(f.BitSet, roomFlagNames)
+}
+
+func (f RoomFlags) MarshalYAML() (interface{}, error) {
+	return flagNames(f.BitSet, roomFlagNames), nil
+}
+
+func (f *RoomFlags) UnmarshalJSON(raw []byte) (err error) {
+	f.BitSet, err = unmarshalFlagsJSON("room", raw, roomFlagNames)
+	return err
+}
+
+func (f *RoomFlags) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	f.BitSet, err = unmarshalFlagsYAML("room", unmarshal, roomFlagNames)
+	return err
+}
+
+func (f *RoomFlags) ToggleName(name string) (bool, error) {
+	return toggleFlagName(&f.BitSet, "room", name, roomFlagNames)
+}
+
+// mobile action flags, numbered by bit
+type ActFlag uint
+
+const (
+	ActIsNPC      ActFlag = 0
+	ActSentinel   ActFlag = 1
+	ActScavenger  ActFlag = 2
+	ActAggressive ActFlag = 5
+	ActStayArea   ActFlag = 6
+	ActWimpy      ActFlag = 7
+	ActPet        ActFlag = 8
+	ActTrain      ActFlag = 9
+	ActPractice   ActFlag = 10
+)
+
+var actFlagNames = []string{
+	ActIsNPC:      "npc",
+	ActSentinel:   "sentinel",
+	ActScavenger:  "scavenger",
+	ActAggressive: "aggressive",
+	ActStayArea:   "stayarea",
+	ActWimpy:      "wimpy",
+	ActPet:        "pet",
+	ActTrain:      "train",
+	ActPractice:   "practice",
+}
+
+type ActFlags struct{ BitSet }
+
+func (f *ActFlags) Has(flag ActFlag) bool { return f.IsSet(uint(flag)) }
+func (f *ActFlags) Add(flag ActFlag)      { f.Set(uint(flag)) }
+func (f *ActFlags) Remove(flag ActFlag)   { f.Clear(uint(flag)) }
+func (f ActFlags) String() string         { return flagString(f.BitSet, actFlagNames) }
+
+func (f ActFlags) MarshalJSON() ([]byte, error) {
+	return marshalFlagsJSON(f.BitSet, actFlagNames)
+}
+
+func (f ActFlags) MarshalYAML() (interface{}, error) {
+	return flagNames(f.BitSet, actFlagNames), nil
+}
+
+func (f *ActFlags) UnmarshalJSON(raw []byte) (err error) {
+	f.BitSet, err = unmarshalFlagsJSON("action", raw, actFlagNames)
+	return err
+}
+
+func (f *ActFlags) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	f.BitSet, err = unmarshalFlagsYAML("action", unmarshal, actFlagNames)
+	return err
+}
+
+func (f *ActFlags) ToggleName(name string) (bool, error) {
+	return toggleFlagName(&f.BitSet, "action", name, actFlagNames)
+}
+
+// mobile affected flags, numbered by bit
+type AffectFlag uint
+
+const (
+	AffBlind        AffectFlag = 0
+	AffInvisible    AffectFlag = 1
+	AffDetectEvil   AffectFlag = 2
+	AffDetectInvis  AffectFlag = 3
+	AffDetectMagic  AffectFlag = 4
+	AffDetectHidden AffectFlag = 5
+	AffHold         AffectFlag = 6
+	AffSanctuary    AffectFlag = 7
+	AffFaerieFire   AffectFlag = 8
+	AffInfrared     AffectFlag = 9
+	AffCurse        AffectFlag = 10
+	AffFlaming      AffectFlag = 11
+	AffPoison       AffectFlag = 12
+	AffProtect      AffectFlag = 13
+	AffParalysis    AffectFlag = 14
+	AffSneak        AffectFlag = 15
+	AffHide         AffectFlag = 16
+	AffSleep        AffectFlag = 17
+	AffCharm        AffectFlag = 18
+	AffFlying       AffectFlag = 19
+	AffPassDoor     AffectFlag = 20
+)
+
+var affectFlagNames = []string{
+	AffBlind:        "blind",
+	AffInvisible:    "invisible",
+	AffDetectEvil:   "detectevil",
+	AffDetectInvis:  "detectinvis",
+	AffDetectMagic:  "detectmagic",
+	AffDetectHidden: "detecthidden",
+	AffHold:         "hold",
+	AffSanctuary:    "sanctuary",
+	AffFaerieFire:   "faeriefire",
+	AffInfrared:     "infrared",
+	AffCurse:        "curse",
+	AffFlaming:      "flaming",
+	AffPoison:       "poison",
+	AffProtect:      "protect",
+	AffParalysis:    "paralysis",
+	AffSneak:        "sneak",
+	AffHide:         "hide",
+	AffSleep:        "sleep",
+	AffCharm:        "charm",
+	AffFlying:       "flying",
+	AffPassDoor:     "passdoor",
+}
+
+type AffectFlags struct{ BitSet }
+
+func (f *AffectFlags) Has(flag AffectFlag) bool { return f.IsSet(uint(flag)) }
+func (f *AffectFlags) Add(flag AffectFlag)      { f.Set(uint(flag)) }
+func (f *AffectFlags) Remove(flag AffectFlag)   { f.Clear(uint(flag)) }
+func (f AffectFlags) String() string            { return flagString(f.BitSet, affectFlagNames) }
+
+func (f AffectFlags) MarshalJSON() ([]byte, error) {
+	return marshalFlagsJSON(f.BitSet, affectFlagNames)
+}
+
+func (f AffectFlags) MarshalYAML() (interface{}, error) {
+	return flagNames(f.BitSet, affectFlagNames), nil
+}
+
+func (f *AffectFlags) UnmarshalJSON(raw []byte) (err error) {
+	f.BitSet, err = unmarshalFlagsJSON("affected", raw, affectFlagNames)
+	return err
+}
+
+func (f *AffectFlags) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	f.BitSet, err = unmarshalFlagsYAML("affected", unmarshal, affectFlagNames)
+	return err
+}
+
+func (f *AffectFlags) ToggleName(name string) (bool, error) {
+	return toggleFlagName(&f.BitSet, "affected", name, affectFlagNames)
+}
+
+// object extra flags, numbered by bit
+type ExtraFlag uint
+
+const (
+	ExtraGlow        ExtraFlag = 0
+	ExtraHum         ExtraFlag = 1
+	ExtraDark        ExtraFlag = 2
+	ExtraLock        ExtraFlag = 3
+	ExtraEvil        ExtraFlag = 4
+	ExtraInvis       ExtraFlag = 5
+	ExtraMagic       ExtraFlag = 6
+	ExtraNoDrop      ExtraFlag = 7
+	ExtraBless       ExtraFlag = 8
+	ExtraAntiGood    ExtraFlag = 9
+	ExtraAntiEvil    ExtraFlag = 10
+	ExtraAntiNeutral ExtraFlag = 11
+	ExtraNoRemove    ExtraFlag = 12
+	ExtraInventory   ExtraFlag = 13
+)
+
+var extraFlagNames = []string{
+	ExtraGlow:        "glow",
+	ExtraHum:         "hum",
+	ExtraDark:        "dark",
+	ExtraLock:        "lock",
+	ExtraEvil:        "evil",
+	ExtraInvis:       "invis",
+	ExtraMagic:       "magic",
+	ExtraNoDrop:      "nodrop",
+	ExtraBless:       "bless",
+	ExtraAntiGood:    "antigood",
+	ExtraAntiEvil:    "antievil",
+	ExtraAntiNeutral: "antineutral",
+	ExtraNoRemove:    "noremove",
+	ExtraInventory:   "inventory",
+}
+
+type ExtraFlags struct{ BitSet }
+
+func (f *ExtraFlags) Has(flag ExtraFlag) bool { return f.IsSet(uint(flag)) }
+func (f *ExtraFlags) Add(flag ExtraFlag)      { f.Set(uint(flag)) }
+func (f *ExtraFlags) Remove(flag ExtraFlag)   { f.Clear(uint(flag)) }
+func (f ExtraFlags) String() string           { return flagString(f.BitSet, extraFlagNames) }
+
+func (f ExtraFlags) MarshalJSON() ([]byte, error) {
+	return marshalFlagsJSON(f.BitSet, extraFlagNames)
+}
+
+func (f ExtraFlags) MarshalYAML() (interface{}, error) {
+	return flagNames(f.BitSet, extraFlagNames), nil
+}
+
+func (f *ExtraFlags) UnmarshalJSON(raw []byte) (err error) {
+	f.BitSet, err = unmarshalFlagsJSON("extra", raw, extraFlagNames)
+	return err
+}
+
+func (f *ExtraFlags) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	f.BitSet, err = unmarshalFlagsYAML("extra", unmarshal, extraFlagNames)
+	return err
+}
+
+func (f *ExtraFlags) ToggleName(name string) (bool, error) {
+	return toggleFlagName(&f.BitSet, "extra", name, extraFlagNames)
+}
+
+// object wear flags, numbered by bit
+type WearFlag uint
+
+const (
+	WearTake   WearFlag = 0
+	WearFinger WearFlag = 1
+	WearNeck   WearFlag = 2
+	WearBody   WearFlag = 3
+	WearHead   WearFlag = 4
+	WearLegs   WearFlag = 5
+	WearFeet   WearFlag = 6
+	WearHands  WearFlag = 7
+	WearArms   WearFlag = 8
+	WearShield WearFlag = 9
+	WearAbout  WearFlag = 10
+	WearWaist  WearFlag = 11
+	WearWrist  WearFlag = 12
+	WearWield  WearFlag = 13
+	WearHold   WearFlag = 14
+)
+
+var wearFlagNames = []string{
+	WearTake:   "take",
+	WearFinger: "finger",
+	WearNeck:   "neck",
+	WearBody:   "body",
+	WearHead:   "head",
+	WearLegs:   "legs",
+	WearFeet:   "feet",
+	WearHands:  "hands",
+	WearArms:   "arms",
+	WearShield: "shield",
+	WearAbout:  "about",
+	WearWaist:  "waist",
+	WearWrist:  "wrist",
+	WearWield:  "wield",
+	WearHold:   "hold",
+}
+
+type WearFlags struct{ BitSet }
+
+func (f *WearFlags) Has(flag WearFlag) bool { return f.IsSet(uint(flag)) }
+func (f *WearFlags) Add(flag WearFlag)      { f.Set(uint(flag)) }
+func (f *WearFlags) Remove(flag WearFlag)   { f.Clear(uint(flag)) }
+func (f WearFlags) String() string          { return flagString(f.BitSet, wearFlagNames) }
+
+func (f WearFlags) MarshalJSON() ([]byte, error) {
+	return marshalFlagsJSON(f.BitSet, wearFlagNames)
+}
+
+func (f WearFlags) MarshalYAML() (interface{}, error) {
+	return flagNames(f.BitSet, wearFlagNames), nil
+}
+
+func (f *WearFlags) UnmarshalJSON(raw []byte) (err error) {
+	f.BitSet, err = unmarshalFlagsJSON("wear", raw, wearFlagNames)
+	return err
+}
+
+func (f *WearFlags) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	f.BitSet, err = unmarshalFlagsYAML("wear", unmarshal, wearFlagNames)
+	return err
+}
+
+func (f *WearFlags) ToggleName(name string) (bool, error) {
+	return toggleFlagName(&f.BitSet, "wear", name, wearFlagNames)
+}
+
+// door states used by door resets
+const (
+	DoorOpen = iota
+	DoorClosed
+	DoorLocked
+)
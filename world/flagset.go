@@ -0,0 +1,195 @@
+package world
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/russross/meddler"
+)
+
+// Flag fields are BitSets with a name for each bit. In area files they
+// are written as lists of names, and bits with no name are written as
+// bit0, bit1, and so on. In the database they are stored in the hex form
+// produced by BitSet.String with a 0x prefix, which keeps SQLite from
+// turning them into numbers.
+
+func init() {
+	meddler.Register("flags", flagsMeddler{})
+}
+
+// ParseMercFlags parses a flag field in Merc or ROM notation: each letter
+// is one bit (A-Z are bits 0-25, a-z are bits 26 and up), and numbers are
+// taken as-is. Any of these can be joined with |. ROM's source calls bits
+// 26 and up aa, bb, and so on, and a doubled letter means the same bit.
+func ParseMercFlags(word string) (BitSet, error) {
+	var set BitSet
+	for _, part := range strings.Split(word, "|") {
+		if part == "" {
+			continue
+		}
+		if part[0] == '-' || part[0] == '+' || (part[0] >= '0' && part[0] <= '9') {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return BitSet{}, fmt.Errorf("invalid flags %q: %v", word, err)
+			}
+			if n < 0 {
+				return BitSet{}, fmt.Errorf("invalid flags %q: negative number", word)
+			}
+			set.Union(BitSetFromInt(n))
+			continue
+		}
+		for _, ch := range part {
+			switch {
+			case ch >= 'A' && ch <= 'Z':
+				set.Set(uint(ch - 'A'))
+			case ch >= 'a' && ch <= 'z':
+				set.Set(uint(26 + ch - 'a'))
+			default:
+				return BitSet{}, fmt.Errorf("invalid flag letter %q in %q", ch, word)
+			}
+		}
+	}
+	return set, nil
+}
+
+func flagNames(set BitSet, names []string) []string {
+	list := []string{}
+	for _, i := range set.Members() {
+		if i < uint(len(names)) && names[i] != "" {
+			list = append(list, names[i])
+		} else {
+			list = append(list, fmt.Sprintf("bit%d", i))
+		}
+	}
+	return list
+}
+
+func flagString(set BitSet, names []string) string {
+	return strings.Join(flagNames(set, names), " ")
+}
+
+func parseFlagNames(kind string, list []string, names []string) (BitSet, error) {
+	var set BitSet
+outer:
+	for _, name := range list {
+		for i, elt := range names {
+			if elt != "" && strings.EqualFold(elt, name) {
+				set.Set(uint(i))
+				continue outer
+			}
+		}
+		if strings.HasPrefix(name, "bit") {
+			if n, err := strconv.ParseUint(name[3:], 10, 16); err == nil {
+				set.Set(uint(n))
+				continue
+			}
+		}
+		return BitSet{}, fmt.Errorf("unknown %s flag %q", kind, name)
+	}
+	return set, nil
+}
+
+// toggleFlagName turns a flag on or off by name and reports whether it is
+// now on
+func toggleFlagName(set *BitSet, kind, name string, names []string) (bool, error) {
+	flag, err := parseFlagNames(kind, []string{name}, names)
+	if err != nil {
+		return false, err
+	}
+	bit := flag.Members()[0]
+	if set.IsSet(bit) {
+		set.Clear(bit)
+		return false, nil
+	}
+	set.Set(bit)
+	return true, nil
+}
+
+func marshalFlagsJSON(set BitSet, names []string) ([]byte, error) {
+	return json.Marshal(flagNames(set, names))
+}
+
+// unmarshalFlagsJSON accepts a list of names or a plain number
+func unmarshalFlagsJSON(kind string, raw []byte, names []string) (BitSet, error) {
+	var n int
+	if err := json.Unmarshal(raw, &n); err == nil {
+		if n < 0 {
+			return BitSet{}, fmt.Errorf("%s flags cannot be negative", kind)
+		}
+		return BitSetFromInt(n), nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return BitSet{}, fmt.Errorf("%s flags must be a list of names: %v", kind, err)
+	}
+	return parseFlagNames(kind, list, names)
+}
+
+// unmarshalFlagsYAML accepts a list of names or a plain number
+func unmarshalFlagsYAML(kind string, unmarshal func(interface{}) error, names []string) (BitSet, error) {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		if n < 0 {
+			return BitSet{}, fmt.Errorf("%s flags cannot be negative", kind)
+		}
+		return BitSetFromInt(n), nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return BitSet{}, fmt.Errorf("%s flags must be a list of names: %v", kind, err)
+	}
+	return parseFlagNames(kind, list, names)
+}
+
+// these are promoted to every flag type that embeds a BitSet, which lets
+// flagsMeddler handle all of them
+func (set *BitSet) bitSetAddr() *BitSet { return set }
+func (set BitSet) bitSetValue() BitSet  { return set }
+
+// flagsMeddler stores flag fields as hex strings, and also reads the
+// plain integers written by older versions
+type flagsMeddler struct{}
+
+func (flagsMeddler) PreRead(fieldAddr interface{}) (interface{}, error) {
+	return new(interface{}), nil
+}
+
+func (flagsMeddler) PostRead(fieldAddr, scanTarget interface{}) error {
+	field, ok := fieldAddr.(interface{ bitSetAddr() *BitSet })
+	if !ok {
+		return fmt.Errorf("flags meddler used on %T, which is not a flag type", fieldAddr)
+	}
+	set := field.bitSetAddr()
+	switch value := (*scanTarget.(*interface{})).(type) {
+	case nil:
+		*set = BitSet{}
+	case int64:
+		*set = BitSetFromInt(int(value))
+	case []byte:
+		parsed, err := ParseBitSet(string(value))
+		if err != nil {
+			return err
+		}
+		*set = parsed
+	case string:
+		parsed, err := ParseBitSet(value)
+		if err != nil {
+			return err
+		}
+		*set = parsed
+	default:
+		return fmt.Errorf("flags meddler cannot read %T", value)
+	}
+	return nil
+}
+
+func (flagsMeddler) PreWrite(field interface{}) (interface{}, error) {
+	elt, ok := field.(interface{ bitSetValue() BitSet })
+	if !ok {
+		return nil, fmt.Errorf("flags meddler used on %T, which is not a flag type", field)
+	}
+	set := elt.bitSetValue()
+	return "0x" + set.String(), nil
+}
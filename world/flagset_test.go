@@ -0,0 +1,184 @@
+package world
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseMercFlags(t *testing.T) {
+	tests := []struct {
+		word    string
+		members []uint
+		bad     bool
+	}{
+		{"0", nil, false},
+		{"1", []uint{0}, false},
+		{"1|2|64", []uint{0, 1, 6}, false},
+		{"A", []uint{0}, false},
+		{"ABG", []uint{0, 1, 6}, false},
+		{"Z", []uint{25}, false},
+		{"a", []uint{26}, false},
+		{"e", []uint{30}, false},
+		{"z", []uint{51}, false},
+		{"aa", []uint{26}, false},
+		{"ee", []uint{30}, false},
+		{"Bbb", []uint{1, 27}, false},
+		{"AZaz", []uint{0, 25, 26, 51}, false},
+		{"A|8", []uint{0, 3}, false},
+		{"ABC|d|16", []uint{0, 1, 2, 4, 29}, false},
+		{"+4", []uint{2}, false},
+		{"||", nil, false},
+		{"-1", nil, true},
+		{"A1", nil, true},
+		{"A-B", nil, true},
+		{"1x", nil, true},
+	}
+	for _, test := range tests {
+		set, err := ParseMercFlags(test.word)
+		if test.bad {
+			if err == nil {
+				t.Errorf("ParseMercFlags(%q) gave %v, expected an error", test.word, set.Members())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMercFlags(%q): %v", test.word, err)
+		} else if got := set.Members(); !reflect.DeepEqual(got, test.members) {
+			t.Errorf("ParseMercFlags(%q) gave %v, expected %v", test.word, got, test.members)
+		}
+	}
+}
+
+func TestFlagsString(t *testing.T) {
+	flags := ActFlags{bits(uint(ActSentinel), uint(ActAggressive), 40)}
+	if got := flags.String(); got != "sentinel aggressive bit40" {
+		t.Errorf("String gave %q", got)
+	}
+	if got := (RoomFlags{}).String(); got != "" {
+		t.Errorf("empty flags gave %q", got)
+	}
+}
+
+// flagsHolder has one of each flag type, as they appear in area files
+type flagsHolder struct {
+	Room   RoomFlags   `json:"room" yaml:"room,flow"`
+	Act    ActFlags    `json:"act" yaml:"act,flow"`
+	Affect AffectFlags `json:"affect" yaml:"affect,flow"`
+	Extra  ExtraFlags  `json:"extra" yaml:"extra,flow"`
+	Wear   WearFlags   `json:"wear" yaml:"wear,flow"`
+}
+
+func testFlags() flagsHolder {
+	return flagsHolder{
+		Room:   RoomFlags{bits(uint(RoomDark), uint(RoomNoRecall))},
+		Act:    ActFlags{bits(uint(ActIsNPC), uint(ActSentinel), 40)},
+		Affect: AffectFlags{bits(uint(AffSanctuary), 63, 64)},
+		Extra:  ExtraFlags{bits(uint(ExtraGlow), uint(ExtraInventory))},
+		Wear:   WearFlags{},
+	}
+}
+
+func checkFlags(t *testing.T, format string, got, expected flagsHolder) {
+	t.Helper()
+	pairs := []struct {
+		name string
+		a, b BitSet
+	}{
+		{"room", got.Room.BitSet, expected.Room.BitSet},
+		{"act", got.Act.BitSet, expected.Act.BitSet},
+		{"affect", got.Affect.BitSet, expected.Affect.BitSet},
+		{"extra", got.Extra.BitSet, expected.Extra.BitSet},
+		{"wear", got.Wear.BitSet, expected.Wear.BitSet},
+	}
+	for _, pair := range pairs {
+		if !pair.a.Equal(pair.b) {
+			t.Errorf("%s %s flags gave %v, expected %v", format, pair.name, pair.a.Members(), pair.b.Members())
+		}
+	}
+}
+
+func TestFlagsJSON(t *testing.T) {
+	flags := testFlags()
+	raw, err := json.Marshal(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"room":["dark","norecall"],"act":["npc","sentinel","bit40"],` +
+		`"affect":["sanctuary","bit63","bit64"],"extra":["glow","inventory"],"wear":[]}`
+	if string(raw) != expected {
+		t.Errorf("JSON gave %s\nexpected %s", raw, expected)
+	}
+	var back flagsHolder
+	if err := json.Unmarshal(raw, &back); err != nil {
+		t.Fatal(err)
+	}
+	checkFlags(t, "JSON", back, flags)
+
+	// older files have numbers, and names are not case sensitive
+	var old flagsHolder
+	if err := json.Unmarshal([]byte(`{"room":8193,"act":["NPC","Sentinel","bit40"],"affect":0}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	checkFlags(t, "JSON", old, flagsHolder{
+		Room: flags.Room,
+		Act:  flags.Act,
+	})
+
+	for _, bad := range []string{`{"room":["nosuchflag"]}`, `{"room":-1}`, `{"room":"dark"}`, `{"room":["bitx"]}`} {
+		if err := json.Unmarshal([]byte(bad), new(flagsHolder)); err == nil {
+			t.Errorf("%s gave no error", bad)
+		}
+	}
+}
+
+func TestFlagsYAML(t *testing.T) {
+	flags := testFlags()
+	raw, err := yaml.Marshal(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "room: [dark, norecall]\nact: [npc, sentinel, bit40]\n" +
+		"affect: [sanctuary, bit63, bit64]\nextra: [glow, inventory]\nwear: []\n"
+	if string(raw) != expected {
+		t.Errorf("YAML gave %s\nexpected %s", raw, expected)
+	}
+	var back flagsHolder
+	if err := yaml.UnmarshalStrict(raw, &back); err != nil {
+		t.Fatal(err)
+	}
+	checkFlags(t, "YAML", back, flags)
+
+	var old flagsHolder
+	if err := yaml.UnmarshalStrict([]byte("room: 8193\nact: [NPC, Sentinel, bit40]\n"), &old); err != nil {
+		t.Fatal(err)
+	}
+	checkFlags(t, "YAML", old, flagsHolder{
+		Room: flags.Room,
+		Act:  flags.Act,
+	})
+
+	for _, bad := range []string{"room: [nosuchflag]\n", "room: -1\n", "room: dark\n"} {
+		if err := yaml.UnmarshalStrict([]byte(bad), new(flagsHolder)); err == nil {
+			t.Errorf("%q gave no error", bad)
+		}
+	}
+}
+
+func TestToggleName(t *testing.T) {
+	var flags RoomFlags
+	if on, err := flags.ToggleName("Dark"); err != nil || !on || !flags.Has(RoomDark) {
+		t.Errorf("turning dark on gave %v, %v", on, err)
+	}
+	if on, err := flags.ToggleName("dark"); err != nil || on || flags.Has(RoomDark) {
+		t.Errorf("turning dark off gave %v, %v", on, err)
+	}
+	if on, err := flags.ToggleName("bit33"); err != nil || !on || !flags.IsSet(33) {
+		t.Errorf("turning bit33 on gave %v, %v", on, err)
+	}
+	if _, err := flags.ToggleName("nosuchflag"); err == nil {
+		t.Errorf("toggling an unknown flag gave no error")
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+// wsbench measures the bandwidth and latency of the game websocket. Guest
+// players walk back and forth in bursts of moves, each followed by a
+// ping. The pong is queued behind everything the moves produced, so the
+// round trip covers the whole burst.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var directions = map[string]string{
+	"n": "north", "e": "east", "s": "south", "w": "west", "u": "up", "d": "down",
+}
+
+var reverse = map[string]string{
+	"n": "s", "e": "w", "s": "n", "w": "e", "u": "d", "d": "u",
+}
+
+type message struct {
+	Type string          `json:"type"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+type roomData struct {
+	Num   int            `json:"num"`
+	Exits map[string]int `json:"exits"`
+}
+
+type pongData struct {
+	ID string `json:"id"`
+}
+
+// mode is one way of connecting
+type mode struct {
+	name     string
+	compress bool
+	batch    bool
+}
+
+var modes = map[string]mode{
+	"plain":         {"plain", false, false},
+	"batch":         {"batch", false, true},
+	"deflate":       {"deflate", true, false},
+	"deflate+batch": {"deflate+batch", true, true},
+}
+
+// counters for one run, shared by its clients
+type result struct {
+	sync.Mutex
+	wireIn    int64
+	payload   int64
+	frames    int
+	messages  int
+	moves     int
+	roundTrip []time.Duration
+}
+
+// countingConn counts the bytes received on the wire, after compression
+type countingConn struct {
+	net.Conn
+	in *int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.in, int64(n))
+	return n, err
+}
+
+type client struct {
+	conn     *websocket.Conn
+	incoming chan *message
+	errors   chan error
+
+	// counted from the start, and reset once the handshake is done
+	wireIn   int64
+	payload  int64
+	frames   int64
+	messages int64
+}
+
+func dial(url string, m mode) (*client, error) {
+	c := &client{incoming: make(chan *message, 1000), errors: make(chan error, 1)}
+	dialer := websocket.Dialer{
+		EnableCompression: m.compress,
+		HandshakeTimeout:  10 * time.Second,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &countingConn{Conn: conn, in: &c.wireIn}, nil
+		},
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.read()
+	return c, nil
+}
+
+// read passes along messages from the server, unpacking batches
+func (c *client) read() {
+	for {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			c.errors <- err
+			return
+		}
+		atomic.AddInt64(&c.frames, 1)
+		atomic.AddInt64(&c.payload, int64(len(raw)))
+		var batch []*message
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			err = json.Unmarshal(raw, &batch)
+		} else {
+			msg := new(message)
+			err = json.Unmarshal(raw, msg)
+			batch = append(batch, msg)
+		}
+		if err != nil {
+			c.errors <- fmt.Errorf("bad message %q: %v", raw, err)
+			return
+		}
+		atomic.AddInt64(&c.messages, int64(len(batch)))
+		for _, msg := range batch {
+			c.incoming <- msg
+		}
+	}
+}
+
+// next waits for a message from the server
+func (c *client) next() (*message, error) {
+	select {
+	case msg := <-c.incoming:
+		return msg, nil
+	case err := <-c.errors:
+		return nil, err
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("timed out waiting for the server")
+	}
+}
+
+// waitFor reads until the pong with the given id, keeping track of the
+// room the player is in
+func (c *client) waitFor(id string, room *roomData) error {
+	for {
+		msg, err := c.next()
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "room":
+			if err := json.Unmarshal(msg.Data, room); err != nil {
+				return err
+			}
+		case "pong":
+			var pong pongData
+			if err := json.Unmarshal(msg.Data, &pong); err != nil {
+				return err
+			}
+			if pong.ID == id {
+				return nil
+			}
+		case "error":
+			log.Printf("server: %s", strings.TrimSpace(msg.Msg))
+		}
+	}
+}
+
+func (c *client) send(req interface{}) error {
+	return c.conn.WriteJSON(req)
+}
+
+// walk plays one guest for the given number of moves
+func walk(url string, m mode, moves, burst, size int, rate float64, seed int64, res *result) error {
+	c, err := dial(url, m)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	// the handshake
+	msg, err := c.next()
+	if err != nil {
+		return err
+	}
+	if msg.Type != "hello" {
+		return fmt.Errorf("expected a hello, got %q", msg.Type)
+	}
+	capabilities := []string{"data", "ping", "resize"}
+	if m.batch {
+		capabilities = append(capabilities, "batch")
+	}
+	if err := c.send(map[string]interface{}{"type": "hello", "protocol": 1, "capabilities": capabilities}); err != nil {
+		return err
+	}
+	if size > 0 {
+		if err := c.send(map[string]interface{}{"type": "resize", "width": size, "height": size}); err != nil {
+			return err
+		}
+	}
+	if err := c.send(map[string]string{"type": "cmd", "cmd": "look"}); err != nil {
+		return err
+	}
+	if err := c.send(map[string]string{"type": "ping", "id": "ready"}); err != nil {
+		return err
+	}
+	var room roomData
+	if err := c.waitFor("ready", &room); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.wireIn, 0)
+	atomic.StoreInt64(&c.payload, 0)
+	atomic.StoreInt64(&c.frames, 0)
+	atomic.StoreInt64(&c.messages, 0)
+
+	rng := rand.New(rand.NewSource(seed))
+	var roundTrips []time.Duration
+	done := 0
+	for round := 0; done < moves; round++ {
+		// go back and forth through one of the exits
+		var exits []string
+		for dir := range room.Exits {
+			exits = append(exits, dir)
+		}
+		if len(exits) == 0 {
+			return fmt.Errorf("room %d has no exits", room.Num)
+		}
+		sort.Strings(exits)
+		dir := exits[rng.Intn(len(exits))]
+
+		start := time.Now()
+		count := 0
+		for ; count < burst && done+count < moves; count++ {
+			if err := c.send(map[string]string{"type": "cmd", "cmd": directions[dir]}); err != nil {
+				return err
+			}
+			dir = reverse[dir]
+		}
+		id := fmt.Sprintf("round %d", round)
+		if err := c.send(map[string]string{"type": "ping", "id": id}); err != nil {
+			return err
+		}
+		if err := c.waitFor(id, &room); err != nil {
+			return err
+		}
+		roundTrips = append(roundTrips, time.Since(start))
+		done += count
+
+		// stay under the server's command rate limit
+		pause := time.Duration(float64(time.Second)*float64(count+1)/rate) - time.Since(start)
+		time.Sleep(pause)
+	}
+
+	res.Lock()
+	defer res.Unlock()
+	res.wireIn += atomic.LoadInt64(&c.wireIn)
+	res.payload += atomic.LoadInt64(&c.payload)
+	res.frames += int(atomic.LoadInt64(&c.frames))
+	res.messages += int(atomic.LoadInt64(&c.messages))
+	res.moves += done
+	res.roundTrip = append(res.roundTrip, roundTrips...)
+	return nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/server", "game websocket to connect to")
+	modeList := flag.String("modes", "plain,batch,deflate,deflate+batch", "connection modes to compare")
+	moves := flag.Int("moves", 100, "moves for each player")
+	burst := flag.Int("burst", 5, "moves sent at once before waiting for the results")
+	clients := flag.Int("clients", 1, "players walking at the same time")
+	size := flag.Int("size", 0, "map size in characters to ask for, or 0 for the default")
+	rate := flag.Float64("rate", 9, "requests per second per player, to stay under the server's limit")
+	seed := flag.Int64("seed", 1, "random seed for choosing exits")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *burst < 1 || *burst > 25 {
+		log.Fatalf("burst must be between 1 and 25 to stay under the server's limit")
+	}
+
+	fmt.Printf("%d player(s), %d moves each in bursts of %d\n\n", *clients, *moves, *burst)
+	fmt.Printf("%-14s %7s %9s %10s %9s %10s %6s %9s %9s %9s\n",
+		"mode", "frames", "messages", "wire in", "per move", "payload", "ratio", "median", "p95", "max")
+	for _, name := range strings.Split(*modeList, ",") {
+		m, present := modes[strings.TrimSpace(name)]
+		if !present {
+			log.Fatalf("unknown mode %q", name)
+		}
+
+		res := new(result)
+		var wg sync.WaitGroup
+		errs := make(chan error, *clients)
+		for i := 0; i < *clients; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := walk(*url, m, *moves, *burst, *size, *rate, *seed+int64(i), res); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			log.Fatalf("%s: %v", m.name, err)
+		}
+
+		sort.Slice(res.roundTrip, func(i, j int) bool { return res.roundTrip[i] < res.roundTrip[j] })
+		ratio := 0.0
+		if res.wireIn > 0 {
+			ratio = float64(res.payload) / float64(res.wireIn)
+		}
+		fmt.Printf("%-14s %7d %9d %10d %9d %10d %6.2f %9s %9s %9s\n",
+			m.name, res.frames, res.messages, res.wireIn, res.wireIn/int64(res.moves), res.payload, ratio,
+			ms(percentile(res.roundTrip, 0.5)), ms(percentile(res.roundTrip, 0.95)), ms(percentile(res.roundTrip, 1)))
+	}
+}